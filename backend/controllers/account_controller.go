@@ -0,0 +1,395 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"swamp/database"
+	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/mail"
+	"swamp/pkg/webrtc"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	maxBioLength       = 500
+	maxAvatarURLLength = 2048
+	deletedUserName    = "Deleted user"
+	deletedSwampTitle  = "Deleted swamp"
+)
+
+// GetMe GET /api/me
+func GetMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profileResponse(user))
+}
+
+// UpdateMe PATCH /api/me
+// Only the fields present in the body are changed; send "" to clear one.
+func UpdateMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		FullName  *string `json:"fullName"`
+		Bio       *string `json:"bio"`
+		AvatarURL *string `json:"avatarUrl"`
+		Timezone  *string `json:"timezone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if request.FullName != nil {
+		name := strings.TrimSpace(*request.FullName)
+		if len(name) < 2 || len(name) > 100 {
+			http.Error(w, `{"error": "Full name must be between 2 and 100 characters"}`, http.StatusBadRequest)
+			return
+		}
+		updates["full_name"] = name
+	}
+	if request.Bio != nil {
+		bio := strings.TrimSpace(*request.Bio)
+		if len([]rune(bio)) > maxBioLength {
+			http.Error(w, fmt.Sprintf(`{"error": "Bio must be at most %d characters"}`, maxBioLength), http.StatusBadRequest)
+			return
+		}
+		updates["bio"] = bio
+	}
+	if request.AvatarURL != nil {
+		avatar := strings.TrimSpace(*request.AvatarURL)
+		if avatar != "" && !validAvatarURL(avatar) {
+			http.Error(w, `{"error": "Avatar URL must be an absolute http(s) URL"}`, http.StatusBadRequest)
+			return
+		}
+		updates["avatar_url"] = avatar
+	}
+	if request.Timezone != nil {
+		timezone := strings.TrimSpace(*request.Timezone)
+		if timezone != "" {
+			if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+				http.Error(w, `{"error": "Unknown timezone"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		updates["timezone"] = timezone
+	}
+
+	if len(updates) > 0 {
+		if err := database.DB.Model(user).Updates(updates).Error; err != nil {
+			http.Error(w, `{"error": "Failed to update profile"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profileResponse(user))
+}
+
+// RequestEmailChange POST /api/me/email
+// Mails a code to the new address. The email only changes once that code is
+// confirmed through ConfirmEmailChange.
+func RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		NewEmail string `json:"newEmail"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.NewEmail == "" {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+	if !checkCurrentPassword(user, request.Password) {
+		http.Error(w, `{"error": "Invalid password"}`, http.StatusUnauthorized)
+		return
+	}
+	if strings.EqualFold(request.NewEmail, user.Email) {
+		http.Error(w, `{"error": "This is already your email address"}`, http.StatusBadRequest)
+		return
+	}
+	if emailRegistered(request.NewEmail) {
+		http.Error(w, `{"error": "An account with this email already exists"}`, http.StatusConflict)
+		return
+	}
+
+	if !issueOTP(w, r, request.NewEmail, models.OTPPurposeEmailChange, mail.EmailChangeMessage) {
+		return
+	}
+	if err := database.DB.Model(user).Update("pending_email", request.NewEmail).Error; err != nil {
+		http.Error(w, `{"error": "Failed to start email change"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "A confirmation code has been sent to the new address",
+		"newEmail": request.NewEmail,
+	})
+}
+
+// ConfirmEmailChange POST /api/me/email/verify
+func ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		OTP string `json:"otp"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.OTP == "" {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+	if user.PendingEmail == "" {
+		http.Error(w, `{"error": "No email change is pending"}`, http.StatusBadRequest)
+		return
+	}
+
+	otp, err := checkOTP(user.PendingEmail, models.OTPPurposeEmailChange, request.OTP)
+	if err != nil {
+		respondOTPError(w, otp, err)
+		return
+	}
+	if emailRegistered(user.PendingEmail) {
+		http.Error(w, `{"error": "An account with this email already exists"}`, http.StatusConflict)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"email":         user.PendingEmail,
+			"pending_email": "",
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(otp).Error
+	})
+	if err != nil {
+		http.Error(w, `{"error": "Failed to change email"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profileResponse(user))
+}
+
+// ChangePassword POST /api/me/password
+// Requires the current password. Every other session is signed out and the
+// caller gets a fresh one.
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.CurrentPassword == "" || request.NewPassword == "" {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+	if !checkCurrentPassword(user, request.CurrentPassword) {
+		http.Error(w, `{"error": "Current password is incorrect"}`, http.StatusUnauthorized)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to process password"}`, http.StatusInternalServerError)
+		return
+	}
+
+	var response map[string]interface{}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		if err := revokeUserSessions(tx, user.ID); err != nil {
+			return err
+		}
+		if err := tx.First(user, user.ID).Error; err != nil {
+			return err
+		}
+
+		var err error
+		response, err = buildSession(tx, r, "Password changed", *user, "")
+		return err
+	})
+	if err != nil {
+		http.Error(w, `{"error": "Failed to change password"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteMe DELETE /api/me
+// Soft-deletes the account after checking the password. Personal data is
+// scrubbed from the user row, chat history is detached from the user,
+// upcoming swamps they own are cancelled, live rooms drop them and any data
+// export archives are removed.
+func DeleteMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+	if !checkCurrentPassword(user, request.Password) {
+		http.Error(w, `{"error": "Invalid password"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return anonymizeUser(tx, user)
+	}); err != nil {
+		http.Error(w, `{"error": "Failed to delete account"}`, http.StatusInternalServerError)
+		return
+	}
+
+	disconnectUser(user.ID)
+	removeDataExports(user.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// anonymizeUser removes everything that identifies the user, including what
+// they wrote in chat and swamp titles, and soft-deletes the row. Past swamps
+// keep pointing at it so they show a "Deleted user".
+func anonymizeUser(tx *gorm.DB, user *models.User) error {
+	if err := revokeUserSessions(tx, user.ID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := tx.Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	for _, owned := range []interface{}{&models.RecoveryCode{}, &models.UserIdentity{}, &models.UserTopic{}, &models.SwampMember{}, &models.SwampInvitation{}, &models.SwampRSVP{}, &models.CalendarFeed{}} {
+		if err := tx.Where("user_id = ?", user.ID).Delete(owned).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Where("email = ?", strings.ToLower(user.Email)).Delete(&models.SwampInvitation{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("email IN ?", []string{user.Email, user.PendingEmail}).Delete(&models.OTP{}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&models.ChatMessage{}).Where("user_id = ?", user.ID).
+		Updates(map[string]interface{}{"user_id": nil, "author_name": deletedUserName, "text": ""}).Error; err != nil {
+		return err
+	}
+	// Series end with their owner even while an occurrence is running
+	if err := tx.Model(&models.Swamp{}).Where("owner_id = ?", user.ID).
+		Where("start_time > ? OR (status <> ? AND id IN (SELECT swamp_id FROM swamp_recurrences))", now, models.SwampStatusEnded).
+		Updates(map[string]interface{}{"deleted": true, "status": models.SwampStatusCancelled}).Error; err != nil {
+		return err
+	}
+	// Past swamps stay for their participants, but without the owner's words
+	if err := tx.Model(&models.Swamp{}).Where("owner_id = ?", user.ID).
+		Update("title", deletedSwampTitle).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(user).Updates(map[string]interface{}{
+		"full_name":           deletedUserName,
+		"email":               fmt.Sprintf("deleted-%d@deleted.invalid", user.ID),
+		"password":            "",
+		"bio":                 "",
+		"avatar_url":          "",
+		"timezone":            "",
+		"pending_email":       "",
+		"role":                models.RoleUser,
+		"totp_enabled":        false,
+		"totp_secret":         "",
+		"totp_pending_secret": "",
+	}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(user).Error
+}
+
+// disconnectUser drops a user from every live room.
+func disconnectUser(userID uint) {
+	webrtc.RoomsLock.RLock()
+	rooms := make([]*webrtc.Room, 0, len(webrtc.Rooms))
+	for _, room := range webrtc.Rooms {
+		rooms = append(rooms, room)
+	}
+	webrtc.RoomsLock.RUnlock()
+
+	for _, room := range rooms {
+		room.Kick(userID)
+	}
+}
+
+// checkCurrentPassword verifies password against the stored hash. Accounts
+// created through SSO have no password and must set one via reset first.
+func checkCurrentPassword(user *models.User, password string) bool {
+	if user.Password == "" || password == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+}
+
+func validAvatarURL(raw string) bool {
+	if len(raw) > maxAvatarURLLength {
+		return false
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// profileResponse is the caller's own view of their account.
+func profileResponse(user *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":               user.ID,
+		"email":            user.Email,
+		"pendingEmail":     user.PendingEmail,
+		"fullName":         user.FullName,
+		"bio":              user.Bio,
+		"avatarUrl":        user.AvatarURL,
+		"timezone":         user.Timezone,
+		"role":             user.Role,
+		"twoFactorEnabled": user.TOTPEnabled,
+		"createdAt":        user.CreatedAt,
+	}
+}
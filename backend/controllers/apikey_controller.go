@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"swamp/database"
+	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/auth"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxActiveAPIKeys caps how many usable keys one account can hold.
+const maxActiveAPIKeys = 20
+
+// CreateAPIKey POST /api/me/api-keys
+// Returns the key itself exactly once; only its hash is stored.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(request.Scopes) == 0 {
+		http.Error(w, `{"error": "Name and at least one scope are required"}`, http.StatusBadRequest)
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !models.ValidAPIScope(scope) {
+			http.Error(w, `{"error": "Unknown scope"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		http.Error(w, `{"error": "Expiry must be in the future"}`, http.StatusBadRequest)
+		return
+	}
+
+	var active int64
+	database.DB.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", user.ID, time.Now()).
+		Count(&active)
+	if active >= maxActiveAPIKeys {
+		http.Error(w, `{"error": "Too many active API keys; revoke one first"}`, http.StatusConflict)
+		return
+	}
+
+	key, hash, prefix, err := auth.NewAPIKey()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to generate API key"}`, http.StatusInternalServerError)
+		return
+	}
+
+	record := models.APIKey{
+		UserID:    user.ID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    strings.Join(uniqueStrings(request.Scopes), " "),
+		ExpiresAt: request.ExpiresAt,
+	}
+	if err := database.DB.Create(&record).Error; err != nil {
+		http.Error(w, `{"error": "Failed to create API key"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Store this key now; it will not be shown again",
+		"key":     key,
+		"apiKey":  apiKeyResponse(record),
+	})
+}
+
+// ListAPIKeys GET /api/me/api-keys
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var keys []models.APIKey
+	database.DB.Where("user_id = ?", user.ID).Order("created_at DESC").Find(&keys)
+
+	response := make([]map[string]interface{}, len(keys))
+	for i, key := range keys {
+		response[i] = apiKeyResponse(key)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"apiKeys": response})
+}
+
+// RevokeAPIKey DELETE /api/me/api-keys/{id}
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	keyID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "API key not found"}`, http.StatusNotFound)
+		return
+	}
+
+	var key models.APIKey
+	if err := database.DB.Where("id = ? AND user_id = ?", keyID, user.ID).First(&key).Error; err != nil {
+		http.Error(w, `{"error": "API key not found"}`, http.StatusNotFound)
+		return
+	}
+	if key.RevokedAt == nil {
+		if err := database.DB.Model(&key).Update("revoked_at", time.Now()).Error; err != nil {
+			http.Error(w, `{"error": "Failed to revoke API key"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiKeyResponse is the public view of a key; the hash never leaves the server.
+func apiKeyResponse(key models.APIKey) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         key.ID,
+		"name":       key.Name,
+		"prefix":     key.Prefix,
+		"scopes":     key.ScopeList(),
+		"expiresAt":  key.ExpiresAt,
+		"lastUsedAt": key.LastUsedAt,
+		"revokedAt":  key.RevokedAt,
+		"createdAt":  key.CreatedAt,
+		"active":     key.Active(),
+	}
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
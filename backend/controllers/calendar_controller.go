@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"swamp/database"
+	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/auth"
+	"swamp/pkg/ical"
+	"swamp/pkg/policy"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// calendarFeedHistory is how far back the feed keeps one-off swamps.
+	calendarFeedHistory = 30 * 24 * time.Hour
+	// maxCalendarFeedSwamps keeps feeds of very broad topic preferences small.
+	maxCalendarFeedSwamps = 500
+)
+
+// RSVPSwamp PUT /api/swamp/{id}/rsvp
+// Marks the caller as attending; the swamp then shows up in their calendar
+// feed. Saying yes twice is harmless.
+func RSVPSwamp(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+	swamp, ok := visibleSwamp(w, r)
+	if !ok {
+		return
+	}
+	if swamp.Finished() {
+		http.Error(w, `{"error": "Swamp has already ended"}`, http.StatusConflict)
+		return
+	}
+
+	rsvp := models.SwampRSVP{SwampID: uint(swamp.ID), UserID: user.ID}
+	if err := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&rsvp).Error; err != nil {
+		http.Error(w, `{"error": "Failed to save RSVP"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "RSVP saved"})
+}
+
+// CancelRSVP DELETE /api/swamp/{id}/rsvp
+func CancelRSVP(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+	swamp, ok := swampFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Where("swamp_id = ? AND user_id = ?", swamp.ID, user.ID).Delete(&models.SwampRSVP{}).Error; err != nil {
+		http.Error(w, `{"error": "Failed to cancel RSVP"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSwampCalendar GET /api/swamp/{id}/calendar.ics
+// Downloads the swamp as an iCalendar file; a recurring swamp comes with
+// its rule, cancelled occurrences and moved ones.
+func GetSwampCalendar(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := visibleSwamp(w, r)
+	if !ok {
+		return
+	}
+
+	swamps := []models.Swamp{*swamp}
+	calendar, err := swampCalendar(database.DB, swamp.Title, swamps)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to build calendar"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="swamp-%d.ics"`, swamp.ID))
+	calendar.Encode(w)
+}
+
+// GetCalendarFeed GET /api/me/calendar-feed
+func GetCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+
+	var feed models.CalendarFeed
+	enabled := database.DB.Where("user_id = ?", user.ID).First(&feed).Error == nil
+
+	response := map[string]interface{}{"enabled": enabled}
+	if enabled {
+		response["createdAt"] = feed.CreatedAt
+		response["lastUsedAt"] = feed.LastUsedAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// CreateCalendarFeed POST /api/me/calendar-feed
+// Creates the caller's feed URL, or rotates it so the old one stops
+// working. The URL carries the secret token and is shown only once.
+func CreateCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to create calendar feed"}`, http.StatusInternalServerError)
+		return
+	}
+	feed := models.CalendarFeed{UserID: user.ID, TokenHash: hash, CreatedAt: time.Now()}
+	if err := database.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&feed).Error; err != nil {
+		http.Error(w, `{"error": "Failed to create calendar feed"}`, http.StatusInternalServerError)
+		return
+	}
+
+	feedPath := "/api/calendar/" + token + ".ics"
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Subscribe to this URL now; it will not be shown again",
+		"feedPath":  feedPath,
+		"feedUrl":   scheme + "://" + r.Host + feedPath,
+		"webcalUrl": "webcal://" + r.Host + feedPath,
+	})
+}
+
+// DeleteCalendarFeed DELETE /api/me/calendar-feed
+func DeleteCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+
+	if err := database.DB.Where("user_id = ?", user.ID).Delete(&models.CalendarFeed{}).Error; err != nil {
+		http.Error(w, `{"error": "Failed to delete calendar feed"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CalendarFeed GET /api/calendar/{token}.ics
+// The subscribable feed. Calendar apps cannot sign in, so the token in the
+// URL is the credential. It lists swamps the user owns or co-hosts, has
+// RSVP'd to, or that match their topics, as long as they may still see
+// them; unlisted swamps only show up once RSVP'd to. Upcoming swamps and
+// series come first, then the ones that started in the last 30 days while
+// there is room.
+func CalendarFeed(w http.ResponseWriter, r *http.Request) {
+	var feed models.CalendarFeed
+	if err := database.DB.Where("token_hash = ?", auth.HashToken(chi.URLParam(r, "token"))).First(&feed).Error; err != nil {
+		http.Error(w, `{"error": "Calendar feed not found"}`, http.StatusNotFound)
+		return
+	}
+	var user models.User
+	if err := database.DB.First(&user, feed.UserID).Error; err != nil {
+		http.Error(w, `{"error": "Calendar feed not found"}`, http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	swamps, err := calendarFeedSwamps(database.DB, &user, now)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to build calendar"}`, http.StatusInternalServerError)
+		return
+	}
+	calendar, err := swampCalendar(database.DB, "Swamp", swamps)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to build calendar"}`, http.StatusInternalServerError)
+		return
+	}
+	database.DB.Model(&feed).Update("last_used_at", now)
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	calendar.Encode(w)
+}
+
+// calendarFeedSwamps picks the swamps for user's feed, at most
+// maxCalendarFeedSwamps of them, so that past ones never crowd out what
+// is coming up.
+func calendarFeedSwamps(db *gorm.DB, user *models.User, now time.Time) ([]models.Swamp, error) {
+	rsvps := db.Model(&models.SwampRSVP{}).Select("swamp_id").Where("user_id = ?", user.ID)
+	// Having RSVP'd to an unlisted swamp means having had its link
+	visible := policy.VisibleSwamps(policy.SubjectOf(user))(db.Session(&gorm.Session{NewDB: true})).
+		Or("visibility = ? AND id IN (?)", models.SwampVisibilityUnlisted, rsvps)
+	series := "status <> ? AND id IN (SELECT swamp_id FROM swamp_recurrences)"
+	base := func() *gorm.DB {
+		return db.Where("deleted = ? AND status <> ?", false, models.SwampStatusCancelled).
+			Where(visible).
+			Where("owner_id = ? OR id IN (SELECT swamp_id FROM swamp_members WHERE user_id = ?) OR "+
+				"id IN (?) OR "+
+				"topic_id IN (SELECT topic_id FROM user_topics WHERE user_id = ?) OR "+
+				"id IN (SELECT swamp_id FROM swamp_topics WHERE topic_id IN (SELECT topic_id FROM user_topics WHERE user_id = ?))",
+				user.ID, user.ID, rsvps, user.ID, user.ID)
+	}
+
+	var upcoming []models.Swamp
+	err := base().Where("start_time >= ? OR "+series, now, models.SwampStatusEnded).
+		Order("start_time").Limit(maxCalendarFeedSwamps).
+		Find(&upcoming).Error
+	if err != nil || len(upcoming) == maxCalendarFeedSwamps {
+		return upcoming, err
+	}
+
+	var past []models.Swamp
+	err = base().Where("start_time >= ? AND start_time < ?", now.Add(-calendarFeedHistory), now).
+		Where("NOT ("+series+")", models.SwampStatusEnded).
+		Order("start_time DESC").Limit(maxCalendarFeedSwamps - len(upcoming)).
+		Find(&past).Error
+	return append(upcoming, past...), err
+}
+
+// swampCalendar loads the topics, series and overrides of swamps and turns
+// them into a calendar.
+func swampCalendar(db *gorm.DB, name string, swamps []models.Swamp) (ical.Calendar, error) {
+	calendar := ical.Calendar{Name: name}
+	if len(swamps) == 0 {
+		return calendar, nil
+	}
+	ids := make([]int, len(swamps))
+	for i, swamp := range swamps {
+		ids[i] = swamp.ID
+	}
+
+	var loaded []models.Swamp
+	if err := db.Preload("Topic").Preload("Topics").Preload("Recurrence").Order("start_time").Find(&loaded, ids).Error; err != nil {
+		return calendar, err
+	}
+	var rows []models.SwampOccurrence
+	if err := db.Where("swamp_id IN ?", ids).Find(&rows).Error; err != nil {
+		return calendar, err
+	}
+	overrides := map[uint][]models.SwampOccurrence{}
+	for _, row := range rows {
+		overrides[row.SwampID] = append(overrides[row.SwampID], row)
+	}
+
+	for _, swamp := range loaded {
+		calendar.Events = append(calendar.Events, swampEvents(swamp, overrides[uint(swamp.ID)])...)
+	}
+	return calendar, nil
+}
+
+// swampEvents is the VEVENT of a one-off swamp, or the series of a
+// recurring one followed by an event for each occurrence moved on its own.
+func swampEvents(swamp models.Swamp, overrides []models.SwampOccurrence) []ical.Event {
+	var topics []string
+	for _, topic := range swamp.Topics {
+		topics = append(topics, topic.Name)
+	}
+	if len(topics) == 0 && swamp.Topic.Name != "" {
+		topics = []string{swamp.Topic.Name}
+	}
+	event := ical.Event{
+		UID:        swamp.UUID + "@swamp",
+		Start:      swamp.StartTime,
+		Duration:   time.Duration(swamp.Duration) * time.Minute,
+		Summary:    swamp.Title,
+		Categories: topics,
+		Modified:   swamp.UpdatedAt,
+	}
+	if swamp.Topic.Name != "" {
+		event.Description = "Topic: " + swamp.Topic.Name
+		if len(topics) > 1 {
+			event.Description = "Topics: " + strings.Join(topics, ", ")
+		}
+	}
+
+	series := swamp.Recurrence
+	if series == nil {
+		return []ical.Event{event}
+	}
+	// DTSTART must be an occurrence, or calendar apps add one and count it
+	first, ok := series.First()
+	if !ok {
+		return nil
+	}
+	event.Start = first
+	event.Duration = time.Duration(series.Duration) * time.Minute
+	event.Rule = series.Rule
+	event.Location = series.Location()
+
+	events := []ical.Event{event}
+	for _, override := range overrides {
+		if override.Cancelled {
+			events[0].ExDates = append(events[0].ExDates, override.OriginalStart)
+			continue
+		}
+		occurrence := series.Occurrence(override.OriginalStart, overrides)
+		moved := event
+		moved.Rule = ""
+		moved.RecurrenceID = &occurrence.OriginalStart
+		moved.Start = occurrence.StartTime
+		moved.Duration = time.Duration(occurrence.Duration) * time.Minute
+		events = append(events, moved)
+	}
+	return events
+}
@@ -1,23 +1,49 @@
 package controllers_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"swamp/controllers"
 	"swamp/database"
+	"swamp/middleware"
 	"swamp/models"
+	"swamp/pkg/auth"
+	"swamp/pkg/chat"
+	"swamp/pkg/mail"
+	"swamp/pkg/sso"
+	"swamp/pkg/sso/ssotest"
+	"swamp/pkg/totp"
+	"swamp/pkg/webrtc"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/driver/sqlite"
+	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
 )
 
+// TestMain gives the package the signing key main reads from JWT_SECRET.
+func TestMain(m *testing.M) {
+	if err := auth.SetSigningKey("swamp-test-signing-key-0123456789"); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
 func initTestDB(t *testing.T) {
 	var err error
 	database.DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
@@ -25,7 +51,7 @@ func initTestDB(t *testing.T) {
 		t.Fatalf("failed to connect test database: %v", err)
 	}
 
-	err = database.DB.AutoMigrate(&models.User{})
+	err = database.DB.AutoMigrate(&models.User{}, &models.RefreshToken{})
 	if err != nil {
 		t.Fatalf("failed to migrate schema: %v", err)
 	}
@@ -60,6 +86,15 @@ func TestLogin(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody:   `"message":"Login successful"`,
 		},
+		{
+			name: "valid login returns access token",
+			rawBody: mustJSON(map[string]string{
+				"email":    "test@example.com",
+				"password": password,
+			}),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"accessToken":"`,
+		},
 		{
 			name:           "invalid JSON",
 			rawBody:        []byte("{{{invalid json}}}"),
@@ -109,6 +144,79 @@ func TestLogin(t *testing.T) {
 	}
 }
 
+// loginForTokens logs in and returns the decoded session payload.
+func loginForTokens(t *testing.T, email, password string) map[string]interface{} {
+	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(mustJSON(map[string]string{
+		"email":    email,
+		"password": password,
+	})))
+	rec := httptest.NewRecorder()
+	controllers.Login(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var session map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &session))
+	return session
+}
+
+func postRefresh(handler http.HandlerFunc, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/token/refresh", bytes.NewBuffer(mustJSON(map[string]string{
+		"refreshToken": token,
+	})))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestRefreshSession(t *testing.T) {
+	initTestDB(t)
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("secure123"), bcrypt.DefaultCost)
+	database.DB.Create(&models.User{
+		Email:    "refresh@example.com",
+		FullName: "Refresh User",
+		Password: string(hashedPassword),
+	})
+
+	session := loginForTokens(t, "refresh@example.com", "secure123")
+	first := session["refreshToken"].(string)
+
+	t.Run("rotation issues a new refresh token", func(t *testing.T) {
+		rec := postRefresh(controllers.RefreshSession, first)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var rotated map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rotated))
+		assert.NotEqual(t, first, rotated["refreshToken"])
+		assert.NotEmpty(t, rotated["accessToken"])
+
+		t.Run("reusing the old token revokes the family", func(t *testing.T) {
+			rec := postRefresh(controllers.RefreshSession, first)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			assert.Contains(t, rec.Body.String(), `"error": "Refresh token reuse detected"`)
+
+			rec = postRefresh(controllers.RefreshSession, rotated["refreshToken"].(string))
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		})
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		rec := postRefresh(controllers.RefreshSession, "not-a-token")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"error": "Invalid refresh token"`)
+	})
+
+	t.Run("logout revokes the session", func(t *testing.T) {
+		token := loginForTokens(t, "refresh@example.com", "secure123")["refreshToken"].(string)
+
+		rec := postRefresh(controllers.Logout, token)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = postRefresh(controllers.RefreshSession, token)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
 func mustJSON(v interface{}) []byte {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -124,7 +232,7 @@ func initTestDBForOTP(t *testing.T) {
 		t.Fatalf("failed to connect test database: %v", err)
 	}
 
-	err = database.DB.AutoMigrate(&models.OTP{}, &models.User{})
+	err = database.DB.AutoMigrate(&models.OTP{}, &models.User{}, &models.RefreshToken{})
 	if err != nil {
 		t.Fatalf("failed to migrate schema: %v", err)
 	}
@@ -132,6 +240,7 @@ func initTestDBForOTP(t *testing.T) {
 
 func TestRequestOTP(t *testing.T) {
 	initTestDBForOTP(t)
+	controllers.ResetRateLimits()
 
 	tests := []struct {
 		name           string
@@ -182,6 +291,167 @@ func TestRequestOTP(t *testing.T) {
 			assert.Contains(t, rec.Body.String(), tt.expectedBody)
 		})
 	}
+
+	t.Run("code is delivered by the mailer", func(t *testing.T) {
+		outbox := mail.NewMemoryMailer()
+		controllers.Mailer = outbox
+
+		req := httptest.NewRequest("POST", "/request-otp", bytes.NewBuffer(mustJSON(map[string]string{
+			"email": "delivered@example.com",
+		})))
+		rec := httptest.NewRecorder()
+		controllers.RequestOTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		msg, ok := outbox.Last("delivered@example.com")
+		if assert.True(t, ok) {
+			code := otpFromMail(t, outbox, "delivered@example.com")
+			assert.Contains(t, msg.HTML, code)
+
+			var otp models.OTP
+			database.DB.Where("email = ?", "delivered@example.com").First(&otp)
+			assert.NotEqual(t, code, otp.Code, "codes must be hashed at rest")
+		}
+	})
+}
+
+var otpPattern = regexp.MustCompile(`\b\d{6}\b`)
+
+// otpFromMail extracts the last code mailed to email.
+func otpFromMail(t *testing.T, outbox *mail.MemoryMailer, email string) string {
+	msg, ok := outbox.Last(email)
+	if !ok {
+		t.Fatalf("no mail sent to %s", email)
+	}
+	code := otpPattern.FindString(msg.Text)
+	if code == "" {
+		t.Fatalf("no code in mail to %s", email)
+	}
+	return code
+}
+
+func requestOTP(email string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/request-otp", bytes.NewBuffer(mustJSON(map[string]string{"email": email})))
+	rec := httptest.NewRecorder()
+	controllers.RequestOTP(rec, req)
+	return rec
+}
+
+func verifyOTP(email, code string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/verify-otp", bytes.NewBuffer(mustJSON(map[string]string{
+		"email":    email,
+		"otp":      code,
+		"fullName": "New User",
+		"password": "Secure123!",
+	})))
+	rec := httptest.NewRecorder()
+	controllers.VerifyOTP(rec, req)
+	return rec
+}
+
+func TestOTPHardening(t *testing.T) {
+	initTestDBForOTP(t)
+	controllers.ResetRateLimits()
+	outbox := mail.NewMemoryMailer()
+	controllers.Mailer = outbox
+
+	t.Run("resend cooldown", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, requestOTP("cooldown@example.com").Code)
+
+		rec := requestOTP("cooldown@example.com")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	})
+
+	t.Run("wrong codes lock the OTP", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, requestOTP("locked@example.com").Code)
+		code := otpFromMail(t, outbox, "locked@example.com")
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+
+		for i := 1; i < 5; i++ {
+			rec := verifyOTP("locked@example.com", wrong)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			assert.Contains(t, rec.Body.String(), `"error": "Invalid OTP"`)
+		}
+
+		rec := verifyOTP("locked@example.com", wrong)
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Contains(t, rec.Body.String(), "OTP is locked")
+
+		// Even the right code is refused while locked, and resending does not unlock
+		assert.Equal(t, http.StatusTooManyRequests, verifyOTP("locked@example.com", code).Code)
+		database.DB.Model(&models.OTP{}).Where("email = ?", "locked@example.com").
+			Update("last_sent_at", time.Now().Add(-time.Hour))
+		assert.Equal(t, http.StatusTooManyRequests, requestOTP("locked@example.com").Code)
+	})
+
+	t.Run("parallel guesses share the attempt limit", func(t *testing.T) {
+		controllers.ResetRateLimits()
+		// One connection keeps the in-memory database shared between goroutines
+		sqlDB, _ := database.DB.DB()
+		sqlDB.SetMaxOpenConns(1)
+		assert.Equal(t, http.StatusOK, requestOTP("parallel@example.com").Code)
+		code := otpFromMail(t, outbox, "parallel@example.com")
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+
+		var wg sync.WaitGroup
+		codes := make([]int, 20)
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				codes[i] = verifyOTP("parallel@example.com", wrong).Code
+			}(i)
+		}
+		wg.Wait()
+
+		rejected := 0
+		for _, status := range codes {
+			if status == http.StatusUnauthorized {
+				rejected++
+			}
+		}
+		assert.LessOrEqual(t, rejected, 4)
+		var otp models.OTP
+		database.DB.Where("email = ?", "parallel@example.com").First(&otp)
+		assert.LessOrEqual(t, otp.Attempts, 5)
+		assert.True(t, otp.Locked())
+	})
+
+	t.Run("expired code", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, requestOTP("expired@example.com").Code)
+		code := otpFromMail(t, outbox, "expired@example.com")
+		database.DB.Model(&models.OTP{}).Where("email = ?", "expired@example.com").
+			Update("expires_at", time.Now().Add(-time.Minute))
+
+		rec := verifyOTP("expired@example.com", code)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"error": "OTP has expired"`)
+	})
+
+	t.Run("correct code", func(t *testing.T) {
+		controllers.ResetRateLimits()
+		assert.Equal(t, http.StatusOK, requestOTP("valid@example.com").Code)
+		code := otpFromMail(t, outbox, "valid@example.com")
+
+		rec := verifyOTP("valid@example.com", code)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"accessToken":"`)
+	})
+
+	t.Run("per-IP limit", func(t *testing.T) {
+		controllers.ResetRateLimits()
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusOK, requestOTP(fmt.Sprintf("ip%d@example.com", i)).Code)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, requestOTP("ip5@example.com").Code)
+	})
 }
 
 func initTestDBForSwamp(t *testing.T) {
@@ -191,7 +461,7 @@ func initTestDBForSwamp(t *testing.T) {
 		t.Fatalf("failed to connect test database: %v", err)
 	}
 
-	err = database.DB.AutoMigrate(&models.Swamp{})
+	err = database.DB.AutoMigrate(&models.Swamp{}, &models.SwampRecurrence{}, &models.SwampOccurrence{})
 	if err != nil {
 		t.Fatalf("failed to migrate schema: %v", err)
 	}
@@ -199,6 +469,7 @@ func initTestDBForSwamp(t *testing.T) {
 
 func TestCreateSwamp(t *testing.T) {
 	initTestDBForSwamp(t)
+	owner := &models.User{Model: gorm.Model{ID: 7}, Email: "owner@example.com"}
 
 	tests := []struct {
 		name           string
@@ -248,6 +519,7 @@ func TestCreateSwamp(t *testing.T) {
 
 			req := httptest.NewRequest("POST", "/swamps", bytes.NewBuffer(body))
 			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(middleware.WithUser(req.Context(), owner))
 			rec := httptest.NewRecorder()
 
 			controllers.CreateSwamp(rec, req)
@@ -256,4 +528,1794 @@ func TestCreateSwamp(t *testing.T) {
 			assert.Contains(t, rec.Body.String(), tt.expectedBody)
 		})
 	}
-}
\ No newline at end of file
+
+	t.Run("owner comes from the session, not the body", func(t *testing.T) {
+		body := mustJSON(map[string]interface{}{
+			"title":           "Spoofed Swamp",
+			"ownerID":         999,
+			"maxParticipants": 5,
+			"startTime":       time.Now().Add(1 * time.Hour).Format(time.RFC3339),
+			"duration":        60,
+		})
+		req := httptest.NewRequest("POST", "/swamps", bytes.NewBuffer(body))
+		req = req.WithContext(middleware.WithUser(req.Context(), owner))
+		rec := httptest.NewRecorder()
+
+		controllers.CreateSwamp(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var swamp models.Swamp
+		database.DB.Where("title = ?", "Spoofed Swamp").First(&swamp)
+		assert.Equal(t, int(owner.ID), swamp.OwnerID)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/swamps", bytes.NewBuffer([]byte(`{}`)))
+		rec := httptest.NewRecorder()
+
+		controllers.CreateSwamp(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+func TestGetSwampsSearch(t *testing.T) {
+	initTestDBForSwamp(t)
+	database.DB.AutoMigrate(&models.Topic{})
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, swamp := range []models.Swamp{
+		{UUID: "a", Title: "Go Generics Deep Dive", OwnerID: 1, TopicID: 1, StartTime: now.Add(50 * time.Hour), Status: models.SwampStatusScheduled},
+		{UUID: "b", Title: "Rust for Gophers", OwnerID: 2, TopicID: 2, StartTime: now.Add(-10 * time.Minute), Status: models.SwampStatusLive},
+		{UUID: "c", Title: "go tooling 100%", OwnerID: 1, TopicID: 2, StartTime: now.Add(-48 * time.Hour), Status: models.SwampStatusEnded},
+		{UUID: "d", Title: "Zig lobby", OwnerID: 3, TopicID: 3, StartTime: now.Add(5 * time.Minute), Status: models.SwampStatusLobby},
+		{UUID: "e", Title: "Go cancelled", OwnerID: 1, TopicID: 1, StartTime: now.Add(time.Hour), Status: models.SwampStatusCancelled, Deleted: true},
+	} {
+		database.DB.Create(&swamp)
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedTotal  int
+		expectedUUIDs  []string
+	}{
+		{name: "no filters", query: "", expectedStatus: http.StatusOK, expectedTotal: 4, expectedUUIDs: []string{"c", "b", "d", "a"}},
+		{name: "title search is case-insensitive", query: "q=GO", expectedStatus: http.StatusOK, expectedTotal: 3, expectedUUIDs: []string{"c", "b", "a"}},
+		{name: "wildcards match literally", query: "q=100%25", expectedStatus: http.StatusOK, expectedTotal: 1, expectedUUIDs: []string{"c"}},
+		{name: "several topics", query: "topicId=1,3", expectedStatus: http.StatusOK, expectedTotal: 2, expectedUUIDs: []string{"d", "a"}},
+		{name: "owner", query: "ownerId=1&sort=-startTime", expectedStatus: http.StatusOK, expectedTotal: 2, expectedUUIDs: []string{"a", "c"}},
+		{name: "upcoming includes the lobby", query: "status=upcoming", expectedStatus: http.StatusOK, expectedTotal: 2, expectedUUIDs: []string{"d", "a"}},
+		{name: "live or ended", query: "status=live&status=ended", expectedStatus: http.StatusOK, expectedTotal: 2, expectedUUIDs: []string{"c", "b"}},
+		{name: "start range", query: "startFrom=" + url.QueryEscape(now.Add(-time.Hour).Format(time.RFC3339)) + "&startTo=" + url.QueryEscape(now.Add(time.Hour).Format(time.RFC3339)), expectedStatus: http.StatusOK, expectedTotal: 2, expectedUUIDs: []string{"b", "d"}},
+		{name: "sort by title", query: "sort=title", expectedStatus: http.StatusOK, expectedTotal: 4, expectedUUIDs: []string{"a", "c", "b", "d"}},
+		{name: "total reflects filters across pages", query: "q=go&recordsPerPage=1&pageNumber=2", expectedStatus: http.StatusOK, expectedTotal: 3, expectedUUIDs: []string{"b"}},
+		{name: "unknown status", query: "status=paused", expectedStatus: http.StatusBadRequest},
+		{name: "bad topic", query: "topicId=abc", expectedStatus: http.StatusBadRequest},
+		{name: "bad time", query: "startFrom=yesterday", expectedStatus: http.StatusBadRequest},
+		{name: "unknown sort", query: "sort=owner", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/swamp?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+
+			controllers.GetSwamps(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Meta         map[string]int `json:"meta"`
+				AllDocuments []models.Swamp `json:"allDocuments"`
+			}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, tt.expectedTotal, body.Meta["totalResults"])
+			var uuids []string
+			for _, swamp := range body.AllDocuments {
+				uuids = append(uuids, swamp.UUID)
+			}
+			assert.Equal(t, tt.expectedUUIDs, uuids)
+		})
+	}
+}
+
+func TestCursorPagination(t *testing.T) {
+	initTestDBForSwamp(t)
+	database.DB.AutoMigrate(&models.Topic{})
+
+	start := time.Now().UTC().Truncate(time.Second).Add(time.Hour)
+	for i := 0; i < 5; i++ {
+		// Two swamps share each start time so the id has to break ties
+		database.DB.Create(&models.Swamp{UUID: fmt.Sprintf("s%d", i), Title: fmt.Sprintf("Swamp %d", i),
+			TopicID: 1, StartTime: start.Add(time.Duration(i/2) * time.Hour)})
+	}
+	for _, name := range []string{"go", "rust", "zig"} {
+		database.DB.Create(&models.Topic{Name: name})
+	}
+
+	type page struct {
+		Meta         map[string]interface{} `json:"meta"`
+		AllDocuments []struct {
+			UUID string `json:"UUID"`
+			Name string `json:"Name"`
+		} `json:"allDocuments"`
+	}
+	get := func(handler http.HandlerFunc, target string) (int, page) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", target, nil))
+		var body page
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+	uuids := func(p page) []string {
+		var out []string
+		for _, doc := range p.AllDocuments {
+			out = append(out, doc.UUID)
+		}
+		return out
+	}
+	cursor := func(p page, name string) string {
+		c, _ := p.Meta[name].(string)
+		return c
+	}
+
+	t.Run("swamps forward and back", func(t *testing.T) {
+		code, first := get(controllers.GetSwamps, "/api/swamp?cursor=&recordsPerPage=2")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{"s0", "s1"}, uuids(first))
+		assert.Nil(t, first.Meta["prevCursor"])
+		assert.Equal(t, float64(5), first.Meta["totalResults"])
+
+		// A swamp created while browsing, sorting before the current page,
+		// must not shift what comes next
+		database.DB.Create(&models.Swamp{UUID: "early", Title: "Early", TopicID: 1, StartTime: start.Add(-time.Hour)})
+
+		_, second := get(controllers.GetSwamps, "/api/swamp?recordsPerPage=2&cursor="+cursor(first, "nextCursor"))
+		assert.Equal(t, []string{"s2", "s3"}, uuids(second))
+
+		_, third := get(controllers.GetSwamps, "/api/swamp?recordsPerPage=2&cursor="+cursor(second, "nextCursor"))
+		assert.Equal(t, []string{"s4"}, uuids(third))
+		assert.Nil(t, third.Meta["nextCursor"])
+
+		_, back := get(controllers.GetSwamps, "/api/swamp?recordsPerPage=2&cursor="+cursor(third, "prevCursor"))
+		assert.Equal(t, []string{"s2", "s3"}, uuids(back))
+
+		_, start := get(controllers.GetSwamps, "/api/swamp?recordsPerPage=2&cursor="+cursor(back, "prevCursor"))
+		assert.Equal(t, []string{"s0", "s1"}, uuids(start))
+		assert.NotEmpty(t, cursor(start, "prevCursor"))
+
+		_, before := get(controllers.GetSwamps, "/api/swamp?recordsPerPage=2&cursor="+cursor(start, "prevCursor"))
+		assert.Equal(t, []string{"early"}, uuids(before))
+		assert.Nil(t, before.Meta["prevCursor"])
+	})
+
+	t.Run("descending sort", func(t *testing.T) {
+		_, first := get(controllers.GetSwamps, "/api/swamp?cursor=&recordsPerPage=3&sort=-startTime")
+		assert.Equal(t, []string{"s4", "s3", "s2"}, uuids(first))
+		_, second := get(controllers.GetSwamps, "/api/swamp?recordsPerPage=3&sort=-startTime&cursor="+cursor(first, "nextCursor"))
+		assert.Equal(t, []string{"s1", "s0", "early"}, uuids(second))
+	})
+
+	t.Run("page numbers still work", func(t *testing.T) {
+		_, p := get(controllers.GetSwamps, "/api/swamp?pageNumber=2&recordsPerPage=2")
+		assert.Equal(t, []string{"s1", "s2"}, uuids(p))
+		assert.Equal(t, float64(2), p.Meta["pageNumber"])
+	})
+
+	t.Run("bad cursors", func(t *testing.T) {
+		code, _ := get(controllers.GetSwamps, "/api/swamp?cursor=not-a-cursor")
+		assert.Equal(t, http.StatusBadRequest, code)
+
+		_, first := get(controllers.GetSwamps, "/api/swamp?cursor=&recordsPerPage=2")
+		code, _ = get(controllers.GetSwamps, "/api/swamp?sort=title&cursor="+cursor(first, "nextCursor"))
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("topics", func(t *testing.T) {
+		code, first := get(controllers.ListTopics, "/api/topics?cursor=&recordsPerPage=2")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, first.AllDocuments, 2)
+		assert.Equal(t, "go", first.AllDocuments[0].Name)
+
+		_, second := get(controllers.ListTopics, "/api/topics?recordsPerPage=2&cursor="+cursor(first, "nextCursor"))
+		assert.Len(t, second.AllDocuments, 1)
+		assert.Equal(t, "zig", second.AllDocuments[0].Name)
+		assert.Nil(t, second.Meta["nextCursor"])
+
+		rec := httptest.NewRecorder()
+		controllers.ListTopics(rec, httptest.NewRequest("GET", "/api/topics", nil))
+		var all []models.Topic
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &all))
+		assert.Len(t, all, 3)
+	})
+}
+
+func TestFeed(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.Swamp{}, &models.SwampMember{}, &models.SwampInvitation{}, &models.Topic{}, &models.UserTopic{})
+
+	user := models.User{Email: "reader@example.com", FullName: "Reader"}
+	database.DB.Create(&user)
+	golang, rust := models.Topic{Name: "Go"}, models.Topic{Name: "Rust"}
+	database.DB.Create(&golang)
+	database.DB.Create(&rust)
+	database.DB.Create(&models.UserTopic{UserID: user.ID, TopicID: golang.ID})
+
+	now := time.Now()
+	for _, swamp := range []models.Swamp{
+		{UUID: "rust-soon", Title: "Rust soon", TopicID: rust.ID, StartTime: now.Add(time.Hour), Status: models.SwampStatusScheduled},
+		{UUID: "go-later", Title: "Go later", TopicID: golang.ID, StartTime: now.Add(50 * time.Hour), Status: models.SwampStatusScheduled},
+		{UUID: "rust-live", Title: "Rust live", TopicID: rust.ID, StartTime: now.Add(-time.Minute), Duration: 60, Status: models.SwampStatusLive},
+		{UUID: "go-ended", Title: "Go ended", TopicID: golang.ID, StartTime: now.Add(-3 * time.Hour), Status: models.SwampStatusEnded},
+		{UUID: "go-cancelled", Title: "Go cancelled", TopicID: golang.ID, StartTime: now.Add(time.Hour), Status: models.SwampStatusCancelled, Deleted: true},
+	} {
+		database.DB.Create(&swamp)
+	}
+
+	type feedPage struct {
+		Meta         map[string]int `json:"meta"`
+		AllDocuments []struct {
+			Swamp       models.Swamp `json:"swamp"`
+			Score       float64      `json:"score"`
+			Explanation string       `json:"explanation"`
+		} `json:"allDocuments"`
+	}
+	get := func(query string) feedPage {
+		rec := httptest.NewRecorder()
+		controllers.GetFeed(rec, authedRequest("GET", "/api/me/feed?"+query, nil, user.ID))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var page feedPage
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		return page
+	}
+
+	page := get("")
+	assert.Equal(t, 3, page.Meta["totalResults"])
+	var order []string
+	for _, item := range page.AllDocuments {
+		order = append(order, item.Swamp.UUID)
+	}
+	assert.Equal(t, []string{"go-later", "rust-live", "rust-soon"}, order)
+	assert.Equal(t, "Matches your interest in Go; starts in 2 days", page.AllDocuments[0].Explanation)
+	assert.Equal(t, "Live now", page.AllDocuments[1].Explanation)
+
+	second := get("pageNumber=2&recordsPerPage=2")
+	assert.Len(t, second.AllDocuments, 1)
+	assert.Equal(t, "rust-soon", second.AllDocuments[0].Swamp.UUID)
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		controllers.GetFeed(rec, httptest.NewRequest("GET", "/api/me/feed", nil))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestUpdateAndCancelSwamp(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.Swamp{}, &models.SwampMember{}, &models.SwampRecurrence{})
+
+	owner := models.User{Email: "owner@example.com", FullName: "Owner"}
+	stranger := models.User{Email: "stranger@example.com", FullName: "Stranger"}
+	database.DB.Create(&owner)
+	database.DB.Create(&stranger)
+	swamp := models.Swamp{UUID: "room-edit", Title: "Tpyo", OwnerID: int(owner.ID), MaxParticipants: 5,
+		StartTime: time.Now().Add(time.Hour), Duration: 60, TopicID: 1}
+	database.DB.Create(&swamp)
+
+	router := chi.NewRouter()
+	router.Patch("/api/swamp/{id}", controllers.UpdateSwamp)
+	router.Delete("/api/swamp/{id}", controllers.DeleteSwamp)
+	router.Get("/api/swamp/{id}", controllers.GetSwampByID)
+
+	path := fmt.Sprintf("/api/swamp/%d", swamp.ID)
+
+	t.Run("stranger cannot edit", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(router, "PATCH", path, map[string]string{"title": "Mine now"}, stranger).Code)
+	})
+
+	t.Run("owner fixes the title only", func(t *testing.T) {
+		rec := serve(router, "PATCH", path, map[string]string{"title": "Typo"}, owner)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"message":"Swamp updated successfully"`)
+
+		var updated models.Swamp
+		database.DB.First(&updated, swamp.ID)
+		assert.Equal(t, "Typo", updated.Title)
+		assert.Equal(t, 5, updated.MaxParticipants)
+		assert.Equal(t, 60, updated.Duration)
+	})
+
+	t.Run("validation matches create", func(t *testing.T) {
+		rec := serve(router, "PATCH", path, map[string]interface{}{"title": ""}, owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"error": "Missing required fields"`)
+
+		rec = serve(router, "PATCH", path, map[string]interface{}{"startTime": "tomorrow"}, owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"error": "Invalid start time format"`)
+
+		rec = serve(router, "PATCH", path, map[string]interface{}{"duration": -5}, owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("start time is fixed once live", func(t *testing.T) {
+		database.DB.Model(&models.Swamp{}).Where("id = ?", swamp.ID).Update("status", models.SwampStatusLive)
+		defer database.DB.Model(&models.Swamp{}).Where("id = ?", swamp.ID).Update("status", models.SwampStatusScheduled)
+
+		rec := serve(router, "PATCH", path, map[string]string{"startTime": time.Now().Add(2 * time.Hour).Format(time.RFC3339)}, owner)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, http.StatusOK, serve(router, "PATCH", path, map[string]int{"duration": 90}, owner).Code)
+	})
+
+	t.Run("stranger cannot cancel", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(router, "DELETE", path, nil, stranger).Code)
+	})
+
+	t.Run("cancelling closes the live room", func(t *testing.T) {
+		hub := chat.NewHub()
+		go hub.Run()
+		room := &webrtc.Room{UUID: swamp.UUID, Peers: &webrtc.Peers{}, Hub: hub}
+		webrtc.RoomsLock.Lock()
+		webrtc.Rooms = map[string]*webrtc.Room{swamp.UUID: room}
+		webrtc.Streams = map[string]*webrtc.Room{"stream-edit": room}
+		webrtc.RoomsLock.Unlock()
+
+		assert.Equal(t, http.StatusNoContent, serve(router, "DELETE", path, nil, owner).Code)
+
+		webrtc.RoomsLock.RLock()
+		assert.Empty(t, webrtc.Rooms)
+		assert.Empty(t, webrtc.Streams)
+		webrtc.RoomsLock.RUnlock()
+		assert.True(t, room.Peers.IsClosed())
+
+		var cancelled models.Swamp
+		assert.NoError(t, database.DB.Unscoped().First(&cancelled, swamp.ID).Error)
+		assert.True(t, cancelled.Deleted)
+		assert.True(t, cancelled.DeletedAt.Valid)
+		assert.Equal(t, models.SwampStatusCancelled, cancelled.Status)
+	})
+
+	t.Run("cancelled swamp is gone", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", path, nil, owner).Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, "PATCH", path, map[string]string{"title": "Back"}, owner).Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, "DELETE", path, nil, owner).Code)
+	})
+}
+
+func TestMultiTopicSwamps(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.Swamp{}, &models.SwampMember{}, &models.SwampInvitation{}, &models.Topic{}, &models.SwampTopic{}, &models.SwampRecurrence{})
+
+	owner := models.User{Email: "owner@example.com", FullName: "Owner"}
+	database.DB.Create(&owner)
+	for _, name := range []string{"Go", "Rust", "Zig", "WebRTC", "Postgres", "Kubernetes"} {
+		database.DB.Create(&models.Topic{Name: name})
+	}
+	database.DB.Create(&models.Topic{Name: "Retired", Deleted: true})
+
+	router := chi.NewRouter()
+	router.Post("/api/swamp", controllers.CreateSwamp)
+	router.Patch("/api/swamp/{id}", controllers.UpdateSwamp)
+	router.Get("/api/swamp/{id}", controllers.GetSwampByID)
+	router.Get("/api/swamp", controllers.GetSwamps)
+
+	fields := func(extra map[string]interface{}) map[string]interface{} {
+		body := map[string]interface{}{
+			"title":           "Systems languages",
+			"maxParticipants": 5,
+			"startTime":       time.Now().Add(time.Hour).Format(time.RFC3339),
+			"duration":        60,
+		}
+		for k, v := range extra {
+			body[k] = v
+		}
+		return body
+	}
+	topicsOf := func(swampID int) (uint, []uint) {
+		var swamp models.Swamp
+		database.DB.Preload("Topics").First(&swamp, swampID)
+		var ids []uint
+		for _, topic := range swamp.Topics {
+			ids = append(ids, topic.ID)
+		}
+		return swamp.TopicID, ids
+	}
+
+	var swampID int
+	t.Run("first listed topic is primary by default", func(t *testing.T) {
+		rec := serve(router, "POST", "/api/swamp", fields(map[string]interface{}{"topicIds": []uint{2, 1, 2}}), owner)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var body struct {
+			Swamp models.Swamp `json:"swamp"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, "Rust", body.Swamp.Topic.Name)
+		assert.Len(t, body.Swamp.Topics, 2)
+
+		swampID = body.Swamp.ID
+		primary, ids := topicsOf(swampID)
+		assert.Equal(t, uint(2), primary)
+		assert.ElementsMatch(t, []uint{1, 2}, ids)
+	})
+
+	t.Run("primary is always among the topics", func(t *testing.T) {
+		rec := serve(router, "PATCH", fmt.Sprintf("/api/swamp/%d", swampID), map[string]interface{}{"topicId": 3}, owner)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		primary, ids := topicsOf(swampID)
+		assert.Equal(t, uint(3), primary)
+		assert.ElementsMatch(t, []uint{1, 2, 3}, ids)
+	})
+
+	t.Run("replacing the set moves a dropped primary", func(t *testing.T) {
+		rec := serve(router, "PATCH", fmt.Sprintf("/api/swamp/%d", swampID), map[string]interface{}{"topicIds": []uint{4, 1}}, owner)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		primary, ids := topicsOf(swampID)
+		assert.Equal(t, uint(4), primary)
+		assert.ElementsMatch(t, []uint{1, 4}, ids)
+	})
+
+	t.Run("invalid topic sets", func(t *testing.T) {
+		rec := serve(router, "POST", "/api/swamp", fields(map[string]interface{}{"topicIds": []uint{1, 2, 3, 4, 5, 6}}), owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"error": "A swamp can have at most 5 topics"`)
+
+		rec = serve(router, "POST", "/api/swamp", fields(map[string]interface{}{"topicIds": []uint{1, 7}}), owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"error": "Unknown topic"`)
+
+		rec = serve(router, "PATCH", fmt.Sprintf("/api/swamp/%d", swampID), map[string]interface{}{"topicIds": []uint{99}}, owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("topic filter matches any associated topic", func(t *testing.T) {
+		database.DB.Create(&models.Swamp{UUID: "legacy", Title: "Legacy", OwnerID: int(owner.ID), TopicID: 1,
+			StartTime: time.Now().Add(2 * time.Hour), Status: models.SwampStatusScheduled})
+
+		rec := serve(router, "GET", "/api/swamp?topicId=1", nil, owner)
+		var body struct {
+			AllDocuments []models.Swamp `json:"allDocuments"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Len(t, body.AllDocuments, 2)
+		assert.Len(t, body.AllDocuments[0].Topics, 2)
+
+		rec = serve(router, "GET", "/api/swamp?topicId=4", nil, owner)
+		body.AllDocuments = nil
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Len(t, body.AllDocuments, 1)
+
+		rec = serve(router, "GET", fmt.Sprintf("/api/swamp/%d", swampID), nil, owner)
+		assert.Contains(t, rec.Body.String(), `"Name":"WebRTC"`)
+	})
+}
+
+func TestSwampVisibility(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.Swamp{}, &models.SwampMember{}, &models.SwampInvitation{}, &models.SwampInviteLink{}, &models.SwampRecurrence{})
+
+	owner := models.User{Email: "owner@example.com", FullName: "Owner"}
+	guest := models.User{Email: "Guest@Example.com", FullName: "Guest"}
+	linkUser := models.User{Email: "link@example.com", FullName: "Link User"}
+	stranger := models.User{Email: "stranger@example.com", FullName: "Stranger"}
+	for _, user := range []*models.User{&owner, &guest, &linkUser, &stranger} {
+		database.DB.Create(user)
+	}
+	start := time.Now().Add(time.Hour)
+	public := models.Swamp{UUID: "public", Title: "Public", OwnerID: int(owner.ID), StartTime: start, Visibility: models.SwampVisibilityPublic}
+	unlisted := models.Swamp{UUID: "unlisted", Title: "Unlisted", OwnerID: int(owner.ID), StartTime: start, Visibility: models.SwampVisibilityUnlisted}
+	private := models.Swamp{UUID: "private", Title: "Private", OwnerID: int(owner.ID), StartTime: start, MaxParticipants: 5, Duration: 30}
+	for _, swamp := range []*models.Swamp{&public, &unlisted, &private} {
+		database.DB.Create(swamp)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/api/swamp", controllers.GetSwamps)
+	router.Get("/api/swamp/{id}", controllers.GetSwampByID)
+	router.Patch("/api/swamp/{id}", controllers.UpdateSwamp)
+	router.Post("/api/swamp/{id}/invitations", controllers.CreateInvitation)
+	router.Delete("/api/swamp/{id}/invitations/{invitationID}", controllers.DeleteInvitation)
+	router.Post("/api/swamp/{id}/invite-links", controllers.CreateInviteLink)
+	router.Delete("/api/swamp/{id}/invite-links/{linkID}", controllers.RevokeInviteLink)
+	router.Post("/api/invite-links/{token}/accept", controllers.AcceptInviteLink)
+
+	listed := func(caller models.User) []string {
+		var body struct {
+			AllDocuments []models.Swamp `json:"allDocuments"`
+		}
+		json.Unmarshal(serve(router, "GET", "/api/swamp", nil, caller).Body.Bytes(), &body)
+		var uuids []string
+		for _, swamp := range body.AllDocuments {
+			uuids = append(uuids, swamp.UUID)
+		}
+		return uuids
+	}
+	privatePath := fmt.Sprintf("/api/swamp/%d", private.ID)
+
+	t.Run("owner makes a swamp private", func(t *testing.T) {
+		rec := serve(router, "PATCH", privatePath, map[string]string{"visibility": "secret"}, owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"error": "Visibility must be public, unlisted or private"`)
+
+		assert.Equal(t, http.StatusOK, serve(router, "PATCH", privatePath, map[string]string{"visibility": "private"}, owner).Code)
+	})
+
+	t.Run("listings hide unlisted and private swamps", func(t *testing.T) {
+		assert.Equal(t, []string{"public"}, listed(stranger))
+		assert.Equal(t, []string{"public", "unlisted", "private"}, listed(owner))
+	})
+
+	t.Run("private swamps look missing to strangers", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", privatePath, nil, stranger).Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", "/api/swamp/"+private.UUID, nil, stranger).Code)
+	})
+
+	t.Run("unlisted swamps open by their link only", func(t *testing.T) {
+		// Sequential IDs could be walked to find them
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", fmt.Sprintf("/api/swamp/%d", unlisted.ID), nil, stranger).Code)
+		assert.Equal(t, http.StatusOK, serve(router, "GET", fmt.Sprintf("/api/swamp/%d", unlisted.ID), nil, owner).Code)
+		rec := serve(router, "GET", "/api/swamp/"+unlisted.UUID, nil, stranger)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"Title":"Unlisted"`)
+	})
+
+	t.Run("only the owner invites", func(t *testing.T) {
+		rec := serve(router, "POST", privatePath+"/invitations", map[string]string{"email": "x@example.com"}, stranger)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		rec = serve(router, "POST", privatePath+"/invitations", map[string]interface{}{"email": "x@example.com", "userId": guest.ID}, owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("email invitation grants access", func(t *testing.T) {
+		rec := serve(router, "POST", privatePath+"/invitations", map[string]string{"email": " guest@EXAMPLE.com"}, owner)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		var invitation models.SwampInvitation
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &invitation))
+		assert.Equal(t, "guest@example.com", invitation.Email)
+
+		assert.Equal(t, http.StatusOK, serve(router, "POST", privatePath+"/invitations", map[string]string{"email": "guest@example.com"}, owner).Code)
+		assert.Equal(t, []string{"public", "private"}, listed(guest))
+		assert.Equal(t, http.StatusOK, serve(router, "GET", privatePath, nil, guest).Code)
+
+		path := fmt.Sprintf("%s/invitations/%d", privatePath, invitation.ID)
+		assert.Equal(t, http.StatusNoContent, serve(router, "DELETE", path, nil, owner).Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", privatePath, nil, guest).Code)
+	})
+
+	t.Run("invite links honour their use limit", func(t *testing.T) {
+		rec := serve(router, "POST", privatePath+"/invite-links", map[string]interface{}{"expiresAt": time.Now().Add(-time.Minute)}, owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = serve(router, "POST", privatePath+"/invite-links", map[string]interface{}{"maxUses": 1, "expiresAt": time.Now().Add(time.Hour)}, owner)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		var created struct {
+			Token string `json:"token"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+		assert.NotContains(t, rec.Body.String(), "tokenHash")
+
+		accept := "/api/invite-links/" + created.Token + "/accept"
+		rec = serve(router, "POST", accept, nil, linkUser)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"message":"Invitation accepted"`)
+		assert.Equal(t, http.StatusOK, serve(router, "GET", privatePath, nil, linkUser).Code)
+
+		// Accepting again does not count; someone new finds it used up
+		assert.Equal(t, http.StatusOK, serve(router, "POST", accept, nil, linkUser).Code)
+		rec = serve(router, "POST", accept, nil, stranger)
+		assert.Equal(t, http.StatusGone, rec.Code)
+		assert.Contains(t, rec.Body.String(), "used up")
+	})
+
+	t.Run("revoked and unknown links", func(t *testing.T) {
+		rec := serve(router, "POST", privatePath+"/invite-links", map[string]interface{}{}, owner)
+		var created struct {
+			Token      string `json:"token"`
+			InviteLink struct {
+				ID uint `json:"id"`
+			} `json:"inviteLink"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+		path := fmt.Sprintf("%s/invite-links/%d", privatePath, created.InviteLink.ID)
+		assert.Equal(t, http.StatusNoContent, serve(router, "DELETE", path, nil, owner).Code)
+		rec = serve(router, "POST", "/api/invite-links/"+created.Token+"/accept", nil, stranger)
+		assert.Equal(t, http.StatusGone, rec.Code)
+		assert.Contains(t, rec.Body.String(), "revoked")
+
+		assert.Equal(t, http.StatusNotFound, serve(router, "POST", "/api/invite-links/nope/accept", nil, stranger).Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", privatePath, nil, stranger).Code)
+	})
+}
+
+func TestSwampPasscode(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.Swamp{}, &models.SwampMember{}, &models.SwampInvitation{}, &models.SwampRecurrence{})
+
+	owner := models.User{Email: "owner@example.com", FullName: "Owner"}
+	stranger := models.User{Email: "stranger@example.com", FullName: "Stranger"}
+	database.DB.Create(&owner)
+	database.DB.Create(&stranger)
+	swamp := models.Swamp{UUID: "room-passcode", Title: "Quiet room", OwnerID: int(owner.ID), StartTime: time.Now().Add(time.Hour)}
+	database.DB.Create(&swamp)
+
+	router := chi.NewRouter()
+	router.Put("/api/swamp/{id}/passcode", controllers.SetSwampPasscode)
+	router.Delete("/api/swamp/{id}/passcode", controllers.ClearSwampPasscode)
+	router.Get("/api/swamp/{id}", controllers.GetSwampByID)
+
+	path := fmt.Sprintf("/api/swamp/%d", swamp.ID)
+	stored := func() models.Swamp {
+		var current models.Swamp
+		database.DB.First(&current, swamp.ID)
+		return current
+	}
+
+	t.Run("only the owner sets it", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(router, "PUT", path+"/passcode", map[string]string{"passcode": "letmein"}, stranger).Code)
+		assert.Equal(t, http.StatusForbidden, serve(router, "DELETE", path+"/passcode", nil, stranger).Code)
+	})
+
+	t.Run("owner sets a passcode", func(t *testing.T) {
+		rec := serve(router, "PUT", path+"/passcode", map[string]string{"passcode": "abc"}, owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = serve(router, "PUT", path+"/passcode", map[string]string{"passcode": "open sesame"}, owner)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		current := stored()
+		assert.True(t, current.PasscodeRequired)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(current.PasscodeHash), []byte("open sesame")))
+
+		rec = serve(router, "GET", path, nil, stranger)
+		assert.Contains(t, rec.Body.String(), `"PasscodeRequired":true`)
+		assert.NotContains(t, rec.Body.String(), current.PasscodeHash)
+	})
+
+	t.Run("rotating without a body generates one", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := authedRequest("PUT", path+"/passcode", nil, owner.ID)
+		req.Body = http.NoBody
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var body struct {
+			Passcode string `json:"passcode"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Len(t, body.Passcode, 8)
+		hash := []byte(stored().PasscodeHash)
+		assert.NoError(t, bcrypt.CompareHashAndPassword(hash, []byte(body.Passcode)))
+		assert.Error(t, bcrypt.CompareHashAndPassword(hash, []byte("open sesame")))
+	})
+
+	t.Run("owner removes it", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve(router, "DELETE", path+"/passcode", nil, owner).Code)
+		assert.False(t, stored().PasscodeRequired)
+	})
+}
+
+func TestRecurringSwamps(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.Swamp{}, &models.SwampMember{}, &models.SwampInvitation{}, &models.Topic{}, &models.SwampTopic{},
+		&models.SwampRecurrence{}, &models.SwampOccurrence{})
+
+	owner := models.User{Email: "owner@example.com", FullName: "Owner", Timezone: "Etc/GMT-2"}
+	stranger := models.User{Email: "stranger@example.com", FullName: "Stranger"}
+	database.DB.Create(&owner)
+	database.DB.Create(&stranger)
+
+	router := chi.NewRouter()
+	router.Post("/api/swamp", controllers.CreateSwamp)
+	router.Get("/api/swamp/occurrences", controllers.ListOccurrences)
+	router.Patch("/api/swamp/{id}", controllers.UpdateSwamp)
+	router.Get("/api/swamp/{id}/occurrences", controllers.ListSwampOccurrences)
+	router.Patch("/api/swamp/{id}/occurrences/{start}", controllers.UpdateOccurrence)
+	router.Delete("/api/swamp/{id}/occurrences/{start}", controllers.CancelOccurrence)
+
+	// A Monday at 09:00 UTC at least a week away
+	base := time.Now().UTC().Truncate(24 * time.Hour).Add(7*24*time.Hour + 9*time.Hour)
+	for base.Weekday() != time.Monday {
+		base = base.Add(24 * time.Hour)
+	}
+	day := func(days int) time.Time { return base.Add(time.Duration(days) * 24 * time.Hour) }
+	stamp := func(t time.Time) string { return t.Format(time.RFC3339) }
+	window := fmt.Sprintf("from=%s&to=%s", url.QueryEscape(stamp(base)), url.QueryEscape(stamp(day(30))))
+
+	var swamp models.Swamp
+	t.Run("create a series", func(t *testing.T) {
+		fields := map[string]interface{}{
+			"Title": "Standup", "MaxParticipants": 10, "StartTime": stamp(base), "Duration": 15,
+			"Recurrence": "FREQ=YEARLY",
+		}
+		rec := serve(router, "POST", "/api/swamp", fields, owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Invalid recurrence rule: FREQ must be DAILY, WEEKLY or MONTHLY")
+
+		fields["Recurrence"] = "FREQ=WEEKLY;BYDAY=TU"
+		rec = serve(router, "POST", "/api/swamp", fields, owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "StartTime must be an occurrence of the Recurrence")
+
+		fields["Recurrence"] = "rrule:freq=weekly;byday=mo,th;count=6"
+		fields["RecurrenceExceptions"] = []string{stamp(day(1))}
+		rec = serve(router, "POST", "/api/swamp", fields, owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "RecurrenceExceptions must be occurrences of the Recurrence")
+
+		fields["RecurrenceExceptions"] = []string{stamp(day(3))}
+		rec = serve(router, "POST", "/api/swamp", fields, owner)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var body struct {
+			Swamp models.Swamp `json:"swamp"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		swamp = body.Swamp
+		if assert.NotNil(t, swamp.Recurrence) {
+			assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=6", swamp.Recurrence.Rule)
+			assert.Equal(t, "Etc/GMT-2", swamp.Recurrence.Timezone)
+		}
+		assert.True(t, swamp.StartTime.Equal(base))
+
+		// Listings skip the series once its last occurrence is over
+		var series models.SwampRecurrence
+		database.DB.Where("swamp_id = ?", swamp.ID).First(&series)
+		if assert.NotNil(t, series.LastStart) {
+			assert.True(t, series.LastStart.Equal(day(17)))
+		}
+	})
+	path := fmt.Sprintf("/api/swamp/%d", swamp.ID)
+
+	type listing struct {
+		Occurrences []models.Occurrence `json:"occurrences"`
+	}
+	occurrences := func() []models.Occurrence {
+		rec := serve(router, "GET", path+"/occurrences?"+window, nil, stranger)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var body listing
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body.Occurrences
+	}
+	stored := func() models.Swamp {
+		var current models.Swamp
+		database.DB.First(&current, swamp.ID)
+		return current
+	}
+
+	t.Run("occurrences within a range", func(t *testing.T) {
+		listed := occurrences()
+		if assert.Len(t, listed, 6) {
+			assert.True(t, listed[1].StartTime.Equal(day(3)))
+			assert.True(t, listed[1].Cancelled)
+			assert.True(t, listed[5].StartTime.Equal(day(17)))
+		}
+
+		rec := serve(router, "GET", path+"/occurrences?from=yesterday", nil, stranger)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		rec = serve(router, "GET", path+"/occurrences?from="+url.QueryEscape(stamp(base))+"&to="+url.QueryEscape(stamp(day(400))), nil, stranger)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		// Far-off ranges are clamped instead of expanding series for centuries
+		rec = serve(router, "GET", path+"/occurrences?from=2900-01-01T00:00:00Z&to=2900-06-01T00:00:00Z", nil, stranger)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"totalResults":0`)
+		rec = serve(router, "GET", "/api/swamp/occurrences?from=2900-01-01T00:00:00Z", nil, stranger)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"totalResults":0`)
+	})
+
+	t.Run("edit one occurrence", func(t *testing.T) {
+		target := path + "/occurrences/" + stamp(day(7))
+		edit := map[string]interface{}{"startTime": stamp(day(7).Add(time.Hour)), "duration": 45}
+		assert.Equal(t, http.StatusForbidden, serve(router, "PATCH", target, edit, stranger).Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, "PATCH", path+"/occurrences/"+stamp(day(8)), edit, owner).Code)
+		assert.Equal(t, http.StatusConflict, serve(router, "PATCH", path+"/occurrences/"+stamp(day(3)), edit, owner).Code)
+		assert.Equal(t, http.StatusBadRequest, serve(router, "PATCH", target, map[string]int{"duration": -5}, owner).Code)
+		assert.Equal(t, http.StatusBadRequest, serve(router, "PATCH", target, map[string]string{"startTime": stamp(time.Now().Add(-time.Hour))}, owner).Code)
+
+		rec := serve(router, "PATCH", target, edit, owner)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"modified":true`)
+
+		listed := occurrences()
+		if assert.Len(t, listed, 6) {
+			assert.True(t, listed[2].OriginalStart.Equal(day(7)))
+			assert.True(t, listed[2].StartTime.Equal(day(7).Add(time.Hour)))
+			assert.Equal(t, 45, listed[2].Duration)
+			assert.True(t, listed[3].StartTime.Equal(day(10)))
+			assert.Equal(t, 15, listed[3].Duration)
+		}
+	})
+
+	t.Run("cancel one occurrence", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve(router, "DELETE", path+"/occurrences/"+stamp(base), nil, owner).Code)
+
+		// The swamp moves on to the next occurrence that still happens
+		current := stored()
+		assert.True(t, current.StartTime.Equal(day(7).Add(time.Hour)), current.StartTime)
+		assert.Equal(t, 45, current.Duration)
+		assert.True(t, occurrences()[0].Cancelled)
+	})
+
+	t.Run("listing across swamps", func(t *testing.T) {
+		oneOff := models.Swamp{UUID: "one-off", Title: "Retro", OwnerID: int(owner.ID), StartTime: day(2), Duration: 60, Status: models.SwampStatusScheduled}
+		database.DB.Create(&oneOff)
+		later := models.Swamp{UUID: "later", Title: "Planning", OwnerID: int(owner.ID), StartTime: day(60), Duration: 60, Status: models.SwampStatusScheduled}
+		database.DB.Create(&later)
+
+		rec := serve(router, "GET", "/api/swamp/occurrences?"+window, nil, stranger)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var body struct {
+			AllDocuments []struct {
+				Swamp      models.Swamp      `json:"swamp"`
+				Occurrence models.Occurrence `json:"occurrence"`
+			} `json:"allDocuments"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		var titles []string
+		for _, item := range body.AllDocuments {
+			titles = append(titles, item.Swamp.Title)
+		}
+		assert.Equal(t, []string{"Retro", "Standup", "Standup", "Standup", "Standup"}, titles)
+
+		rec = serve(router, "GET", "/api/swamp/occurrences?q=retro&"+window, nil, stranger)
+		assert.Contains(t, rec.Body.String(), `"totalResults":1`)
+	})
+
+	t.Run("edit the series", func(t *testing.T) {
+		rec := serve(router, "PATCH", path, map[string]string{"Recurrence": "FREQ=WEEKLY;COUNT=2"}, owner)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		// Overrides belonged to the old rule
+		listed := occurrences()
+		if assert.Len(t, listed, 2) {
+			assert.False(t, listed[0].Cancelled)
+			assert.True(t, listed[1].StartTime.Equal(day(7)))
+		}
+		assert.True(t, stored().StartTime.Equal(base))
+
+		rec = serve(router, "PATCH", path, map[string]interface{}{"Duration": 30}, owner)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 30, occurrences()[1].Duration)
+		assert.Equal(t, 30, stored().Duration)
+	})
+
+	t.Run("stop repeating", func(t *testing.T) {
+		rec := serve(router, "PATCH", path, map[string]interface{}{"Recurrence": "", "RecurrenceExceptions": []string{stamp(base)}}, owner)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = serve(router, "PATCH", path, map[string]string{"Recurrence": ""}, owner)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotContains(t, rec.Body.String(), `"Recurrence"`)
+		assert.Len(t, occurrences(), 1)
+		assert.Equal(t, http.StatusConflict, serve(router, "DELETE", path+"/occurrences/"+stamp(base), nil, owner).Code)
+	})
+}
+
+func TestCalendar(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.Swamp{}, &models.SwampMember{}, &models.SwampInvitation{}, &models.Topic{}, &models.SwampTopic{},
+		&models.UserTopic{}, &models.SwampRecurrence{}, &models.SwampOccurrence{}, &models.SwampRSVP{}, &models.CalendarFeed{})
+
+	owner := models.User{Email: "owner@example.com", FullName: "Owner"}
+	fan := models.User{Email: "fan@example.com", FullName: "Fan"}
+	guest := models.User{Email: "guest@example.com", FullName: "Guest"}
+	stranger := models.User{Email: "stranger@example.com", FullName: "Stranger"}
+	for _, user := range []*models.User{&owner, &fan, &guest, &stranger} {
+		database.DB.Create(user)
+	}
+	goTopic := models.Topic{Name: "Go"}
+	rust := models.Topic{Name: "Rust"}
+	database.DB.Create(&goTopic)
+	database.DB.Create(&rust)
+	database.DB.Create(&models.UserTopic{UserID: fan.ID, TopicID: goTopic.ID})
+
+	tomorrow := time.Now().UTC().Truncate(time.Minute).Add(24 * time.Hour)
+	swamp := func(uuid string, topic models.Topic, start time.Time, visibility, status string) models.Swamp {
+		s := models.Swamp{UUID: uuid, Title: "Swamp " + uuid, OwnerID: int(owner.ID), StartTime: start, Duration: 30,
+			TopicID: topic.ID, Visibility: visibility, Status: status}
+		database.DB.Create(&s)
+		database.DB.Create(&models.SwampTopic{SwampID: uint(s.ID), TopicID: topic.ID})
+		return s
+	}
+	goTalk := swamp("go-talk", goTopic, tomorrow, models.SwampVisibilityPublic, models.SwampStatusScheduled)
+	rustTalk := swamp("rust-talk", rust, tomorrow.Add(time.Hour), models.SwampVisibilityPublic, models.SwampStatusScheduled)
+	database.DB.Create(&models.SwampTopic{SwampID: uint(rustTalk.ID), TopicID: goTopic.ID})
+	private := swamp("private", goTopic, tomorrow, models.SwampVisibilityPrivate, models.SwampStatusScheduled)
+	hidden := swamp("hidden", goTopic, tomorrow, models.SwampVisibilityUnlisted, models.SwampStatusScheduled)
+	swamp("old", goTopic, time.Now().Add(-60*24*time.Hour), models.SwampVisibilityPublic, models.SwampStatusEnded)
+	swamp("called-off", goTopic, tomorrow, models.SwampVisibilityPublic, models.SwampStatusCancelled)
+	standup := swamp("standup", rust, tomorrow, models.SwampVisibilityPublic, models.SwampStatusScheduled)
+	seriesStart := tomorrow.Add(-60 * 24 * time.Hour)
+	database.DB.Create(&models.SwampRecurrence{SwampID: uint(standup.ID), Rule: "FREQ=DAILY", Start: seriesStart, Duration: 15, Timezone: "UTC"})
+	database.DB.Create(&models.SwampOccurrence{SwampID: uint(standup.ID), OriginalStart: tomorrow.Add(24 * time.Hour), Cancelled: true})
+
+	router := chi.NewRouter()
+	router.Get("/api/calendar/{token}.ics", controllers.CalendarFeed)
+	router.Get("/api/swamp/{id}/calendar.ics", controllers.GetSwampCalendar)
+	router.Put("/api/swamp/{id}/rsvp", controllers.RSVPSwamp)
+	router.Delete("/api/swamp/{id}/rsvp", controllers.CancelRSVP)
+	router.Get("/api/me/calendar-feed", controllers.GetCalendarFeed)
+	router.Post("/api/me/calendar-feed", controllers.CreateCalendarFeed)
+	router.Delete("/api/me/calendar-feed", controllers.DeleteCalendarFeed)
+
+	subscribe := func(caller models.User) string {
+		rec := serve(router, "POST", "/api/me/calendar-feed", nil, caller)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		var body struct {
+			FeedPath  string `json:"feedPath"`
+			FeedURL   string `json:"feedUrl"`
+			WebcalURL string `json:"webcalUrl"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.True(t, strings.HasSuffix(body.FeedURL, body.FeedPath))
+		assert.True(t, strings.HasPrefix(body.WebcalURL, "webcal://"))
+		return body.FeedPath
+	}
+	fetch := func(feedPath string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", feedPath, nil))
+		return rec
+	}
+	uids := func(ics string) []string {
+		var found []string
+		for _, line := range strings.Split(ics, "\r\n") {
+			if uid, ok := strings.CutPrefix(line, "UID:"); ok {
+				found = append(found, strings.TrimSuffix(uid, "@swamp"))
+			}
+		}
+		return found
+	}
+
+	t.Run("download a swamp", func(t *testing.T) {
+		rec := serve(router, "GET", fmt.Sprintf("/api/swamp/%d/calendar.ics", rustTalk.ID), nil, stranger)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/calendar; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Contains(t, rec.Header().Get("Content-Disposition"), fmt.Sprintf(`filename="swamp-%d.ics"`, rustTalk.ID))
+		ics := rec.Body.String()
+		assert.Equal(t, []string{"rust-talk"}, uids(ics))
+		assert.Contains(t, ics, "\r\nSUMMARY:Swamp rust-talk\r\n")
+		assert.Contains(t, ics, "\r\nDTSTART:"+tomorrow.Add(time.Hour).Format("20060102T150405Z")+"\r\n")
+		assert.Contains(t, ics, "\r\nDURATION:PT30M\r\n")
+		assert.Contains(t, ics, "\r\nDESCRIPTION:Topics: ")
+		assert.Contains(t, ics, "\r\nCATEGORIES:")
+
+		rec = serve(router, "GET", fmt.Sprintf("/api/swamp/%d/calendar.ics", standup.ID), nil, stranger)
+		ics = rec.Body.String()
+		assert.Contains(t, ics, "\r\nDTSTART:"+seriesStart.Format("20060102T150405Z")+"\r\n")
+		assert.Contains(t, ics, "\r\nRRULE:FREQ=DAILY\r\n")
+		assert.Contains(t, ics, "\r\nEXDATE:"+tomorrow.Add(24*time.Hour).Format("20060102T150405Z")+"\r\n")
+
+		privatePath := fmt.Sprintf("/api/swamp/%d/calendar.ics", private.ID)
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", privatePath, nil, stranger).Code)
+		assert.Equal(t, http.StatusOK, serve(router, "GET", privatePath, nil, owner).Code)
+	})
+
+	t.Run("rsvp", func(t *testing.T) {
+		path := fmt.Sprintf("/api/swamp/%d/rsvp", standup.ID)
+		assert.Equal(t, http.StatusOK, serve(router, "PUT", path, nil, guest).Code)
+		assert.Equal(t, http.StatusOK, serve(router, "PUT", path, nil, guest).Code)
+		var count int64
+		database.DB.Model(&models.SwampRSVP{}).Where("user_id = ?", guest.ID).Count(&count)
+		assert.Equal(t, int64(1), count)
+
+		assert.Equal(t, http.StatusNotFound, serve(router, "PUT", fmt.Sprintf("/api/swamp/%d/rsvp", private.ID), nil, guest).Code)
+		var old models.Swamp
+		database.DB.Where("uuid = ?", "old").First(&old)
+		assert.Equal(t, http.StatusConflict, serve(router, "PUT", fmt.Sprintf("/api/swamp/%d/rsvp", old.ID), nil, guest).Code)
+
+		// Unlisted swamps are RSVP'd to through their link
+		assert.Equal(t, http.StatusNotFound, serve(router, "PUT", fmt.Sprintf("/api/swamp/%d/rsvp", hidden.ID), nil, guest).Code)
+		assert.Equal(t, http.StatusOK, serve(router, "PUT", "/api/swamp/"+hidden.UUID+"/rsvp", nil, guest).Code)
+
+		assert.Equal(t, http.StatusOK, serve(router, "PUT", fmt.Sprintf("/api/swamp/%d/rsvp", goTalk.ID), nil, guest).Code)
+		assert.Equal(t, http.StatusNoContent, serve(router, "DELETE", fmt.Sprintf("/api/swamp/%d/rsvp", goTalk.ID), nil, guest).Code)
+		database.DB.Model(&models.SwampRSVP{}).Where("user_id = ?", guest.ID).Count(&count)
+		assert.Equal(t, int64(2), count)
+	})
+
+	t.Run("feeds list owned, rsvp'd and matching swamps", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, fetch("/api/calendar/unknown.ics").Code)
+
+		rec := fetch(subscribe(fan))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/calendar; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.ElementsMatch(t, []string{"go-talk", "rust-talk"}, uids(rec.Body.String()))
+
+		// Unlisted swamps only come in once RSVP'd to
+		rec = fetch(subscribe(guest))
+		assert.ElementsMatch(t, []string{"standup", "hidden"}, uids(rec.Body.String()))
+		assert.Contains(t, rec.Body.String(), "\r\nRRULE:FREQ=DAILY\r\n")
+
+		rec = fetch(subscribe(owner))
+		assert.ElementsMatch(t, []string{"go-talk", "rust-talk", "private", "hidden", "standup"}, uids(rec.Body.String()))
+
+		assert.Empty(t, uids(fetch(subscribe(stranger)).Body.String()))
+	})
+
+	t.Run("past swamps do not crowd out upcoming ones", func(t *testing.T) {
+		past := make([]models.Swamp, 500)
+		for i := range past {
+			past[i] = models.Swamp{UUID: fmt.Sprintf("past-%d", i), Title: "Past", OwnerID: int(owner.ID), Duration: 30,
+				StartTime: time.Now().Add(-time.Duration(i+1) * time.Hour), TopicID: goTopic.ID, Status: models.SwampStatusEnded}
+		}
+		database.DB.CreateInBatches(&past, 100)
+		defer database.DB.Where("uuid LIKE ?", "past-%").Delete(&models.Swamp{})
+
+		listed := uids(fetch(subscribe(fan)).Body.String())
+		assert.Len(t, listed, 500)
+		assert.Contains(t, listed, "go-talk")
+		assert.Contains(t, listed, "rust-talk")
+		assert.Contains(t, listed, "past-0")
+		assert.NotContains(t, listed, "past-499")
+	})
+
+	t.Run("series start on their first occurrence", func(t *testing.T) {
+		// Saved before Start had to match the rule
+		legacy := swamp("legacy", rust, tomorrow, models.SwampVisibilityPublic, models.SwampStatusScheduled)
+		defer database.DB.Delete(&legacy)
+		day := []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}[tomorrow.Add(24*time.Hour).Weekday()]
+		database.DB.Create(&models.SwampRecurrence{SwampID: uint(legacy.ID), Rule: "FREQ=WEEKLY;BYDAY=" + day, Start: tomorrow, Duration: 30, Timezone: "UTC"})
+
+		ics := serve(router, "GET", fmt.Sprintf("/api/swamp/%d/calendar.ics", legacy.ID), nil, stranger).Body.String()
+		assert.Contains(t, ics, "\r\nDTSTART:"+tomorrow.Add(24*time.Hour).Format("20060102T150405Z")+"\r\n")
+		assert.NotContains(t, ics, "\r\nDTSTART:"+tomorrow.Format("20060102T150405Z")+"\r\n")
+	})
+
+	t.Run("rotate and delete the feed", func(t *testing.T) {
+		first := subscribe(fan)
+		fetch(first)
+		rec := serve(router, "GET", "/api/me/calendar-feed", nil, fan)
+		assert.Contains(t, rec.Body.String(), `"enabled":true`)
+		assert.NotContains(t, rec.Body.String(), `"lastUsedAt":null`)
+		assert.NotContains(t, rec.Body.String(), "token")
+
+		second := subscribe(fan)
+		assert.NotEqual(t, first, second)
+		assert.Equal(t, http.StatusNotFound, fetch(first).Code)
+		assert.Equal(t, http.StatusOK, fetch(second).Code)
+
+		assert.Equal(t, http.StatusNoContent, serve(router, "DELETE", "/api/me/calendar-feed", nil, fan).Code)
+		assert.Equal(t, http.StatusNotFound, fetch(second).Code)
+		assert.Contains(t, serve(router, "GET", "/api/me/calendar-feed", nil, fan).Body.String(), `"enabled":false`)
+	})
+}
+
+func TestPasswordReset(t *testing.T) {
+	initTestDBForOTP(t)
+	controllers.ResetRateLimits()
+	outbox := mail.NewMemoryMailer()
+	controllers.Mailer = outbox
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("oldpass1"), bcrypt.DefaultCost)
+	database.DB.Create(&models.User{
+		Email:    "reset@example.com",
+		FullName: "Reset User",
+		Password: string(hashedPassword),
+	})
+	oldSession := loginForTokens(t, "reset@example.com", "oldpass1")
+
+	forgot := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/password/forgot", bytes.NewBuffer(mustJSON(map[string]string{"email": email})))
+		rec := httptest.NewRecorder()
+		controllers.ForgotPassword(rec, req)
+		return rec
+	}
+	resetFor := func(email, code, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/password/reset", bytes.NewBuffer(mustJSON(map[string]string{
+			"email":    email,
+			"otp":      code,
+			"password": password,
+		})))
+		rec := httptest.NewRecorder()
+		controllers.ResetPassword(rec, req)
+		return rec
+	}
+	reset := func(code, password string) *httptest.ResponseRecorder {
+		return resetFor("reset@example.com", code, password)
+	}
+
+	t.Run("unknown email gets the same response without mail", func(t *testing.T) {
+		rec := forgot("nobody@example.com")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		_, sent := outbox.Last("nobody@example.com")
+		assert.False(t, sent)
+	})
+
+	t.Run("limits do not reveal which emails have accounts", func(t *testing.T) {
+		controllers.ResetRateLimits()
+		assert.Equal(t, http.StatusOK, forgot("reset@example.com").Code)
+		assert.Equal(t, http.StatusOK, forgot("ghost@example.com").Code)
+		assert.Equal(t, http.StatusTooManyRequests, forgot("reset@example.com").Code)
+		assert.Equal(t, http.StatusTooManyRequests, forgot("ghost@example.com").Code)
+
+		// A locked code stays locked, silently
+		database.DB.Model(&models.OTP{}).Where("email = ?", "reset@example.com").
+			Update("locked_until", time.Now().Add(time.Hour))
+		controllers.ResetRateLimits()
+		assert.Equal(t, http.StatusOK, forgot("reset@example.com").Code)
+		assert.Equal(t, http.StatusOK, forgot("ghost2@example.com").Code)
+		database.DB.Where("email = ?", "reset@example.com").Delete(&models.OTP{})
+		controllers.ResetRateLimits()
+	})
+
+	t.Run("signup code cannot reset a password", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, requestOTP("crossflow@example.com").Code)
+		signupCode := otpFromMail(t, outbox, "crossflow@example.com")
+		database.DB.Create(&models.User{Email: "crossflow@example.com", FullName: "Cross Flow"})
+
+		rec := resetFor("crossflow@example.com", signupCode, "Newpass12")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("valid reset code", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, forgot("reset@example.com").Code)
+		code := otpFromMail(t, outbox, "reset@example.com")
+
+		rec := reset(code, "Newpass12")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"message":"Password reset successfully"`)
+
+		// Existing sessions are revoked and only the new password works
+		assert.Equal(t, http.StatusUnauthorized, postRefresh(controllers.RefreshSession, oldSession["refreshToken"].(string)).Code)
+		loginForTokens(t, "reset@example.com", "Newpass12")
+
+		// The code is single-use
+		assert.Equal(t, http.StatusUnauthorized, reset(code, "Another12").Code)
+	})
+}
+
+func TestVerifyOTPSignup(t *testing.T) {
+	initTestDBForOTP(t)
+	controllers.ResetRateLimits()
+	outbox := mail.NewMemoryMailer()
+	controllers.Mailer = outbox
+
+	database.DB.Create(&models.User{Email: "taken@example.com", FullName: "Taken"})
+
+	t.Run("existing email cannot request a signup code", func(t *testing.T) {
+		rec := requestOTP("taken@example.com")
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		_, sent := outbox.Last("taken@example.com")
+		assert.False(t, sent)
+	})
+
+	t.Run("existing email cannot sign up", func(t *testing.T) {
+		rec := verifyOTP("taken@example.com", "123456")
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"error": "An account with this email already exists"`)
+	})
+
+	t.Run("missing password is rejected", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/verify-otp", bytes.NewBuffer(mustJSON(map[string]string{
+			"email":    "new@example.com",
+			"otp":      "123456",
+			"fullName": "New User",
+		})))
+		rec := httptest.NewRecorder()
+		controllers.VerifyOTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("new account", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, requestOTP("new@example.com").Code)
+		code := otpFromMail(t, outbox, "new@example.com")
+
+		assert.Equal(t, http.StatusOK, verifyOTP("new@example.com", code).Code)
+		assert.True(t, loginForTokens(t, "new@example.com", "Secure123!")["accessToken"] != "")
+	})
+}
+
+// authedRequest builds a request carrying the current DB state of the user,
+// the way RequireAuth would.
+func authedRequest(method, target string, body interface{}, userID uint) *http.Request {
+	var user models.User
+	database.DB.First(&user, userID)
+	req := httptest.NewRequest(method, target, bytes.NewBuffer(mustJSON(body)))
+	return req.WithContext(middleware.WithUser(req.Context(), &user))
+}
+
+// serve sends an authedRequest from caller through router.
+func serve(router http.Handler, method, target string, body interface{}, caller models.User) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, authedRequest(method, target, body, caller.ID))
+	return rec
+}
+
+func TestTwoFactor(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.RecoveryCode{})
+	controllers.ResetRateLimits()
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("Secure123"), bcrypt.DefaultCost)
+	user := models.User{Email: "2fa@example.com", FullName: "Two Factor", Password: string(hashedPassword)}
+	database.DB.Create(&user)
+
+	// Enroll
+	rec := httptest.NewRecorder()
+	controllers.SetupTwoFactor(rec, authedRequest("POST", "/2fa/setup", nil, user.ID))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var setup map[string]string
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &setup))
+	secret := setup["secret"]
+	assert.Contains(t, setup["otpauthUri"], "otpauth://totp/")
+
+	code, _ := totp.CodeAt(secret, totp.Step(time.Now()))
+	rec = httptest.NewRecorder()
+	controllers.ConfirmTwoFactor(rec, authedRequest("POST", "/2fa/confirm", map[string]string{"code": code}, user.ID))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var confirm struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &confirm))
+	assert.Len(t, confirm.RecoveryCodes, 10)
+
+	// Password login now only yields a challenge
+	challenge := loginForTokens(t, "2fa@example.com", "Secure123")
+	assert.Equal(t, true, challenge["twoFactorRequired"])
+	assert.Nil(t, challenge["accessToken"])
+	challengeToken := challenge["challengeToken"].(string)
+	_, err := auth.ParseAccessToken(challengeToken)
+	assert.Error(t, err, "challenge tokens must not work as access tokens")
+
+	secondStep := func(code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login/2fa", bytes.NewBuffer(mustJSON(map[string]string{
+			"challengeToken": challengeToken,
+			"code":           code,
+		})))
+		rec := httptest.NewRecorder()
+		controllers.LoginTwoFactor(rec, req)
+		return rec
+	}
+
+	t.Run("replayed code is rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, secondStep(code).Code)
+	})
+
+	t.Run("fresh code completes login", func(t *testing.T) {
+		next, _ := totp.CodeAt(secret, totp.Step(time.Now())+1)
+		rec := secondStep(next)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"accessToken":"`)
+	})
+
+	t.Run("recovery code works once", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, secondStep(strings.ToUpper(confirm.RecoveryCodes[0])).Code)
+		assert.Equal(t, http.StatusUnauthorized, secondStep(confirm.RecoveryCodes[0]).Code)
+	})
+
+	t.Run("guesses are rate limited", func(t *testing.T) {
+		controllers.ResetRateLimits()
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusUnauthorized, secondStep("zzzzz-zzzzz").Code)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, secondStep(confirm.RecoveryCodes[1]).Code)
+	})
+
+	t.Run("parallel guesses share the limit", func(t *testing.T) {
+		controllers.ResetRateLimits()
+		sqlDB, _ := database.DB.DB()
+		sqlDB.SetMaxOpenConns(1)
+
+		var wg sync.WaitGroup
+		codes := make([]int, 20)
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				codes[i] = secondStep("zzzzz-zzzzz").Code
+			}(i)
+		}
+		wg.Wait()
+
+		rejected := 0
+		for _, status := range codes {
+			if status == http.StatusUnauthorized {
+				rejected++
+			}
+		}
+		assert.Equal(t, 5, rejected)
+		assert.Equal(t, http.StatusTooManyRequests, secondStep(confirm.RecoveryCodes[1]).Code)
+	})
+}
+
+func TestSSOLogin(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.UserIdentity{}, &models.OIDCLoginState{})
+
+	issuer := ssotest.NewIssuer("swamp-client", "swamp-secret")
+	defer issuer.Close()
+
+	provider, err := sso.NewProvider(context.Background(), sso.ProviderConfig{
+		Name:         "corp",
+		Issuer:       issuer.URL(),
+		ClientID:     "swamp-client",
+		ClientSecret: "swamp-secret",
+		RedirectURL:  "http://localhost:5173/sso/callback",
+	})
+	assert.NoError(t, err)
+	controllers.SSOProviders = sso.NewRegistry(provider)
+	defer func() { controllers.SSOProviders = sso.NewRegistry() }()
+
+	router := chi.NewRouter()
+	router.Get("/api/auth/oidc/{provider}/authorize", controllers.StartSSOLogin)
+	router.Post("/api/auth/oidc/{provider}/callback", controllers.FinishSSOLogin)
+
+	// login walks the browser through the provider and returns the callback response
+	login := func(claims ssotest.Claims) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/auth/oidc/corp/authorize", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var start map[string]string
+		json.Unmarshal(rec.Body.Bytes(), &start)
+		authURL, err := url.Parse(start["authorizationUrl"])
+		assert.NoError(t, err)
+		query := authURL.Query()
+		assert.Equal(t, "S256", query.Get("code_challenge_method"))
+		assert.Equal(t, start["state"], query.Get("state"))
+
+		cookies := rec.Result().Cookies()
+		if assert.Len(t, cookies, 1) {
+			assert.True(t, cookies[0].HttpOnly)
+			assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+		}
+
+		code := issuer.Authorize(query.Get("nonce"), query.Get("code_challenge"), claims)
+		body := mustJSON(map[string]string{"code": code, "state": query.Get("state")})
+		req := httptest.NewRequest("POST", "/api/auth/oidc/corp/callback", bytes.NewBuffer(body))
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("first login creates a user", func(t *testing.T) {
+		rec := login(ssotest.Claims{Subject: "sub-1", Email: "New.Person@corp.example", EmailVerified: true, Name: "New Person"})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"accessToken":"`)
+
+		var user models.User
+		assert.NoError(t, database.DB.Where("email = ?", "new.person@corp.example").First(&user).Error)
+		assert.Equal(t, "New Person", user.FullName)
+	})
+
+	t.Run("later logins reuse the linked user", func(t *testing.T) {
+		rec := login(ssotest.Claims{Subject: "sub-1", Email: "renamed@corp.example", EmailVerified: true})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"email":"new.person@corp.example"`)
+
+		var count int64
+		database.DB.Model(&models.User{}).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("verified email links an existing account", func(t *testing.T) {
+		existing := models.User{Email: "member@corp.example", FullName: "Member"}
+		database.DB.Create(&existing)
+
+		rec := login(ssotest.Claims{Subject: "sub-2", Email: "member@corp.example", EmailVerified: true})
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var link models.UserIdentity
+		assert.NoError(t, database.DB.Where("provider = ? AND subject = ?", "corp", "sub-2").First(&link).Error)
+		assert.Equal(t, existing.ID, link.UserID)
+	})
+
+	t.Run("unverified email is refused", func(t *testing.T) {
+		rec := login(ssotest.Claims{Subject: "sub-3", Email: "member@corp.example", EmailVerified: false})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("two-factor users get a challenge", func(t *testing.T) {
+		database.DB.Model(&models.User{}).Where("email = ?", "member@corp.example").Update("totp_enabled", true)
+		rec := login(ssotest.Claims{Subject: "sub-2", Email: "member@corp.example", EmailVerified: true})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"twoFactorRequired":true`)
+		assert.NotContains(t, rec.Body.String(), `"accessToken"`)
+	})
+
+	t.Run("state is single use and bound to the browser", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/auth/oidc/corp/authorize", nil))
+		var start map[string]string
+		json.Unmarshal(rec.Body.Bytes(), &start)
+		authURL, _ := url.Parse(start["authorizationUrl"])
+		query := authURL.Query()
+
+		cookies := rec.Result().Cookies()
+
+		claims := ssotest.Claims{Subject: "sub-1", Email: "new.person@corp.example", EmailVerified: true}
+		callback := func(cookies []*http.Cookie) int {
+			code := issuer.Authorize(query.Get("nonce"), query.Get("code_challenge"), claims)
+			body := mustJSON(map[string]string{"code": code, "state": start["state"]})
+			req := httptest.NewRequest("POST", "/api/auth/oidc/corp/callback", bytes.NewBuffer(body))
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		// Another browser, or one that started a different login, is refused
+		// without using up the state
+		assert.Equal(t, http.StatusUnauthorized, callback(nil))
+		other := httptest.NewRecorder()
+		router.ServeHTTP(other, httptest.NewRequest("GET", "/api/auth/oidc/corp/authorize", nil))
+		assert.Equal(t, http.StatusUnauthorized, callback(other.Result().Cookies()))
+
+		assert.Equal(t, http.StatusOK, callback(cookies))
+		assert.Equal(t, http.StatusUnauthorized, callback(cookies))
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/auth/oidc/nope/authorize", nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestSwampRoles(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.Swamp{}, &models.SwampMember{})
+
+	owner := models.User{Email: "owner@example.com", FullName: "Owner"}
+	coHost := models.User{Email: "cohost@example.com", FullName: "Co Host"}
+	stranger := models.User{Email: "stranger@example.com", FullName: "Stranger"}
+	admin := models.User{Email: "admin@example.com", FullName: "Admin", Role: models.RoleAdmin}
+	for _, u := range []*models.User{&owner, &coHost, &stranger, &admin} {
+		database.DB.Create(u)
+	}
+	swamp := models.Swamp{UUID: "room-1", Title: "Roles", OwnerID: int(owner.ID), TopicID: 1}
+	database.DB.Create(&swamp)
+
+	router := chi.NewRouter()
+	router.Post("/api/swamp/{id}/co-hosts", controllers.AddCoHost)
+	router.Delete("/api/swamp/{id}/co-hosts/{userID}", controllers.RemoveCoHost)
+	router.Post("/api/swamp/{id}/participants/{userID}/remove", controllers.RemoveParticipant)
+	router.Put("/api/admin/users/{userID}/role", controllers.SetUserRole)
+
+	coHosts := fmt.Sprintf("/api/swamp/%d/co-hosts", swamp.ID)
+
+	t.Run("stranger cannot appoint co-hosts", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(router, "POST", coHosts, map[string]uint{"userId": coHost.ID}, stranger).Code)
+	})
+
+	t.Run("owner appoints a co-host", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, serve(router, "POST", coHosts, map[string]uint{"userId": coHost.ID}, owner).Code)
+		assert.Equal(t, http.StatusCreated, serve(router, "POST", coHosts, map[string]uint{"userId": coHost.ID}, owner).Code)
+
+		var count int64
+		database.DB.Model(&models.SwampMember{}).Where("swamp_id = ?", swamp.ID).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("co-host cannot appoint others", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(router, "POST", coHosts, map[string]uint{"userId": stranger.ID}, coHost).Code)
+	})
+
+	t.Run("co-host may moderate but the room is not live", func(t *testing.T) {
+		target := fmt.Sprintf("/api/swamp/%d/participants/%d/remove", swamp.ID, stranger.ID)
+		assert.Equal(t, http.StatusConflict, serve(router, "POST", target, nil, coHost).Code)
+		assert.Equal(t, http.StatusForbidden, serve(router, "POST", target, nil, stranger).Code)
+	})
+
+	t.Run("admin removes a co-host", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve(router, "DELETE", fmt.Sprintf("%s/%d", coHosts, coHost.ID), nil, admin).Code)
+	})
+
+	t.Run("unknown swamp", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve(router, "POST", "/api/swamp/999/co-hosts", map[string]uint{"userId": coHost.ID}, owner).Code)
+	})
+
+	t.Run("admin changes a role and revokes sessions", func(t *testing.T) {
+		target := fmt.Sprintf("/api/admin/users/%d/role", stranger.ID)
+		assert.Equal(t, http.StatusBadRequest, serve(router, "PUT", target, map[string]string{"role": "superuser"}, admin).Code)
+		assert.Equal(t, http.StatusOK, serve(router, "PUT", target, map[string]string{"role": models.RoleModerator}, admin).Code)
+
+		var updated models.User
+		database.DB.First(&updated, stranger.ID)
+		assert.Equal(t, models.RoleModerator, updated.Role)
+		assert.Equal(t, stranger.TokenVersion+1, updated.TokenVersion)
+	})
+
+	t.Run("admin cannot demote themselves", func(t *testing.T) {
+		target := fmt.Sprintf("/api/admin/users/%d/role", admin.ID)
+		assert.Equal(t, http.StatusBadRequest, serve(router, "PUT", target, map[string]string{"role": models.RoleUser}, admin).Code)
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.APIKey{})
+
+	owner := models.User{Email: "keys@example.com", FullName: "Key Owner"}
+	other := models.User{Email: "other@example.com", FullName: "Other"}
+	database.DB.Create(&owner)
+	database.DB.Create(&other)
+
+	create := func(body interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		controllers.CreateAPIKey(rec, authedRequest("POST", "/api/me/api-keys", body, owner.ID))
+		return rec
+	}
+
+	t.Run("rejects unknown scopes and past expiry", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, create(map[string]interface{}{"name": "bot", "scopes": []string{"admin"}}).Code)
+		assert.Equal(t, http.StatusBadRequest, create(map[string]interface{}{
+			"name": "bot", "scopes": []string{models.APIScopeChatWrite}, "expiresAt": time.Now().Add(-time.Hour),
+		}).Code)
+	})
+
+	rec := create(map[string]interface{}{"name": "deploy bot", "scopes": []string{models.APIScopeSwampsWrite, models.APIScopeChatWrite}})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var created struct {
+		Key    string                 `json:"key"`
+		APIKey map[string]interface{} `json:"apiKey"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.True(t, strings.HasPrefix(created.Key, auth.APIKeyPrefix))
+	assert.True(t, strings.HasPrefix(created.Key, created.APIKey["prefix"].(string)))
+
+	t.Run("only the hash is stored", func(t *testing.T) {
+		var stored models.APIKey
+		database.DB.First(&stored)
+		assert.Equal(t, auth.HashToken(created.Key), stored.KeyHash)
+		assert.NotContains(t, stored.KeyHash, created.Key)
+	})
+
+	t.Run("list never includes the key", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		controllers.ListAPIKeys(rec, authedRequest("GET", "/api/me/api-keys", nil, owner.ID))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"name":"deploy bot"`)
+		assert.NotContains(t, rec.Body.String(), created.Key)
+		assert.NotContains(t, rec.Body.String(), "hash")
+	})
+
+	router := chi.NewRouter()
+	router.Delete("/api/me/api-keys/{id}", controllers.RevokeAPIKey)
+	revoke := func(caller models.User) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, authedRequest("DELETE", fmt.Sprintf("/api/me/api-keys/%v", created.APIKey["id"]), nil, caller.ID))
+		return rec.Code
+	}
+
+	t.Run("other users cannot revoke it", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, revoke(other))
+	})
+
+	t.Run("owner revokes it", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, revoke(owner))
+		_, _, err := middleware.UserFromAPIKey(created.Key, models.APIScopeChatWrite)
+		assert.Error(t, err)
+	})
+}
+
+func TestAccountSelfService(t *testing.T) {
+	initTestDBForOTP(t)
+	database.DB.AutoMigrate(&models.APIKey{}, &models.RecoveryCode{}, &models.UserIdentity{}, &models.UserTopic{},
+		&models.SwampMember{}, &models.SwampInvitation{}, &models.ChatMessage{}, &models.Swamp{}, &models.SwampRecurrence{},
+		&models.SwampRSVP{}, &models.CalendarFeed{})
+	controllers.ResetRateLimits()
+	outbox := mail.NewMemoryMailer()
+	controllers.Mailer = outbox
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("Secure123"), bcrypt.DefaultCost)
+	user := models.User{Email: "me@example.com", FullName: "Me Myself", Password: string(hashedPassword)}
+	database.DB.Create(&user)
+	database.DB.Create(&models.User{Email: "taken@example.com", FullName: "Taken"})
+
+	call := func(handler http.HandlerFunc, method string, body interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, authedRequest(method, "/api/me", body, user.ID))
+		return rec
+	}
+
+	t.Run("profile update validates fields", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			body           map[string]string
+			expectedStatus int
+		}{
+			{name: "bad timezone", body: map[string]string{"timezone": "Mars/Olympus"}, expectedStatus: http.StatusBadRequest},
+			{name: "bad avatar", body: map[string]string{"avatarUrl": "javascript:alert(1)"}, expectedStatus: http.StatusBadRequest},
+			{name: "long bio", body: map[string]string{"bio": strings.Repeat("x", 501)}, expectedStatus: http.StatusBadRequest},
+			{name: "short name", body: map[string]string{"fullName": "x"}, expectedStatus: http.StatusBadRequest},
+			{name: "valid", body: map[string]string{"bio": "Frog fan", "timezone": "Europe/Berlin", "avatarUrl": "https://cdn.example.com/me.png"}, expectedStatus: http.StatusOK},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.expectedStatus, call(controllers.UpdateMe, "PATCH", tt.body).Code)
+			})
+		}
+
+		rec := call(controllers.GetMe, "GET", nil)
+		assert.Contains(t, rec.Body.String(), `"bio":"Frog fan"`)
+		assert.Contains(t, rec.Body.String(), `"timezone":"Europe/Berlin"`)
+		assert.Contains(t, rec.Body.String(), `"fullName":"Me Myself"`)
+	})
+
+	t.Run("email change is confirmed by the new address", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, call(controllers.RequestEmailChange, "POST", map[string]string{"newEmail": "new@example.com", "password": "wrong"}).Code)
+		assert.Equal(t, http.StatusConflict, call(controllers.RequestEmailChange, "POST", map[string]string{"newEmail": "taken@example.com", "password": "Secure123"}).Code)
+		assert.Equal(t, http.StatusOK, call(controllers.RequestEmailChange, "POST", map[string]string{"newEmail": "new@example.com", "password": "Secure123"}).Code)
+
+		var unchanged models.User
+		database.DB.First(&unchanged, user.ID)
+		assert.Equal(t, "me@example.com", unchanged.Email)
+
+		assert.Equal(t, http.StatusUnauthorized, call(controllers.ConfirmEmailChange, "POST", map[string]string{"otp": "000000"}).Code)
+		code := otpFromMail(t, outbox, "new@example.com")
+		rec := call(controllers.ConfirmEmailChange, "POST", map[string]string{"otp": code})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"email":"new@example.com"`)
+	})
+
+	t.Run("password change needs the current password", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, call(controllers.ChangePassword, "POST", map[string]string{"currentPassword": "wrong", "newPassword": "Better456"}).Code)
+
+		rec := call(controllers.ChangePassword, "POST", map[string]string{"currentPassword": "Secure123", "newPassword": "Better456"})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"accessToken":"`)
+		loginForTokens(t, "new@example.com", "Better456")
+	})
+
+	t.Run("deletion anonymizes the account", func(t *testing.T) {
+		userID := user.ID
+		database.DB.Create(&models.ChatMessage{RoomUUID: "room", UserID: &userID, AuthorName: "Me Myself", Text: "hi"})
+		database.DB.Create(&models.Swamp{UUID: "future", OwnerID: int(user.ID), StartTime: time.Now().Add(time.Hour), TopicID: 1})
+		database.DB.Create(&models.Swamp{UUID: "past", Title: "Me Myself's frog club", OwnerID: int(user.ID), StartTime: time.Now().Add(-time.Hour), TopicID: 1})
+		series := models.Swamp{UUID: "series", OwnerID: int(user.ID), StartTime: time.Now().Add(-time.Minute), Duration: 30, Status: models.SwampStatusLive, TopicID: 1}
+		database.DB.Create(&series)
+		database.DB.Create(&models.SwampRecurrence{SwampID: uint(series.ID), Rule: "FREQ=DAILY", Start: series.StartTime, Duration: 30})
+		database.DB.Create(&models.SwampRSVP{SwampID: uint(series.ID), UserID: user.ID})
+		database.DB.Create(&models.CalendarFeed{UserID: user.ID, TokenHash: "feed"})
+
+		assert.Equal(t, http.StatusUnauthorized, call(controllers.DeleteMe, "DELETE", map[string]string{"password": "Secure123"}).Code)
+		assert.Equal(t, http.StatusNoContent, call(controllers.DeleteMe, "DELETE", map[string]string{"password": "Better456"}).Code)
+
+		var deleted models.User
+		assert.NoError(t, database.DB.Unscoped().First(&deleted, user.ID).Error)
+		assert.True(t, deleted.DeletedAt.Valid)
+		assert.Equal(t, "Deleted user", deleted.FullName)
+		assert.NotContains(t, deleted.Email, "example.com")
+		assert.Empty(t, deleted.Bio)
+
+		var message models.ChatMessage
+		database.DB.First(&message)
+		assert.Nil(t, message.UserID)
+		assert.Equal(t, "Deleted user", message.AuthorName)
+		assert.Empty(t, message.Text)
+
+		var future, past models.Swamp
+		database.DB.Where("uuid = ?", "future").First(&future)
+		database.DB.Where("uuid = ?", "past").First(&past)
+		assert.True(t, future.Deleted)
+		assert.False(t, past.Deleted)
+		assert.Equal(t, "Deleted swamp", past.Title)
+		database.DB.First(&series, series.ID)
+		assert.Equal(t, models.SwampStatusCancelled, series.Status)
+		var rsvps, feeds int64
+		database.DB.Model(&models.SwampRSVP{}).Where("user_id = ?", user.ID).Count(&rsvps)
+		database.DB.Model(&models.CalendarFeed{}).Where("user_id = ?", user.ID).Count(&feeds)
+		assert.Zero(t, rsvps)
+		assert.Zero(t, feeds)
+
+		// The address is free for a new signup
+		assert.Error(t, database.DB.Where("email = ?", "new@example.com").First(&models.User{}).Error)
+	})
+}
+
+func TestDataExport(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.DataExport{}, &models.Topic{}, &models.UserTopic{}, &models.Swamp{},
+		&models.ChatMessage{}, &models.APIKey{}, &models.UserIdentity{}, &models.SwampMember{},
+		&models.SwampInvitation{}, &models.SwampRSVP{}, &models.CalendarFeed{})
+	// The export runs on another goroutine; keep it on the same in-memory database
+	sqlDB, _ := database.DB.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Setenv("EXPORT_DIR", t.TempDir())
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("Secure123"), bcrypt.DefaultCost)
+	user := models.User{Email: "export@example.com", FullName: "Export Me", Password: string(hashedPassword), Bio: "Likes frogs"}
+	other := models.User{Email: "nosy@example.com", FullName: "Nosy"}
+	database.DB.Create(&user)
+	database.DB.Create(&other)
+	topic := models.Topic{Name: "Amphibians"}
+	database.DB.Create(&topic)
+	database.DB.Create(&models.UserTopic{UserID: user.ID, TopicID: topic.ID})
+	database.DB.Create(&models.Swamp{UUID: "mine", Title: "My Swamp", OwnerID: int(user.ID), TopicID: topic.ID})
+	theirs := models.Swamp{UUID: "theirs", Title: "Their Swamp", OwnerID: int(other.ID), TopicID: topic.ID}
+	database.DB.Create(&theirs)
+	database.DB.Create(&models.SwampMember{SwampID: uint(theirs.ID), UserID: user.ID, Role: models.SwampRoleCoHost})
+	userID := user.ID
+	database.DB.Create(&models.ChatMessage{RoomUUID: "mine", UserID: &userID, AuthorName: user.FullName, Text: "ribbit"})
+	database.DB.Create(&models.SwampInvitation{SwampID: uint(theirs.ID), UserID: &userID, InvitedBy: other.ID})
+	database.DB.Create(&models.SwampInvitation{SwampID: uint(theirs.ID), Email: "export@example.com", InvitedBy: other.ID})
+	database.DB.Create(&models.SwampInvitation{SwampID: uint(theirs.ID), Email: "someone@example.com", InvitedBy: other.ID})
+	database.DB.Create(&models.SwampRSVP{SwampID: uint(theirs.ID), UserID: user.ID})
+	database.DB.Create(&models.CalendarFeed{UserID: user.ID, TokenHash: "feed-token-hash"})
+	loginForTokens(t, "export@example.com", "Secure123")
+
+	router := chi.NewRouter()
+	router.Post("/api/me/exports", controllers.RequestDataExport)
+	router.Get("/api/me/exports/{id}", controllers.GetDataExport)
+	router.Get("/api/me/exports/{id}/download", controllers.DownloadDataExport)
+
+	requestExport := func(format string) map[string]interface{} {
+		rec := serve(router, "POST", "/api/me/exports", map[string]string{"format": format}, user)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		var job map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &job)
+		controllers.WaitForDataExports()
+
+		rec = serve(router, "GET", fmt.Sprintf("/api/me/exports/%v", job["id"]), nil, user)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		json.Unmarshal(rec.Body.Bytes(), &job)
+		assert.Equal(t, models.ExportReady, job["status"])
+		return job
+	}
+
+	t.Run("rejects unknown formats", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, serve(router, "POST", "/api/me/exports", map[string]string{"format": "xml"}, user).Code)
+	})
+
+	t.Run("zip archive has every section", func(t *testing.T) {
+		job := requestExport(models.ExportFormatZIP)
+
+		rec := serve(router, "GET", job["downloadUrl"].(string), nil, user)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/zip", rec.Header().Get("Content-Type"))
+
+		archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		assert.NoError(t, err)
+		files := map[string]string{}
+		for _, f := range archive.File {
+			r, _ := f.Open()
+			content, _ := io.ReadAll(r)
+			r.Close()
+			files[f.Name] = string(content)
+		}
+		assert.Contains(t, files["user.json"], "Likes frogs")
+		assert.NotContains(t, files["user.json"], user.Password)
+		assert.Contains(t, files["topic_preferences.json"], "Amphibians")
+		assert.Contains(t, files["owned_swamps.json"], "My Swamp")
+		assert.NotContains(t, files["owned_swamps.json"], "Their Swamp")
+		assert.Contains(t, files["swamp_roles.json"], fmt.Sprintf(`"swampId": %d`, theirs.ID))
+		assert.Contains(t, files["invitations.json"], "export@example.com")
+		assert.NotContains(t, files["invitations.json"], "someone@example.com")
+		assert.Contains(t, files["rsvps.json"], fmt.Sprintf(`"swampId": %d`, theirs.ID))
+		assert.Contains(t, files["calendar_feed.json"], `"createdAt"`)
+		assert.Contains(t, files["calendar_feed.json"], `"lastUsedAt"`)
+		assert.NotContains(t, files["export.json"], "feed-token-hash")
+		assert.Contains(t, files["chat_messages.json"], "ribbit")
+		assert.Contains(t, files["sessions.json"], `"ipAddress"`)
+		assert.Contains(t, files, "export.json")
+	})
+
+	t.Run("json archive", func(t *testing.T) {
+		job := requestExport(models.ExportFormatJSON)
+
+		rec := serve(router, "GET", job["downloadUrl"].(string), nil, user)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var archive map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &archive))
+		assert.Len(t, archive["chatMessages"], 1)
+		assert.Len(t, archive["sessions"], 1)
+		assert.Len(t, archive["swampRoles"], 1)
+		assert.Len(t, archive["invitations"], 2)
+		assert.Len(t, archive["rsvps"], 1)
+		assert.NotNil(t, archive["calendarFeed"])
+	})
+
+	t.Run("other users cannot see it", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", "/api/me/exports/1", nil, other).Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, "GET", "/api/me/exports/1/download", nil, other).Code)
+	})
+}
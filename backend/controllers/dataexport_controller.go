@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"swamp/database"
+	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/export"
+
+	"github.com/go-chi/chi/v5"
+	guuid "github.com/google/uuid"
+)
+
+// exportRetention is how long a finished archive can be downloaded.
+const exportRetention = 7 * 24 * time.Hour
+
+// exportJobs tracks archives being built so tests (and shutdown) can wait.
+var exportJobs sync.WaitGroup
+
+// RequestDataExport POST /api/me/exports
+// Queues an export of everything stored about the caller. Poll the status
+// endpoint until it is ready, then download it.
+func RequestDataExport(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	request := struct {
+		Format string `json:"format"`
+	}{Format: models.ExportFormatZIP}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if request.Format != models.ExportFormatZIP && request.Format != models.ExportFormatJSON {
+		http.Error(w, `{"error": "Format must be zip or json"}`, http.StatusBadRequest)
+		return
+	}
+
+	var inProgress int64
+	database.DB.Model(&models.DataExport{}).
+		Where("user_id = ? AND status IN ?", user.ID, []string{models.ExportPending, models.ExportRunning}).
+		Count(&inProgress)
+	if inProgress > 0 {
+		http.Error(w, `{"error": "An export is already in progress"}`, http.StatusConflict)
+		return
+	}
+
+	purgeExpiredExports()
+
+	job := models.DataExport{UserID: user.ID, Status: models.ExportPending, Format: request.Format}
+	if err := database.DB.Create(&job).Error; err != nil {
+		http.Error(w, `{"error": "Failed to start export"}`, http.StatusInternalServerError)
+		return
+	}
+	startDataExport(job.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/api/me/exports/%d", job.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(dataExportResponse(job))
+}
+
+// ListDataExports GET /api/me/exports
+func ListDataExports(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var jobs []models.DataExport
+	database.DB.Where("user_id = ?", user.ID).Order("created_at DESC").Find(&jobs)
+
+	response := make([]map[string]interface{}, len(jobs))
+	for i, job := range jobs {
+		response[i] = dataExportResponse(job)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"exports": response})
+}
+
+// GetDataExport GET /api/me/exports/{id}
+func GetDataExport(w http.ResponseWriter, r *http.Request) {
+	job, ok := ownDataExport(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dataExportResponse(*job))
+}
+
+// DownloadDataExport GET /api/me/exports/{id}/download
+func DownloadDataExport(w http.ResponseWriter, r *http.Request) {
+	job, ok := ownDataExport(w, r)
+	if !ok {
+		return
+	}
+	if job.Status != models.ExportReady {
+		http.Error(w, `{"error": "Export is not ready"}`, http.StatusConflict)
+		return
+	}
+	if job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt) {
+		http.Error(w, `{"error": "Export has expired"}`, http.StatusGone)
+		return
+	}
+
+	file, err := os.Open(job.FilePath)
+	if err != nil {
+		http.Error(w, `{"error": "Export has expired"}`, http.StatusGone)
+		return
+	}
+	defer file.Close()
+
+	contentType := "application/zip"
+	if job.Format == models.ExportFormatJSON {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="swamp-export-%d.%s"`, job.ID, job.Format))
+	w.Header().Set("Content-Length", strconv.FormatInt(job.Size, 10))
+	io.Copy(w, file)
+}
+
+// ResumeDataExports restarts exports interrupted by a restart. main calls it
+// once the database is connected.
+func ResumeDataExports() {
+	var jobs []models.DataExport
+	database.DB.Where("status IN ?", []string{models.ExportPending, models.ExportRunning}).Find(&jobs)
+	for _, job := range jobs {
+		startDataExport(job.ID)
+	}
+}
+
+func startDataExport(id uint) {
+	exportJobs.Add(1)
+	go func() {
+		defer exportJobs.Done()
+		runDataExport(id)
+	}()
+}
+
+// runDataExport builds the archive and records the outcome on the job.
+func runDataExport(id uint) {
+	var job models.DataExport
+	if err := database.DB.First(&job, id).Error; err != nil {
+		return
+	}
+	database.DB.Model(&job).Update("status", models.ExportRunning)
+
+	path, size, err := writeDataExport(job)
+	now := time.Now()
+	if err != nil {
+		log.Printf("data export %d failed: %v", job.ID, err)
+		database.DB.Model(&job).Updates(map[string]interface{}{
+			"status":       models.ExportFailed,
+			"error":        "The export could not be generated",
+			"completed_at": now,
+		})
+		return
+	}
+
+	database.DB.Model(&job).Updates(map[string]interface{}{
+		"status":       models.ExportReady,
+		"file_path":    path,
+		"size":         size,
+		"completed_at": now,
+		"expires_at":   now.Add(exportRetention),
+	})
+}
+
+func writeDataExport(job models.DataExport) (string, int64, error) {
+	archive, err := export.Build(database.DB, job.UserID)
+	if err != nil {
+		return "", 0, err
+	}
+
+	dir := exportDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", 0, err
+	}
+	// The random part keeps archive names unguessable on shared storage
+	path := filepath.Join(dir, fmt.Sprintf("%d-%s.%s", job.ID, guuid.New().String(), job.Format))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if job.Format == models.ExportFormatJSON {
+		err = export.WriteJSON(file, archive)
+	} else {
+		err = export.WriteZIP(file, archive)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	return path, info.Size(), nil
+}
+
+// purgeExpiredExports removes archives past their retention period.
+func purgeExpiredExports() {
+	var expired []models.DataExport
+	database.DB.Where("expires_at < ?", time.Now()).Find(&expired)
+	for _, job := range expired {
+		if job.FilePath != "" {
+			os.Remove(job.FilePath)
+		}
+		database.DB.Delete(&job)
+	}
+}
+
+// removeDataExports deletes every archive belonging to userID.
+func removeDataExports(userID uint) {
+	var jobs []models.DataExport
+	database.DB.Where("user_id = ?", userID).Find(&jobs)
+	for _, job := range jobs {
+		if job.FilePath != "" {
+			os.Remove(job.FilePath)
+		}
+		database.DB.Delete(&job)
+	}
+}
+
+// ownDataExport loads the export named in the URL if it belongs to the caller.
+func ownDataExport(w http.ResponseWriter, r *http.Request) (*models.DataExport, bool) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var job models.DataExport
+	if err := database.DB.Where("id = ? AND user_id = ?", chi.URLParam(r, "id"), user.ID).First(&job).Error; err != nil {
+		http.Error(w, `{"error": "Export not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	return &job, true
+}
+
+// exportDir is where archives are written, EXPORT_DIR or ./exports.
+func exportDir() string {
+	if dir := os.Getenv("EXPORT_DIR"); dir != "" {
+		return dir
+	}
+	return "exports"
+}
+
+func dataExportResponse(job models.DataExport) map[string]interface{} {
+	response := map[string]interface{}{
+		"id":          job.ID,
+		"status":      job.Status,
+		"format":      job.Format,
+		"createdAt":   job.CreatedAt,
+		"completedAt": job.CompletedAt,
+		"expiresAt":   job.ExpiresAt,
+	}
+	if job.Status == models.ExportReady {
+		response["size"] = job.Size
+		response["downloadUrl"] = fmt.Sprintf("/api/me/exports/%d/download", job.ID)
+	}
+	if job.Status == models.ExportFailed {
+		response["error"] = job.Error
+	}
+	return response
+}
@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"time"
+
+	"swamp/pkg/ratelimit"
+)
+
+// ResetRateLimits clears the in-memory limiters between tests.
+func ResetRateLimits() {
+	otpIPLimiter = ratelimit.New(5, 10*time.Minute)
+	resetEmailLimiter = ratelimit.New(1, otpResendCooldown)
+	twoFactorLimiter = ratelimit.New(5, 5*time.Minute)
+}
+
+// WaitForDataExports blocks until every queued data export has finished.
+func WaitForDataExports() {
+	exportJobs.Wait()
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"swamp/database"
+	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/feed"
+	"swamp/pkg/policy"
+	"swamp/pkg/webrtc"
+)
+
+const (
+	// feedHorizon and feedCandidateLimit bound how many swamps are scored
+	// per request; the feed is about what is on soon.
+	feedHorizon        = 30 * 24 * time.Hour
+	feedCandidateLimit = 500
+)
+
+// activeSwampStatuses are the statuses of swamps one can still join.
+var activeSwampStatuses = []string{models.SwampStatusScheduled, models.SwampStatusLobby, models.SwampStatusLive}
+
+// GetFeed GET /api/me/feed
+// Ranks upcoming and live swamps for the caller by overlap with their
+// topics, live participants and how soon they start. Pages with
+// pageNumber/recordsPerPage like GET /api/swamp.
+func GetFeed(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	pageNumber, _ := strconv.Atoi(r.URL.Query().Get("pageNumber"))
+	recordsPerPage, _ := strconv.Atoi(r.URL.Query().Get("recordsPerPage"))
+	if pageNumber < 1 {
+		pageNumber = 1
+	}
+	if recordsPerPage < 1 {
+		recordsPerPage = 10
+	}
+
+	var topicIDs []uint
+	if err := database.DB.Model(&models.UserTopic{}).Where("user_id = ?", user.ID).
+		Pluck("topic_id", &topicIDs).Error; err != nil {
+		http.Error(w, `{"error": "Failed to load feed"}`, http.StatusInternalServerError)
+		return
+	}
+	interests := make(map[uint]bool, len(topicIDs))
+	for _, id := range topicIDs {
+		interests[id] = true
+	}
+
+	now := time.Now()
+	var swamps []models.Swamp
+	err := database.DB.Preload("Topic").Preload("Topics").
+		Scopes(policy.VisibleSwamps(policy.SubjectOf(user))).
+		Where("deleted = ? AND status IN ? AND start_time <= ?", false, activeSwampStatuses, now.Add(feedHorizon)).
+		Order("start_time ASC").
+		Limit(feedCandidateLimit).
+		Find(&swamps).Error
+	if err != nil {
+		http.Error(w, `{"error": "Failed to load feed"}`, http.StatusInternalServerError)
+		return
+	}
+
+	candidates := make([]feed.Candidate, len(swamps))
+	for i, swamp := range swamps {
+		topics := swamp.Topics
+		if len(topics) == 0 && swamp.TopicID != 0 {
+			topics = []models.Topic{swamp.Topic}
+		}
+		candidates[i] = feed.Candidate{Swamp: swamp, TopicCount: len(topics)}
+		for _, topic := range topics {
+			if interests[topic.ID] {
+				candidates[i].MatchedTopics = append(candidates[i].MatchedTopics, topic.Name)
+			}
+		}
+		if swamp.Status == models.SwampStatusLive {
+			publishers, viewers := webrtc.Occupancy(swamp.UUID)
+			candidates[i].Participants = publishers + viewers
+		}
+	}
+	items := feed.Rank(candidates, now)
+
+	total := len(items)
+	start := (pageNumber - 1) * recordsPerPage
+	if start > total {
+		start = total
+	}
+	end := start + recordsPerPage
+	if end > total {
+		end = total
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"meta": map[string]int{
+			"totalResults":   total,
+			"pageNumber":     pageNumber,
+			"recordsPerPage": recordsPerPage,
+		},
+		"allDocuments": items[start:end],
+	})
+}
@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"swamp/database"
+	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/auth"
+	"swamp/pkg/policy"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// errInviteLinkUsedUp is returned when the last use of a link was taken
+// by someone else in the meantime.
+var errInviteLinkUsedUp = errors.New("invite link used up")
+
+// ListInvitations GET /api/swamp/{id}/invitations
+func ListInvitations(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := authorizeSwamp(w, r, policy.ManageSwamp)
+	if !ok {
+		return
+	}
+
+	var invitations []models.SwampInvitation
+	database.DB.Where("swamp_id = ?", swamp.ID).Order("created_at DESC").Find(&invitations)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"invitations": invitations})
+}
+
+// CreateInvitation POST /api/swamp/{id}/invitations
+// Invites either an existing user by userId or anyone by email; an email
+// invitation applies once someone with that address signs in.
+func CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	caller, _ := middleware.UserFromContext(r.Context())
+	swamp, ok := authorizeSwamp(w, r, policy.ManageSwamp)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		UserID uint   `json:"userId"`
+		Email  string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(request.Email))
+	if (request.UserID == 0) == (email == "") {
+		http.Error(w, `{"error": "Provide either userId or email"}`, http.StatusBadRequest)
+		return
+	}
+	if email != "" && !strings.Contains(email, "@") {
+		http.Error(w, `{"error": "Invalid email format"}`, http.StatusBadRequest)
+		return
+	}
+
+	invitation := models.SwampInvitation{SwampID: uint(swamp.ID), InvitedBy: caller.ID}
+	lookup := database.DB.Where("swamp_id = ?", swamp.ID)
+	if request.UserID != 0 {
+		if err := database.DB.First(&models.User{}, request.UserID).Error; err != nil {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		invitation.UserID = &request.UserID
+		lookup = lookup.Where("user_id = ?", request.UserID)
+	} else {
+		invitation.Email = email
+		lookup = lookup.Where("email = ?", email)
+	}
+
+	// Inviting someone twice returns the existing invitation
+	status := http.StatusCreated
+	var existing models.SwampInvitation
+	if err := lookup.First(&existing).Error; err == nil {
+		invitation, status = existing, http.StatusOK
+	} else if err := database.DB.Create(&invitation).Error; err != nil {
+		http.Error(w, `{"error": "Failed to create invitation"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(invitation)
+}
+
+// DeleteInvitation DELETE /api/swamp/{id}/invitations/{invitationID}
+func DeleteInvitation(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := authorizeSwamp(w, r, policy.ManageSwamp)
+	if !ok {
+		return
+	}
+
+	invitationID, err := strconv.ParseUint(chi.URLParam(r, "invitationID"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invitation not found"}`, http.StatusNotFound)
+		return
+	}
+
+	result := database.DB.Where("id = ? AND swamp_id = ?", invitationID, swamp.ID).Delete(&models.SwampInvitation{})
+	if result.Error != nil {
+		http.Error(w, `{"error": "Failed to delete invitation"}`, http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, `{"error": "Invitation not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListInviteLinks GET /api/swamp/{id}/invite-links
+func ListInviteLinks(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := authorizeSwamp(w, r, policy.ManageSwamp)
+	if !ok {
+		return
+	}
+
+	var links []models.SwampInviteLink
+	database.DB.Where("swamp_id = ?", swamp.ID).Order("created_at DESC").Find(&links)
+
+	response := make([]map[string]interface{}, len(links))
+	for i, link := range links {
+		response[i] = inviteLinkResponse(link)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"inviteLinks": response})
+}
+
+// CreateInviteLink POST /api/swamp/{id}/invite-links
+// Returns the link token exactly once; only its hash is stored. maxUses of
+// 0 and a missing expiresAt mean no limit.
+func CreateInviteLink(w http.ResponseWriter, r *http.Request) {
+	caller, _ := middleware.UserFromContext(r.Context())
+	swamp, ok := authorizeSwamp(w, r, policy.ManageSwamp)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		ExpiresAt *time.Time `json:"expiresAt"`
+		MaxUses   int        `json:"maxUses"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		http.Error(w, `{"error": "Expiry must be in the future"}`, http.StatusBadRequest)
+		return
+	}
+	if request.MaxUses < 0 {
+		http.Error(w, `{"error": "maxUses must not be negative"}`, http.StatusBadRequest)
+		return
+	}
+
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to generate invite link"}`, http.StatusInternalServerError)
+		return
+	}
+
+	link := models.SwampInviteLink{
+		SwampID:   uint(swamp.ID),
+		TokenHash: hash,
+		CreatedBy: caller.ID,
+		ExpiresAt: request.ExpiresAt,
+		MaxUses:   request.MaxUses,
+	}
+	if err := database.DB.Create(&link).Error; err != nil {
+		http.Error(w, `{"error": "Failed to create invite link"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Share this link now; it will not be shown again",
+		"token":      token,
+		"acceptPath": "/api/invite-links/" + token + "/accept",
+		"inviteLink": inviteLinkResponse(link),
+	})
+}
+
+// RevokeInviteLink DELETE /api/swamp/{id}/invite-links/{linkID}
+// People who already redeemed the link keep their invitations.
+func RevokeInviteLink(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := authorizeSwamp(w, r, policy.ManageSwamp)
+	if !ok {
+		return
+	}
+
+	linkID, err := strconv.ParseUint(chi.URLParam(r, "linkID"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invite link not found"}`, http.StatusNotFound)
+		return
+	}
+
+	var link models.SwampInviteLink
+	if err := database.DB.Where("id = ? AND swamp_id = ?", linkID, swamp.ID).First(&link).Error; err != nil {
+		http.Error(w, `{"error": "Invite link not found"}`, http.StatusNotFound)
+		return
+	}
+	if link.RevokedAt == nil {
+		if err := database.DB.Model(&link).Update("revoked_at", time.Now()).Error; err != nil {
+			http.Error(w, `{"error": "Failed to revoke invite link"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInviteLink POST /api/invite-links/{token}/accept
+// Redeems a link for the caller, who then holds an invitation to the swamp.
+// Callers who can already see the swamp do not use up the link.
+func AcceptInviteLink(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var link models.SwampInviteLink
+	if err := database.DB.Where("token_hash = ?", auth.HashToken(chi.URLParam(r, "token"))).First(&link).Error; err != nil {
+		http.Error(w, `{"error": "Invite link not found"}`, http.StatusNotFound)
+		return
+	}
+	var swamp models.Swamp
+	if err := database.DB.Where("deleted = ?", false).First(&swamp, link.SwampID).Error; err != nil {
+		http.Error(w, `{"error": "Swamp not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if swamp.Visibility != models.SwampVisibilityPrivate || policy.SwampRole(database.DB, user.ID, &swamp) != "" ||
+		policy.Invited(database.DB, user.ID, &swamp) {
+		respondAccepted(w, swamp)
+		return
+	}
+	if msg := inviteLinkProblem(link, time.Now()); msg != "" {
+		http.Error(w, `{"error": "`+msg+`"}`, http.StatusGone)
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Counting the use conditionally keeps two callers from taking the last one
+		result := tx.Model(&models.SwampInviteLink{}).
+			Where("id = ? AND revoked_at IS NULL AND (max_uses = 0 OR uses < max_uses)", link.ID).
+			Update("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInviteLinkUsedUp
+		}
+		return tx.Create(&models.SwampInvitation{SwampID: link.SwampID, UserID: &user.ID, InvitedBy: link.CreatedBy, LinkID: &link.ID}).Error
+	})
+	if errors.Is(err, errInviteLinkUsedUp) {
+		http.Error(w, `{"error": "Invite link has been used up"}`, http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Failed to accept invitation"}`, http.StatusInternalServerError)
+		return
+	}
+
+	respondAccepted(w, swamp)
+}
+
+// inviteLinkProblem explains why link cannot be redeemed, or returns "".
+func inviteLinkProblem(link models.SwampInviteLink, now time.Time) string {
+	switch {
+	case link.RevokedAt != nil:
+		return "Invite link has been revoked"
+	case link.Expired(now):
+		return "Invite link has expired"
+	case link.UsedUp():
+		return "Invite link has been used up"
+	}
+	return ""
+}
+
+func respondAccepted(w http.ResponseWriter, swamp models.Swamp) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Invitation accepted",
+		"swamp":   swamp,
+	})
+}
+
+// inviteLinkResponse is the public view of a link; the hash never leaves the server.
+func inviteLinkResponse(link models.SwampInviteLink) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        link.ID,
+		"swampId":   link.SwampID,
+		"createdBy": link.CreatedBy,
+		"expiresAt": link.ExpiresAt,
+		"maxUses":   link.MaxUses,
+		"uses":      link.Uses,
+		"revokedAt": link.RevokedAt,
+		"createdAt": link.CreatedAt,
+		"active":    link.Active(time.Now()),
+	}
+}
@@ -9,7 +9,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Login handles user authentication and returns user data
+// Login handles user authentication and starts a new session
 func Login(w http.ResponseWriter, r *http.Request) {
 	var request struct {
 		Email    string `json:"email"`
@@ -41,14 +41,22 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Return user info
-	response := map[string]interface{}{
-		"message": "Login successful",
-		"user": map[string]interface{}{
-			"id":       user.ID,
-			"email":    user.Email,
-			"fullName": user.FullName,
-		},
+	// With 2FA on, the password only earns a challenge for the second step
+	if user.TOTPEnabled {
+		challenge, err := twoFactorChallenge(user)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to issue token"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(challenge)
+		return
+	}
+
+	response, err := buildSession(database.DB, r, "Login successful", user, "")
+	if err != nil {
+		http.Error(w, `{"error": "Failed to issue token"}`, http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
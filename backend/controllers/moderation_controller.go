@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"swamp/database"
+	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/policy"
+	"swamp/pkg/webrtc"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// SetUserRole PUT /api/admin/users/{userID}/role
+// Admin only (enforced by the route). Changing a role signs the user out so
+// new tokens and socket identities carry it.
+func SetUserRole(w http.ResponseWriter, r *http.Request) {
+	caller, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user id"}`, http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !models.ValidRole(request.Role) {
+		http.Error(w, `{"error": "Role must be user, moderator or admin"}`, http.StatusBadRequest)
+		return
+	}
+	if uint(userID) == caller.ID && request.Role != models.RoleAdmin {
+		http.Error(w, `{"error": "Admins cannot demote themselves"}`, http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("role", request.Role).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID)
+	})
+	if err != nil {
+		http.Error(w, `{"error": "Failed to update role"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":   user.ID,
+		"role": request.Role,
+	})
+}
+
+// ListCoHosts GET /api/swamp/{id}/co-hosts
+func ListCoHosts(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := swampFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var members []models.SwampMember
+	database.DB.Where("swamp_id = ? AND role = ?", swamp.ID, models.SwampRoleCoHost).Find(&members)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ownerId": swamp.OwnerID,
+		"coHosts": members,
+	})
+}
+
+// AddCoHost POST /api/swamp/{id}/co-hosts
+// Only the owner (or an admin) may appoint co-hosts.
+func AddCoHost(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := authorizeSwamp(w, r, policy.ManageCoHosts)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		UserID uint `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.UserID == 0 {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+	if request.UserID == uint(swamp.OwnerID) {
+		http.Error(w, `{"error": "The owner is already a host"}`, http.StatusBadRequest)
+		return
+	}
+	if err := database.DB.First(&models.User{}, request.UserID).Error; err != nil {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+
+	member := models.SwampMember{SwampID: uint(swamp.ID), UserID: request.UserID, Role: models.SwampRoleCoHost}
+	if err := database.DB.Where(models.SwampMember{SwampID: member.SwampID, UserID: member.UserID}).
+		Assign(models.SwampMember{Role: member.Role}).
+		FirstOrCreate(&member).Error; err != nil {
+		http.Error(w, `{"error": "Failed to add co-host"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(member)
+}
+
+// RemoveCoHost DELETE /api/swamp/{id}/co-hosts/{userID}
+func RemoveCoHost(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := authorizeSwamp(w, r, policy.ManageCoHosts)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user id"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := database.DB.Where("swamp_id = ? AND user_id = ?", swamp.ID, userID).
+		Delete(&models.SwampMember{}).Error; err != nil {
+		http.Error(w, `{"error": "Failed to remove co-host"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveParticipant POST /api/swamp/{id}/participants/{userID}/remove
+// Disconnects a participant from the swamp's live room. Owners, co-hosts,
+// moderators and admins may do this.
+func RemoveParticipant(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := authorizeSwamp(w, r, policy.ModerateSwamp)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user id"}`, http.StatusBadRequest)
+		return
+	}
+	if uint(userID) == uint(swamp.OwnerID) {
+		http.Error(w, `{"error": "The owner cannot be removed"}`, http.StatusForbidden)
+		return
+	}
+
+	webrtc.RoomsLock.RLock()
+	room := webrtc.Rooms[swamp.UUID]
+	webrtc.RoomsLock.RUnlock()
+	if room == nil {
+		http.Error(w, `{"error": "Swamp is not live"}`, http.StatusConflict)
+		return
+	}
+
+	room.Kick(uint(userID))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// swampFromRequest loads the swamp named by the {id} URL parameter, its
+// numeric ID or its UUID, and writes a 404 when it does not exist or was
+// cancelled.
+func swampFromRequest(w http.ResponseWriter, r *http.Request) (*models.Swamp, bool) {
+	query := database.DB.Where("deleted = ?", false)
+	key := chi.URLParam(r, "id")
+	if swampID, err := strconv.Atoi(key); err == nil {
+		query = query.Where("id = ?", swampID)
+	} else {
+		query = query.Where("uuid = ?", key)
+	}
+
+	var swamp models.Swamp
+	if err := query.First(&swamp).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, `{"error": "Swamp not found"}`, http.StatusNotFound)
+		} else {
+			http.Error(w, `{"error": "Failed to load swamp"}`, http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return &swamp, true
+}
+
+// visibleSwamp loads the swamp from the URL if the caller may see it.
+// Private swamps look missing to anyone else, and so do unlisted ones
+// unless they are named by the UUID their link carries.
+func visibleSwamp(w http.ResponseWriter, r *http.Request) (*models.Swamp, bool) {
+	swamp, ok := swampFromRequest(w, r)
+	if !ok {
+		return nil, false
+	}
+	action := policy.FindSwamp
+	if chi.URLParam(r, "id") == swamp.UUID {
+		action = policy.ViewSwamp
+	}
+	caller, _ := middleware.UserFromContext(r.Context())
+	if !policy.Can(database.DB, policy.SubjectOf(caller), action, swamp) {
+		http.Error(w, `{"error": "Swamp not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	return swamp, true
+}
+
+// authorizeSwamp loads the swamp from the URL and checks the caller may
+// perform action on it, writing 401/403/404 as appropriate.
+func authorizeSwamp(w http.ResponseWriter, r *http.Request, action policy.Action) (*models.Swamp, bool) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	swamp, ok := swampFromRequest(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if !policy.Can(database.DB, policy.SubjectOf(user), action, swamp) {
+		http.Error(w, `{"error": "You do not have permission to perform this action"}`, http.StatusForbidden)
+		return nil, false
+	}
+	return swamp, true
+}
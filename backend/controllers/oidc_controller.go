@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"swamp/database"
+	"swamp/models"
+	"swamp/pkg/auth"
+	"swamp/pkg/sso"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// oidcStateTTL bounds how long a user can spend at the provider's login page.
+const oidcStateTTL = 10 * time.Minute
+
+// oidcStateCookie ties a login to the browser that started it, so nobody can
+// finish their own login in someone else's browser (login CSRF).
+const oidcStateCookie = "swamp_oidc_state"
+
+// SSOProviders holds the configured OpenID Connect providers; main loads them
+// from the environment. With none configured the SSO endpoints return 404.
+var SSOProviders = sso.NewRegistry()
+
+var errEmailNotVerified = errors.New("provider did not verify the email address")
+
+// ListSSOProviders GET /api/auth/oidc/providers
+func ListSSOProviders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"providers": SSOProviders.Names()})
+}
+
+// StartSSOLogin GET /api/auth/oidc/{provider}/authorize
+// Returns the provider URL to redirect the browser to. State, nonce and the
+// PKCE verifier are stored server-side and consumed by the callback; state
+// and nonce also go into a short-lived HttpOnly cookie the callback checks.
+func StartSSOLogin(w http.ResponseWriter, r *http.Request) {
+	provider, err := SSOProviders.Get(chi.URLParam(r, "provider"))
+	if err != nil {
+		http.Error(w, `{"error": "Unknown identity provider"}`, http.StatusNotFound)
+		return
+	}
+
+	state, stateHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to start login"}`, http.StatusInternalServerError)
+		return
+	}
+	nonce, _, err := auth.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to start login"}`, http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	record := models.OIDCLoginState{
+		StateHash:    stateHash,
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}
+	if err := database.DB.Create(&record).Error; err != nil {
+		http.Error(w, `{"error": "Failed to start login"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Opportunistically clear abandoned logins
+	database.DB.Where("expires_at < ?", time.Now()).Delete(&models.OIDCLoginState{})
+
+	setOIDCStateCookie(w, r, state+"."+nonce, int(oidcStateTTL.Seconds()))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"authorizationUrl": provider.AuthCodeURL(state, nonce, verifier),
+		"state":            state,
+		"expiresAt":        record.ExpiresAt,
+	})
+}
+
+// FinishSSOLogin POST /api/auth/oidc/{provider}/callback
+// The frontend posts the code and state it received on the redirect, with
+// the cookie StartSSOLogin set in the same browser. The verified ID token is
+// linked to an existing user or creates a new one, and the response matches
+// Login, including the two-factor challenge.
+func FinishSSOLogin(w http.ResponseWriter, r *http.Request) {
+	provider, err := SSOProviders.Get(chi.URLParam(r, "provider"))
+	if err != nil {
+		http.Error(w, `{"error": "Unknown identity provider"}`, http.StatusNotFound)
+		return
+	}
+
+	var request struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" || request.State == "" {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+
+	cookieState, cookieNonce := "", ""
+	if cookie, err := r.Cookie(oidcStateCookie); err == nil {
+		cookieState, cookieNonce, _ = strings.Cut(cookie.Value, ".")
+	}
+	setOIDCStateCookie(w, r, "", -1)
+	if cookieState == "" || subtle.ConstantTimeCompare([]byte(cookieState), []byte(request.State)) != 1 {
+		http.Error(w, `{"error": "Invalid or expired login state"}`, http.StatusUnauthorized)
+		return
+	}
+
+	// Deleting the state up front makes it single-use even under concurrency
+	var stored models.OIDCLoginState
+	if err := database.DB.Where("state_hash = ? AND provider = ?", auth.HashToken(request.State), provider.Name).
+		First(&stored).Error; err != nil {
+		http.Error(w, `{"error": "Invalid or expired login state"}`, http.StatusUnauthorized)
+		return
+	}
+	result := database.DB.Delete(&stored)
+	if result.Error != nil || result.RowsAffected == 0 || time.Now().After(stored.ExpiresAt) ||
+		subtle.ConstantTimeCompare([]byte(cookieNonce), []byte(stored.Nonce)) != 1 {
+		http.Error(w, `{"error": "Invalid or expired login state"}`, http.StatusUnauthorized)
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), request.Code, stored.CodeVerifier, stored.Nonce)
+	if err != nil {
+		http.Error(w, `{"error": "Identity provider login failed"}`, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := userForIdentity(identity)
+	if errors.Is(err, errEmailNotVerified) {
+		http.Error(w, `{"error": "Your identity provider has not verified this email address"}`, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Failed to sign in"}`, http.StatusInternalServerError)
+		return
+	}
+
+	var response map[string]interface{}
+	if user.TOTPEnabled {
+		response, err = twoFactorChallenge(*user)
+	} else {
+		response, err = buildSession(database.DB, r, "Login successful", *user, "")
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Failed to issue token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// setOIDCStateCookie sets the login cookie, or clears it when maxAge is
+// negative. It is only sent back to the SSO endpoints.
+func setOIDCStateCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || os.Getenv("ENVIRONMENT") == "PRODUCTION",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// userForIdentity resolves a provider identity to a user: an existing link
+// wins, otherwise a verified email links to (or creates) the matching account.
+func userForIdentity(identity *sso.Identity) (*models.User, error) {
+	var user models.User
+
+	var link models.UserIdentity
+	err := database.DB.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&link).Error
+	if err == nil {
+		if err := database.DB.First(&user, link.UserID).Error; err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errEmailNotVerified
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("LOWER(email) = ?", identity.Email).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// SSO-only accounts have no password until the user sets one via reset
+			user = models.User{FullName: ssoDisplayName(identity), Email: identity.Email}
+			err = tx.Create(&user).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ssoDisplayName falls back to the email's local part when the provider
+// does not share a name.
+func ssoDisplayName(identity *sso.Identity) string {
+	if name := strings.TrimSpace(identity.Name); name != "" {
+		return name
+	}
+	return strings.SplitN(identity.Email, "@", 2)[0]
+}
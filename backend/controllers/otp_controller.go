@@ -1,24 +1,56 @@
 package controllers
 
 import (
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
+	"log"
+	"math"
+	"math/big"
 	"net/http"
+	"strconv"
+	"strings"
 	"swamp/database"
 	"swamp/models"
+	"swamp/pkg/mail"
+	"swamp/pkg/ratelimit"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
-// Generate a 6-digit OTP
-func generateOTP() string {
-	rand.Seed(time.Now().UnixNano())
-	return fmt.Sprintf("%06d", rand.Intn(1000000))
+const (
+	otpTTL            = 5 * time.Minute
+	otpResendCooldown = 60 * time.Second
+	otpLockDuration   = 15 * time.Minute
+	maxOTPAttempts    = 5
+)
+
+var (
+	errOTPInvalid = errors.New("Invalid OTP")
+	errOTPExpired = errors.New("OTP has expired")
+	errOTPLocked  = errors.New("Too many failed attempts, OTP is locked")
+)
+
+// Mailer delivers OTP emails. main swaps in the configured mailer at startup;
+// the in-memory default lets tests read the delivered codes.
+var Mailer mail.Mailer = mail.NewMemoryMailer()
+
+// otpIPLimiter caps how many codes a single address can request across all emails.
+var otpIPLimiter = ratelimit.New(5, 10*time.Minute)
+
+// Generate a 6-digit OTP using crypto/rand
+func generateOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
 }
 
-// RequestOTP (Send OTP)
+// RequestOTP (Send OTP) starts signup for an email that has no account yet
 func RequestOTP(w http.ResponseWriter, r *http.Request) {
 	var request struct {
 		Email string `json:"email"`
@@ -30,25 +62,15 @@ func RequestOTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	otpCode := generateOTP()
-	expirationTime := time.Now().Add(5 * time.Minute)
-
-	otp := models.OTP{
-		Email:     request.Email,
-		Code:      otpCode,
-		ExpiresAt: expirationTime,
+	if emailRegistered(request.Email) {
+		http.Error(w, `{"error": "An account with this email already exists"}`, http.StatusConflict)
+		return
 	}
 
-	// Save OTP to the database (Update if exists)
-	database.DB.Where(models.OTP{Email: request.Email}).Delete(&models.OTP{})
-	if err := database.DB.Create(&otp).Error; err != nil {
-		http.Error(w, `{"error": "Failed to store OTP"}`, http.StatusInternalServerError)
+	if !issueOTP(w, r, request.Email, models.OTPPurposeSignup, mail.OTPMessage) {
 		return
 	}
 
-	// In a real-world scenario, send the OTP via email/SMS
-	fmt.Println("Generated OTP:", otpCode) // For testing
-
 	response := map[string]string{
 		"message": "OTP sent successfully",
 		"email":   request.Email,
@@ -58,7 +80,172 @@ func RequestOTP(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// Verify OTP
+// issueOTP generates, stores and mails a code for the given purpose, enforcing
+// the lockout and resend limits. It writes the error response itself and
+// returns false when the caller should stop.
+func issueOTP(w http.ResponseWriter, r *http.Request, email, purpose string,
+	render func(to, code string, ttl time.Duration) (mail.Message, error)) bool {
+	now := time.Now()
+	var otp models.OTP
+	exists := database.DB.Where("email = ? AND purpose = ?", email, purpose).First(&otp).Error == nil
+	if exists {
+		// A fresh code must not reset the lockout, otherwise guessing is unlimited
+		if otp.Locked() {
+			respondTooManyRequests(w, errOTPLocked.Error(), otp.LockedUntil.Sub(now))
+			return false
+		}
+		if wait := otp.LastSentAt.Add(otpResendCooldown).Sub(now); wait > 0 {
+			respondTooManyRequests(w, "Please wait before requesting another OTP", wait)
+			return false
+		}
+	}
+
+	ip := clientIP(r)
+	if ok, wait := otpIPLimiter.Allow(ip); !ok {
+		respondTooManyRequests(w, "Too many OTP requests, please try again later", wait)
+		return false
+	}
+	return deliverOTP(w, &otp, exists, email, purpose, ip, render)
+}
+
+// deliverOTP stores a fresh code in otp, which exists in the database or
+// not yet, and mails it. Limits are the caller's job. It writes the error
+// response itself and returns false when the caller should stop.
+func deliverOTP(w http.ResponseWriter, otp *models.OTP, exists bool, email, purpose, ip string,
+	render func(to, code string, ttl time.Duration) (mail.Message, error)) bool {
+	now := time.Now()
+	otpCode, err := generateOTP()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to generate OTP"}`, http.StatusInternalServerError)
+		return false
+	}
+	hashedCode, err := bcrypt.GenerateFromPassword([]byte(otpCode), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to generate OTP"}`, http.StatusInternalServerError)
+		return false
+	}
+
+	otp.Email = email
+	otp.Purpose = purpose
+	otp.Code = string(hashedCode)
+	otp.ExpiresAt = now.Add(otpTTL)
+	otp.Attempts = 0
+	otp.LockedUntil = nil
+	otp.LastSentAt = now
+	otp.RequestIP = ip
+
+	// Save OTP to the database (Update if exists)
+	if exists {
+		err = database.DB.Save(otp).Error
+	} else {
+		err = database.DB.Create(otp).Error
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Failed to store OTP"}`, http.StatusInternalServerError)
+		return false
+	}
+
+	message, err := render(email, otpCode, otpTTL)
+	if err == nil {
+		err = Mailer.Send(message)
+	}
+	if err != nil {
+		log.Printf("failed to deliver %s OTP to %s: %v", purpose, email, err)
+		database.DB.Delete(otp)
+		http.Error(w, `{"error": "Failed to send OTP"}`, http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// checkOTP compares code with the stored hash for email and purpose, so a code
+// issued for one flow cannot be used in another. Every guess takes one of
+// maxOTPAttempts in a single conditional update before the comparison, so
+// parallel guesses cannot exceed the limit; the code locks once they run out.
+func checkOTP(email, purpose, code string) (*models.OTP, error) {
+	var otp models.OTP
+	if err := database.DB.Where("email = ? AND purpose = ?", email, purpose).First(&otp).Error; err != nil {
+		return nil, errOTPInvalid
+	}
+
+	if otp.Locked() {
+		return &otp, errOTPLocked
+	}
+
+	if time.Now().After(otp.ExpiresAt) {
+		return &otp, errOTPExpired
+	}
+
+	result := database.DB.Model(&models.OTP{}).Where("id = ? AND attempts < ?", otp.ID, maxOTPAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return &otp, errOTPInvalid
+	}
+	if result.RowsAffected == 0 {
+		lockOTP(&otp)
+		return &otp, errOTPLocked
+	}
+	database.DB.Select("attempts").First(&otp, otp.ID)
+
+	if bcrypt.CompareHashAndPassword([]byte(otp.Code), []byte(code)) != nil {
+		if otp.Attempts >= maxOTPAttempts {
+			lockOTP(&otp)
+			return &otp, errOTPLocked
+		}
+		return &otp, errOTPInvalid
+	}
+
+	return &otp, nil
+}
+
+// lockOTP locks otp for otpLockDuration, keeping an earlier lock that a
+// parallel guess has already set.
+func lockOTP(otp *models.OTP) {
+	lockedUntil := time.Now().Add(otpLockDuration)
+	database.DB.Model(&models.OTP{}).Where("id = ? AND locked_until IS NULL", otp.ID).Update("locked_until", lockedUntil)
+	database.DB.Select("locked_until").First(otp, otp.ID)
+	if otp.LockedUntil == nil {
+		otp.LockedUntil = &lockedUntil
+	}
+}
+
+// respondOTPError maps checkOTP failures to distinct responses.
+func respondOTPError(w http.ResponseWriter, otp *models.OTP, err error) {
+	switch err {
+	case errOTPLocked:
+		respondTooManyRequests(w, err.Error(), time.Until(*otp.LockedUntil))
+	case errOTPExpired:
+		http.Error(w, `{"error": "OTP has expired"}`, http.StatusUnauthorized)
+	default:
+		remaining := maxOTPAttempts
+		if otp != nil {
+			remaining = maxOTPAttempts - otp.Attempts
+		}
+		http.Error(w, fmt.Sprintf(`{"error": "Invalid OTP", "attemptsRemaining": %d}`, remaining), http.StatusUnauthorized)
+	}
+}
+
+// respondTooManyRequests writes a 429 with a Retry-After header.
+func respondTooManyRequests(w http.ResponseWriter, message string, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      message,
+		"retryAfter": seconds,
+	})
+}
+
+// emailRegistered reports whether an account already uses email.
+func emailRegistered(email string) bool {
+	var count int64
+	database.DB.Model(&models.User{}).Where("email = ?", email).Count(&count)
+	return count > 0
+}
+
+// VerifyOTP completes signup: it checks a signup-purpose code and creates the account
 func VerifyOTP(w http.ResponseWriter, r *http.Request) {
 	var request struct {
 		Email    string `json:"email"`
@@ -68,22 +255,21 @@ func VerifyOTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse request body
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == "" || request.OTP == "" {
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == "" || request.OTP == "" ||
+		strings.TrimSpace(request.FullName) == "" || request.Password == "" {
 		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
 		return
 	}
 
-	var otp models.OTP
-	result := database.DB.Where("email = ? AND code = ?", request.Email, request.OTP).First(&otp)
-
-	if result.Error != nil {
-		http.Error(w, `{"error": "Invalid OTP"}`, http.StatusUnauthorized)
+	// Checked before the code so existing accounts don't burn verify attempts
+	if emailRegistered(request.Email) {
+		http.Error(w, `{"error": "An account with this email already exists"}`, http.StatusConflict)
 		return
 	}
 
-	// Check if OTP is expired
-	if time.Now().After(otp.ExpiresAt) {
-		http.Error(w, `{"error": "OTP has expired"}`, http.StatusUnauthorized)
+	otp, err := checkOTP(request.Email, models.OTPPurposeSignup, request.OTP)
+	if err != nil {
+		respondOTPError(w, otp, err)
 		return
 	}
 
@@ -96,21 +282,30 @@ func VerifyOTP(w http.ResponseWriter, r *http.Request) {
 
 	// Create new user
 	user := models.User{
-		FullName: request.FullName,
+		FullName: strings.TrimSpace(request.FullName),
 		Email:    request.Email,
 		Password: string(hashedPassword),
 	}
 
 	// Save user to database
 	if err := database.DB.Create(&user).Error; err != nil {
+		// Lost a race with a concurrent signup for the same email
+		if emailRegistered(request.Email) {
+			http.Error(w, `{"error": "An account with this email already exists"}`, http.StatusConflict)
+			return
+		}
 		http.Error(w, `{"error": "Failed to create user"}`, http.StatusInternalServerError)
 		return
 	}
 
 	// Delete OTP after successful verification
-	database.DB.Delete(&otp)
+	database.DB.Delete(otp)
 
-	response := map[string]string{"message": "OTP verified successfully"}
+	response, err := buildSession(database.DB, r, "OTP verified successfully", user, "")
+	if err != nil {
+		http.Error(w, `{"error": "Failed to issue token"}`, http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
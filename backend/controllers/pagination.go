@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor is the decoded form of the opaque cursor strings handed to
+// clients. It points just past (or, with Before, just before) the row with
+// sort key Key and id ID.
+type pageCursor struct {
+	Sort   string `json:"s"`
+	Key    string `json:"k,omitempty"`
+	ID     uint   `json:"i"`
+	Before bool   `json:"b,omitempty"`
+}
+
+func encodeCursor(c pageCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor accepts "" as the first page.
+func decodeCursor(s string) (*pageCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c pageCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID == 0 {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+// keyset is an ordering usable for cursor pagination: a sort column with
+// the id as tie-breaker. An empty column orders by id alone.
+type keyset struct {
+	column string
+	desc   bool
+	parse  func(string) (interface{}, error) // turns a cursor key back into a query value
+}
+
+func parseTimeKey(key string) (interface{}, error) {
+	return time.Parse(time.RFC3339Nano, key)
+}
+
+func timeKey(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// order returns the ORDER BY clause, flipped when reading backwards.
+func (k keyset) order(reverse bool) string {
+	direction := "ASC"
+	if k.desc != reverse {
+		direction = "DESC"
+	}
+	if k.column == "" {
+		return "id " + direction
+	}
+	return fmt.Sprintf("%s %s, id %s", k.column, direction, direction)
+}
+
+// seek limits db to the rows after (or before) the cursor.
+func (k keyset) seek(db *gorm.DB, c *pageCursor) (*gorm.DB, error) {
+	op := ">"
+	if k.desc != c.Before {
+		op = "<"
+	}
+	if k.column == "" {
+		return db.Where("id "+op+" ?", c.ID), nil
+	}
+
+	key, err := k.parse(c.Key)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	condition := fmt.Sprintf("(%s %s ?) OR (%s = ? AND id %s ?)", k.column, op, k.column, op)
+	return db.Where(condition, key, key, c.ID), nil
+}
+
+// paginate loads one page of limit rows of db in the order of ks. keyOf
+// returns a row's sort key and id for building cursors. The returned meta
+// holds nextCursor and prevCursor, each nil at the respective end.
+func paginate[T any](db *gorm.DB, sort string, ks keyset, cursorParam string, limit int, keyOf func(T) (string, uint)) ([]T, map[string]interface{}, error) {
+	cursor, err := decodeCursor(cursorParam)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cursor != nil && cursor.Sort != sort {
+		return nil, nil, errInvalidCursor
+	}
+
+	before := cursor != nil && cursor.Before
+	if cursor != nil {
+		if db, err = ks.seek(db, cursor); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// One extra row tells us whether there is another page
+	var rows []T
+	if err := db.Order(ks.order(before)).Limit(limit + 1).Find(&rows).Error; err != nil {
+		return nil, nil, err
+	}
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+	if before {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	meta := map[string]interface{}{"nextCursor": nil, "prevCursor": nil}
+	if len(rows) == 0 {
+		return rows, meta, nil
+	}
+	if (!before && more) || before {
+		key, id := keyOf(rows[len(rows)-1])
+		meta["nextCursor"] = encodeCursor(pageCursor{Sort: sort, Key: key, ID: id})
+	}
+	if (before && more) || (!before && cursor != nil) {
+		key, id := keyOf(rows[0])
+		meta["prevCursor"] = encodeCursor(pageCursor{Sort: sort, Key: key, ID: id, Before: true})
+	}
+	return rows, meta, nil
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+
+	"swamp/database"
+	"swamp/pkg/policy"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasscodeLength       = 4
+	maxPasscodeLength       = 64
+	generatedPasscodeLength = 8
+)
+
+// SetSwampPasscode PUT /api/swamp/{id}/passcode
+// Sets or rotates the passcode room sockets ask for. Without a passcode in
+// the body one is generated. People already in the room stay connected.
+func SetSwampPasscode(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := authorizeSwamp(w, r, policy.ManageSwamp)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Passcode string `json:"passcode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+
+	passcode := request.Passcode
+	if passcode == "" {
+		generated, err := generatePasscode()
+		if err != nil {
+			http.Error(w, `{"error": "Failed to generate passcode"}`, http.StatusInternalServerError)
+			return
+		}
+		passcode = generated
+	} else if len(passcode) < minPasscodeLength || len(passcode) > maxPasscodeLength {
+		http.Error(w, `{"error": "Passcode must be between 4 and 64 characters"}`, http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(passcode), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to set passcode"}`, http.StatusInternalServerError)
+		return
+	}
+	if err := database.DB.Model(swamp).Update("passcode_hash", string(hash)).Error; err != nil {
+		http.Error(w, `{"error": "Failed to set passcode"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Passcode updated",
+		"passcode": passcode,
+	})
+}
+
+// ClearSwampPasscode DELETE /api/swamp/{id}/passcode
+func ClearSwampPasscode(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := authorizeSwamp(w, r, policy.ManageSwamp)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Model(swamp).Update("passcode_hash", "").Error; err != nil {
+		http.Error(w, `{"error": "Failed to remove passcode"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// generatePasscode returns a passcode like "k7m2px9q" without look-alike characters.
+func generatePasscode() (string, error) {
+	b := make([]byte, generatedPasscodeLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = recoveryAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"swamp/database"
+	"swamp/models"
+	"swamp/pkg/mail"
+	"swamp/pkg/ratelimit"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// resetEmailLimiter applies the resend cooldown to every email asked about,
+// whether or not it has an account.
+var resetEmailLimiter = ratelimit.New(1, otpResendCooldown)
+
+// ForgotPassword POST /api/password/forgot
+// Mails a password reset code. The response is the same whether or not the
+// email belongs to an account so the endpoint cannot be used to probe for users:
+// limits are applied before the lookup, and a locked code is left alone
+// without saying so.
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == "" {
+		http.Error(w, `{"error": "Invalid email format"}`, http.StatusBadRequest)
+		return
+	}
+
+	ip := clientIP(r)
+	if ok, wait := otpIPLimiter.Allow(ip); !ok {
+		respondTooManyRequests(w, "Too many OTP requests, please try again later", wait)
+		return
+	}
+	if ok, wait := resetEmailLimiter.Allow(strings.ToLower(request.Email)); !ok {
+		respondTooManyRequests(w, "Please wait before requesting another OTP", wait)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Where("email = ?", request.Email).First(&user).Error; err == nil {
+		var otp models.OTP
+		exists := database.DB.Where("email = ? AND purpose = ?", user.Email, models.OTPPurposePasswordReset).First(&otp).Error == nil
+		// A fresh code must not reset the lockout
+		if !exists || !otp.Locked() {
+			if !deliverOTP(w, &otp, exists, user.Email, models.OTPPurposePasswordReset, ip, mail.PasswordResetMessage) {
+				return
+			}
+		}
+	}
+
+	response := map[string]string{
+		"message": "If an account exists for this email, a reset code has been sent",
+		"email":   request.Email,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ResetPassword POST /api/password/reset
+// Verifies a reset code, stores the new password and signs the user out of
+// every existing session.
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Email    string `json:"email"`
+		OTP      string `json:"otp"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil ||
+		request.Email == "" || request.OTP == "" || request.Password == "" {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return
+	}
+
+	otp, err := checkOTP(request.Email, models.OTPPurposePasswordReset, request.OTP)
+	if err != nil {
+		respondOTPError(w, otp, err)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Where("email = ?", request.Email).First(&user).Error; err != nil {
+		respondOTPError(w, nil, errOTPInvalid)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to process password"}`, http.StatusInternalServerError)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		if err := revokeUserSessions(tx, user.ID); err != nil {
+			return err
+		}
+		return tx.Delete(otp).Error
+	})
+	if err != nil {
+		http.Error(w, `{"error": "Failed to reset password"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]string{"message": "Password reset successfully"}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
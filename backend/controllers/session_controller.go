@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"swamp/database"
+	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/auth"
+
+	guuid "github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errTokenAlreadyUsed = errors.New("refresh token already used")
+
+// createRefreshToken persists a new refresh token in the given family and
+// returns the plaintext value to hand to the client.
+func createRefreshToken(tx *gorm.DB, r *http.Request, userID uint, familyID string) (string, time.Time, error) {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	record := models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(auth.RefreshTokenTTL),
+		UserAgent: r.UserAgent(),
+		IPAddress: clientIP(r),
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	return token, record.ExpiresAt, nil
+}
+
+// revokeTokenFamily revokes every still-active token descended from the same login.
+func revokeTokenFamily(tx *gorm.DB, familyID string) error {
+	return tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// revokeUserSessions signs a user out everywhere: all refresh tokens are
+// revoked and bumping TokenVersion invalidates outstanding access tokens.
+func revokeUserSessions(tx *gorm.DB, userID uint) error {
+	if err := tx.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.User{}).Where("id = ?", userID).
+		Update("token_version", gorm.Expr("token_version + 1")).Error
+}
+
+// RefreshSession POST /api/token/refresh
+// Exchanges a refresh token for a new access/refresh pair. Every token is
+// single-use; presenting one that was already rotated revokes its family.
+func RefreshSession(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+
+	var stored models.RefreshToken
+	if err := database.DB.Where("token_hash = ?", auth.HashToken(request.RefreshToken)).First(&stored).Error; err != nil {
+		http.Error(w, `{"error": "Invalid refresh token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if stored.RevokedAt != nil {
+		// A rotated token was replayed: assume it was stolen and kill the chain.
+		revokeTokenFamily(database.DB, stored.FamilyID)
+		http.Error(w, `{"error": "Refresh token reuse detected"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if !stored.Active() {
+		http.Error(w, `{"error": "Refresh token has expired"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, stored.UserID).Error; err != nil {
+		http.Error(w, `{"error": "Invalid refresh token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var response map[string]interface{}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Only the request that flips revoked_at wins a concurrent rotation.
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", stored.ID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errTokenAlreadyUsed
+		}
+
+		var err error
+		response, err = buildSession(tx, r, "Token refreshed", user, stored.FamilyID)
+		return err
+	})
+	if err == errTokenAlreadyUsed {
+		revokeTokenFamily(database.DB, stored.FamilyID)
+		http.Error(w, `{"error": "Refresh token reuse detected"}`, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Failed to refresh session"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Logout POST /api/logout
+// Revokes the session the given refresh token belongs to.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+
+	var stored models.RefreshToken
+	if err := database.DB.Where("token_hash = ?", auth.HashToken(request.RefreshToken)).First(&stored).Error; err == nil {
+		if err := revokeTokenFamily(database.DB, stored.FamilyID); err != nil {
+			http.Error(w, `{"error": "Failed to log out"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Unknown tokens are treated as already logged out.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll POST /api/logout/all
+// Revokes every session belonging to the authenticated user.
+func LogoutAll(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if err := revokeUserSessions(database.DB, user.ID); err != nil {
+		http.Error(w, `{"error": "Failed to log out"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// buildSession issues an access token and a refresh token in the given family.
+// An empty familyID starts a new session.
+func buildSession(tx *gorm.DB, r *http.Request, message string, user models.User, familyID string) (map[string]interface{}, error) {
+	if familyID == "" {
+		familyID = guuid.New().String()
+	}
+
+	accessToken, expiresAt, err := auth.IssueAccessToken(user.ID, user.Email, user.TokenVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, refreshExpiresAt, err := createRefreshToken(tx, r, user.ID, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"message":               message,
+		"accessToken":           accessToken,
+		"tokenType":             "Bearer",
+		"expiresAt":             expiresAt,
+		"refreshToken":          refreshToken,
+		"refreshTokenExpiresAt": refreshExpiresAt,
+		"user": map[string]interface{}{
+			"id":       user.ID,
+			"email":    user.Email,
+			"fullName": user.FullName,
+		},
+	}, nil
+}
+
+// clientIP returns the caller's address without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
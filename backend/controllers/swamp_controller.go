@@ -2,61 +2,153 @@ package controllers
 
 import (
     "encoding/json"
+    "fmt"
     "net/http"
     "strconv"
+    "strings"
     "time"
 
     "swamp/database"
+    "swamp/middleware"
     "swamp/models"
+    "swamp/pkg/lifecycle"
+    "swamp/pkg/policy"
+    "swamp/pkg/webrtc"
 
-    "github.com/go-chi/chi/v5"
     guuid "github.com/google/uuid"
+    "gorm.io/gorm"
 )
 
-// CreateSwamp handles the creation of a new swamp (single-topic version)
+// swampCancelledEvent is sent to everyone in a room when its swamp is cancelled.
+const swampCancelledEvent = "swamp_cancelled"
+
+// swampFields are the attributes an owner can set. Pointers let UpdateSwamp
+// tell which fields were sent; CreateSwamp requires all but the topics and
+// recurrence. On a recurring swamp StartTime and Duration edit the series;
+// see seriesFor.
+type swampFields struct {
+    Title           *string `json:"Title"`
+    MaxParticipants *int    `json:"MaxParticipants"`
+    StartTime       *string `json:"StartTime"` // RFC3339 string
+    Duration        *int    `json:"Duration"`
+    TopicID         *uint   `json:"TopicID"`   // primary topic
+    TopicIDs        *[]uint `json:"TopicIDs"`  // all topics; see topicSet
+    Visibility      *string `json:"Visibility"` // public, unlisted or private
+    Recurrence      *string `json:"Recurrence"` // RRULE value; "" stops the swamp repeating
+    RecurrenceExceptions *[]string `json:"RecurrenceExceptions"` // RFC3339 starts of occurrences to skip
+}
+
+// applyTo validates the fields that were sent and copies them onto swamp.
+// It returns the message for a 400 response, or "" when all is well.
+func (f swampFields) applyTo(swamp *models.Swamp) string {
+    if (f.Title != nil && strings.TrimSpace(*f.Title) == "") ||
+       (f.MaxParticipants != nil && *f.MaxParticipants == 0) ||
+       (f.StartTime != nil && *f.StartTime == "") ||
+       (f.Duration != nil && *f.Duration == 0) {
+        return "Missing required fields"
+    }
+    if (f.MaxParticipants != nil && *f.MaxParticipants < 0) || (f.Duration != nil && *f.Duration < 0) {
+        return "MaxParticipants and Duration must be positive"
+    }
+    if f.Visibility != nil && !models.ValidVisibility(*f.Visibility) {
+        return "Visibility must be public, unlisted or private"
+    }
+
+    if f.StartTime != nil {
+        parsed, err := time.Parse(time.RFC3339, *f.StartTime)
+        if err != nil {
+            return "Invalid start time format"
+        }
+        swamp.StartTime = parsed
+    }
+    if f.Title != nil {
+        swamp.Title = strings.TrimSpace(*f.Title)
+    }
+    if f.MaxParticipants != nil {
+        swamp.MaxParticipants = *f.MaxParticipants
+    }
+    if f.Duration != nil {
+        swamp.Duration = *f.Duration
+    }
+    if f.TopicID != nil {
+        swamp.TopicID = *f.TopicID     // <— set the FK here
+    }
+    if f.Visibility != nil {
+        swamp.Visibility = *f.Visibility
+    }
+    return ""
+}
+
+// CreateSwamp handles the creation of a new swamp.
+// The owner is always the authenticated caller; any OwnerID in the body is ignored.
 func CreateSwamp(w http.ResponseWriter, r *http.Request) {
-    // 1) Decode into a custom struct so we only pull the fields we want
-    var input struct {
-        Title           string `json:"Title"`
-        OwnerID         int    `json:"OwnerID"`
-        MaxParticipants int    `json:"MaxParticipants"`
-        StartTime       string `json:"StartTime"` // RFC3339 string
-        Duration        int    `json:"Duration"`
-        TopicID         uint   `json:"TopicID"`   // single topic
+    owner, ok := middleware.UserFromContext(r.Context())
+    if !ok {
+        http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+        return
     }
+
+    // 1) Decode only the editable fields
+    var input swampFields
     if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-        http.Error(w, `{"error":"Invalid request format"}`, http.StatusBadRequest)
+        http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
         return
     }
-    // 2) Validate
-    if input.Title == "" || input.OwnerID == 0 ||
-       input.MaxParticipants == 0 || input.StartTime == "" ||
-       input.Duration == 0 {
-        http.Error(w, `{"error":"Missing required fields"}`, http.StatusBadRequest)
+    // 2) Validate: everything but the topic is required on create
+    if input.Title == nil || input.MaxParticipants == nil ||
+       input.StartTime == nil || input.Duration == nil {
+        http.Error(w, `{"error": "Missing required fields"}`, http.StatusBadRequest)
         return
     }
-    // 3) Parse start time
-    parsed, err := time.Parse(time.RFC3339, input.StartTime)
-    if err != nil {
-        http.Error(w, `{"error":"Invalid start time format"}`, http.StatusBadRequest)
+    // 3) Build Swamp with its primary TopicID and the full topic set
+    swamp := models.Swamp{
+        UUID:    guuid.New().String(),
+        OwnerID:    int(owner.ID),
+        Status:     models.SwampStatusScheduled,
+        Visibility: models.SwampVisibilityPublic,
+    }
+    if msg := input.applyTo(&swamp); msg != "" {
+        http.Error(w, fmt.Sprintf(`{"error": "%s"}`, msg), http.StatusBadRequest)
         return
     }
-    // 4) Build Swamp with the single TopicID
-    swamp := models.Swamp{
-        UUID:            guuid.New().String(),
-        Title:           input.Title,
-        OwnerID:         input.OwnerID,
-        MaxParticipants: input.MaxParticipants,
-        StartTime:       parsed,
-        Duration:        input.Duration,
-        TopicID:         input.TopicID,            // <— set the FK here
+    topicIDs, msg := input.topicSet(&swamp, nil)
+    if msg != "" {
+        http.Error(w, fmt.Sprintf(`{"error": "%s"}`, msg), http.StatusBadRequest)
+        return
+    }
+    if known, err := checkNewTopics(database.DB, topicIDs, nil); err != nil || !known {
+        http.Error(w, `{"error": "Unknown topic"}`, http.StatusBadRequest)
+        return
     }
-    if err := database.DB.Create(&swamp).Error; err != nil {
-        http.Error(w, `{"error":"Failed to create swamp"}`, http.StatusInternalServerError)
+    series, exceptions, msg := input.seriesFor(&swamp, nil, owner.Timezone)
+    if msg != "" {
+        http.Error(w, fmt.Sprintf(`{"error": "%s"}`, msg), http.StatusBadRequest)
         return
     }
-    // 5) Reload with Topic preloaded so JSON contains Topic.Name
-    database.DB.Preload("Topic").First(&swamp, swamp.ID)
+    err := database.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(&swamp).Error; err != nil {
+            return err
+        }
+        if len(topicIDs) > 0 {
+            if err := replaceSwampTopics(tx, swamp.ID, topicIDs); err != nil {
+                return err
+            }
+        }
+        return saveSeries(tx, swamp.ID, series, nil, exceptions)
+    })
+    if err != nil {
+        http.Error(w, `{"error": "Failed to create swamp"}`, http.StatusInternalServerError)
+        return
+    }
+    // The first occurrence is not at StartTime if it is an exception
+    if series != nil {
+        if _, err := lifecycle.NextOccurrence(database.DB, &swamp, time.Now()); err != nil {
+            http.Error(w, `{"error": "Failed to create swamp"}`, http.StatusInternalServerError)
+            return
+        }
+    }
+    // 4) Reload with topics preloaded so JSON contains their names
+    database.DB.Preload("Topic").Preload("Topics").Preload("Recurrence").First(&swamp, swamp.ID)
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{
@@ -65,6 +157,12 @@ func CreateSwamp(w http.ResponseWriter, r *http.Request) {
     })
 }
 
+// GetSwamps GET /api/swamp
+// Supports q, topicId, ownerId, status, startFrom, startTo and sort on top
+// of the paging parameters; see parseSwampQuery. Passing cursor (empty for
+// the first page) switches from pageNumber to cursor pagination, which does
+// not skip or repeat swamps created while paging. Only swamps the caller
+// may find are listed; see policy.VisibleSwamps.
 func GetSwamps(w http.ResponseWriter, r *http.Request) {
     // paging...
     pageNumber, _ := strconv.Atoi(r.URL.Query().Get("pageNumber"))
@@ -76,22 +174,54 @@ func GetSwamps(w http.ResponseWriter, r *http.Request) {
         recordsPerPage = 10
     }
 
+    query, msg := parseSwampQuery(r.URL.Query())
+    if msg != "" {
+        http.Error(w, fmt.Sprintf(`{"error": "%s"}`, msg), http.StatusBadRequest)
+        return
+    }
+    caller, _ := middleware.UserFromContext(r.Context())
+    query.Viewer = policy.SubjectOf(caller)
+    sort := swampSorts[query.Sort]
+
     // 6) Preload Topic here
     var swamps []models.Swamp
     var totalResults int64
-    database.DB.Model(&models.Swamp{}).Count(&totalResults)
-    database.DB.
-        Preload("Topic").                      // <— add this
-        Limit(recordsPerPage).
-        Offset((pageNumber-1)*recordsPerPage).
-        Find(&swamps)
+    if err := query.scope(database.DB.Model(&models.Swamp{})).Count(&totalResults).Error; err != nil {
+        http.Error(w, `{"error": "Failed to load swamps"}`, http.StatusInternalServerError)
+        return
+    }
+
+    meta := map[string]interface{}{
+        "totalResults":   int(totalResults),
+        "recordsPerPage": recordsPerPage,
+    }
+    if r.URL.Query().Has("cursor") {
+        page, cursors, err := paginate(query.scope(database.DB).Preload("Topic").Preload("Topics").Preload("Recurrence"), query.Sort, sort.keyset,
+            r.URL.Query().Get("cursor"), recordsPerPage, func(s models.Swamp) (string, uint) {
+                return sort.key(s), uint(s.ID)
+            })
+        if err != nil {
+            http.Error(w, `{"error": "Invalid cursor"}`, http.StatusBadRequest)
+            return
+        }
+        swamps = page
+        for k, v := range cursors {
+            meta[k] = v
+        }
+    } else {
+        query.scope(database.DB).
+            Preload("Topic").                      // <— add this
+            Preload("Topics").
+            Preload("Recurrence").
+            Order(sort.order(false)).
+            Limit(recordsPerPage).
+            Offset((pageNumber-1)*recordsPerPage).
+            Find(&swamps)
+        meta["pageNumber"] = pageNumber
+    }
 
     response := map[string]interface{}{
-        "meta": map[string]int{
-            "totalResults":   int(totalResults),
-            "pageNumber":     pageNumber,
-            "recordsPerPage": recordsPerPage,
-        },
+        "meta":         meta,
         "allDocuments": swamps,
     }
 
@@ -100,16 +230,149 @@ func GetSwamps(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetSwampByID(w http.ResponseWriter, r *http.Request) {
-    swampIDStr := chi.URLParam(r, "id")
-    swampID, _ := strconv.Atoi(swampIDStr)
-    var swamp models.Swamp
+    // Private swamps look missing to anyone not invited, and unlisted ones
+    // to anyone opening them by ID instead of their link
+    found, ok := visibleSwamp(w, r)
+    if !ok {
+        return
+    }
 
     // 7) And here too: preload the single Topic
-    if err := database.DB.Preload("Topic").First(&swamp, swampID).Error; err != nil {
-        http.Error(w, `{"error":"Swamp not found"}`, http.StatusNotFound)
+    var swamp models.Swamp
+    if err := database.DB.Preload("Topic").Preload("Topics").Preload("Recurrence").First(&swamp, found.ID).Error; err != nil {
+        http.Error(w, `{"error": "Swamp not found"}`, http.StatusNotFound)
         return
     }
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(swamp)
 }
+
+// UpdateSwamp PATCH /api/swamp/{id}
+// Owner only. Fields left out of the body keep their current values. Once a
+// swamp is live its start time is fixed, though it can still be extended.
+// Edits to a recurring swamp apply to the whole series; single occurrences
+// are changed through UpdateOccurrence.
+func UpdateSwamp(w http.ResponseWriter, r *http.Request) {
+    swamp, ok := authorizeSwamp(w, r, policy.ManageSwamp)
+    if !ok {
+        return
+    }
+    if swamp.Finished() {
+        http.Error(w, `{"error": "Swamp has already ended"}`, http.StatusConflict)
+        return
+    }
+
+    var input swampFields
+    if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+        http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+        return
+    }
+    if input.StartTime != nil && swamp.Status == models.SwampStatusLive {
+        http.Error(w, `{"error": "Cannot change the start time of a live swamp"}`, http.StatusConflict)
+        return
+    }
+    currentTopics, err := storedTopicIDs(database.DB, swamp)
+    if err != nil {
+        http.Error(w, `{"error": "Failed to update swamp"}`, http.StatusInternalServerError)
+        return
+    }
+    currentSeries, err := storedSeries(database.DB, swamp)
+    if err != nil {
+        http.Error(w, `{"error": "Failed to update swamp"}`, http.StatusInternalServerError)
+        return
+    }
+    if msg := input.applyTo(swamp); msg != "" {
+        http.Error(w, fmt.Sprintf(`{"error": "%s"}`, msg), http.StatusBadRequest)
+        return
+    }
+    topicIDs, msg := input.topicSet(swamp, currentTopics)
+    if msg != "" {
+        http.Error(w, fmt.Sprintf(`{"error": "%s"}`, msg), http.StatusBadRequest)
+        return
+    }
+    if known, err := checkNewTopics(database.DB, topicIDs, currentTopics); err != nil || !known {
+        http.Error(w, `{"error": "Unknown topic"}`, http.StatusBadRequest)
+        return
+    }
+    timezone := ""
+    if currentSeries == nil && input.Recurrence != nil {
+        timezone = ownerTimezone(swamp)
+    }
+    series, exceptions, msg := input.seriesFor(swamp, currentSeries, timezone)
+    if msg != "" {
+        http.Error(w, fmt.Sprintf(`{"error": "%s"}`, msg), http.StatusBadRequest)
+        return
+    }
+    // Rescheduling may close the lobby again; going live or ending is left
+    // to the scheduler so rooms are handled in one place.
+    if next := swamp.StatusAt(time.Now()); next != models.SwampStatusLive && next != models.SwampStatusEnded &&
+        models.CanTransition(swamp.Status, next) {
+        swamp.Status = next
+    }
+
+    err = database.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Model(swamp).Select("Title", "MaxParticipants", "StartTime", "Duration", "TopicID", "Status", "Visibility").
+            Updates(swamp).Error; err != nil {
+            return err
+        }
+        if err := replaceSwampTopics(tx, swamp.ID, topicIDs); err != nil {
+            return err
+        }
+        return saveSeries(tx, swamp.ID, series, currentSeries, exceptions)
+    })
+    if err != nil {
+        http.Error(w, `{"error": "Failed to update swamp"}`, http.StatusInternalServerError)
+        return
+    }
+    // Point the swamp back at the occurrence the edited series calls for
+    if series != nil {
+        if _, err := lifecycle.NextOccurrence(database.DB, swamp, time.Now()); err != nil {
+            http.Error(w, `{"error": "Failed to update swamp"}`, http.StatusInternalServerError)
+            return
+        }
+    }
+    database.DB.Preload("Topic").Preload("Topics").Preload("Recurrence").First(swamp, swamp.ID)
+
+    // A live room picks up a new participant limit straight away
+    webrtc.RoomsLock.RLock()
+    room := webrtc.Rooms[swamp.UUID]
+    webrtc.RoomsLock.RUnlock()
+    if room != nil && input.MaxParticipants != nil {
+        room.SetMaxParticipants(swamp.MaxParticipants)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "message": "Swamp updated successfully",
+        "swamp":   swamp,
+    })
+}
+
+// DeleteSwamp DELETE /api/swamp/{id}
+// Owner only. Cancels the swamp: it is soft-deleted and anyone still in its
+// room is told why and disconnected.
+func DeleteSwamp(w http.ResponseWriter, r *http.Request) {
+    swamp, ok := authorizeSwamp(w, r, policy.ManageSwamp)
+    if !ok {
+        return
+    }
+
+    updates := map[string]interface{}{"deleted": true}
+    if models.CanTransition(swamp.Status, models.SwampStatusCancelled) {
+        updates["status"] = models.SwampStatusCancelled
+    }
+    err := database.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Model(swamp).Updates(updates).Error; err != nil {
+            return err
+        }
+        return tx.Delete(swamp).Error
+    })
+    if err != nil {
+        http.Error(w, `{"error": "Failed to cancel swamp"}`, http.StatusInternalServerError)
+        return
+    }
+
+    webrtc.CloseRoom(swamp.UUID, swampCancelledEvent, "This swamp was cancelled by its owner")
+    w.WriteHeader(http.StatusNoContent)
+}
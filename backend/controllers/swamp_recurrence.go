@@ -0,0 +1,450 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"swamp/database"
+	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/lifecycle"
+	"swamp/pkg/policy"
+	"swamp/pkg/recurrence"
+	"swamp/pkg/webrtc"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultOccurrenceRange = 30 * 24 * time.Hour
+	maxOccurrenceRange     = 366 * 24 * time.Hour
+	// occurrenceHorizon bounds how far from now occurrences are listed, so
+	// a far-off range cannot make every series expand for long
+	occurrenceHorizon = 2 * 366 * 24 * time.Hour
+)
+
+// seriesFor builds the series f asks for on top of the stored one, current,
+// which is nil for one-off swamps. A nil series means the swamp does not
+// repeat. New series repeat in timezone, falling back to UTC. It also
+// returns the occurrence starts f cancels and the message for a 400
+// response.
+func (f swampFields) seriesFor(swamp *models.Swamp, current *models.SwampRecurrence, timezone string) (*models.SwampRecurrence, []time.Time, string) {
+	stop := f.Recurrence != nil && strings.TrimSpace(*f.Recurrence) == ""
+	if stop || (f.Recurrence == nil && current == nil) {
+		if f.RecurrenceExceptions != nil {
+			return nil, nil, "RecurrenceExceptions need a Recurrence"
+		}
+		return nil, nil, ""
+	}
+
+	series := models.SwampRecurrence{Start: swamp.StartTime.UTC(), Duration: swamp.Duration, Timezone: "UTC"}
+	if current != nil {
+		series = *current
+		if f.StartTime != nil {
+			series.Start = swamp.StartTime.UTC()
+		}
+		if f.Duration != nil {
+			series.Duration = swamp.Duration
+		}
+	} else if _, err := time.LoadLocation(timezone); err == nil && timezone != "" {
+		series.Timezone = timezone
+	}
+	if f.Recurrence != nil {
+		rule, err := recurrence.Parse(*f.Recurrence)
+		if err != nil {
+			msg := err.Error()
+			return nil, nil, strings.ToUpper(msg[:1]) + msg[1:]
+		}
+		series.Rule = rule.String()
+	}
+	// RFC 5545 leaves series whose DTSTART the rule skips undefined, and
+	// calendar apps disagree on them
+	if (f.Recurrence != nil || f.StartTime != nil) && !series.Matches() {
+		return nil, nil, "StartTime must be an occurrence of the Recurrence"
+	}
+
+	var exceptions []time.Time
+	if f.RecurrenceExceptions != nil {
+		for _, raw := range *f.RecurrenceExceptions {
+			start, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return nil, nil, "RecurrenceExceptions must be RFC3339 times"
+			}
+			if !series.Includes(start) {
+				return nil, nil, "RecurrenceExceptions must be occurrences of the Recurrence"
+			}
+			exceptions = append(exceptions, start.UTC())
+		}
+	}
+	return &series, exceptions, ""
+}
+
+// storedSeries returns the series of swamp, or nil if it does not repeat.
+func storedSeries(db *gorm.DB, swamp *models.Swamp) (*models.SwampRecurrence, error) {
+	var series models.SwampRecurrence
+	err := db.Where("swamp_id = ?", swamp.ID).First(&series).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &series, nil
+}
+
+// saveSeries stores series in place of current and cancels the occurrences
+// starting at exceptions. Moving the rule or its start drops the overrides,
+// which are keyed by the old occurrence starts.
+func saveSeries(tx *gorm.DB, swampID int, series, current *models.SwampRecurrence, exceptions []time.Time) error {
+	if series == nil {
+		if current == nil {
+			return nil
+		}
+		if err := tx.Where("swamp_id = ?", swampID).Delete(&models.SwampOccurrence{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("swamp_id = ?", swampID).Delete(&models.SwampRecurrence{}).Error
+	}
+
+	series.SwampID = uint(swampID)
+	if current != nil && (current.Rule != series.Rule || !current.Start.Equal(series.Start)) {
+		if err := tx.Where("swamp_id = ?", swampID).Delete(&models.SwampOccurrence{}).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(series).Error; err != nil {
+		return err
+	}
+	for _, start := range exceptions {
+		override := models.SwampOccurrence{SwampID: uint(swampID), OriginalStart: start, Cancelled: true}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "swamp_id"}, {Name: "original_start"}},
+			DoUpdates: clause.AssignmentColumns([]string{"cancelled", "updated_at"}),
+		}).Create(&override).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownerTimezone is the profile timezone of the swamp's owner, which new
+// series repeat in.
+func ownerTimezone(swamp *models.Swamp) string {
+	var owner models.User
+	database.DB.Select("timezone").First(&owner, swamp.OwnerID)
+	return owner.Timezone
+}
+
+// parseOccurrenceRange reads from and to, defaulting to the next 30 days,
+// and clamps them to two years either side of now. The string is the
+// message for a 400 response.
+func parseOccurrenceRange(values url.Values) (time.Time, time.Time, string) {
+	from, to := time.Now(), time.Time{}
+	if raw := values.Get("from"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return from, to, "from must be an RFC3339 time"
+		}
+		from = parsed
+	}
+	to = from.Add(defaultOccurrenceRange)
+	if raw := values.Get("to"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return from, to, "to must be an RFC3339 time"
+		}
+		to = parsed
+	}
+	if !to.After(from) {
+		return from, to, "to must be after from"
+	}
+	if to.Sub(from) > maxOccurrenceRange {
+		return from, to, "from and to may be at most 366 days apart"
+	}
+	now := time.Now()
+	if earliest := now.Add(-occurrenceHorizon); from.Before(earliest) {
+		from = earliest
+	}
+	if latest := now.Add(occurrenceHorizon); to.After(latest) {
+		to = latest
+	}
+	if !to.After(from) {
+		to = from
+	}
+	return from, to, ""
+}
+
+// swampOccurrences lists the occurrences of swamp starting in [from, to).
+// A one-off swamp has just the one; swamp.Recurrence must be loaded.
+func swampOccurrences(swamp *models.Swamp, overrides []models.SwampOccurrence, from, to time.Time) []models.Occurrence {
+	if swamp.Recurrence == nil {
+		if swamp.StartTime.Before(from) || !swamp.StartTime.Before(to) {
+			return nil
+		}
+		return []models.Occurrence{{OriginalStart: swamp.StartTime, StartTime: swamp.StartTime, Duration: swamp.Duration}}
+	}
+	return swamp.Recurrence.Occurrences(from, to, overrides)
+}
+
+// ListSwampOccurrences GET /api/swamp/{id}/occurrences
+// Lists the occurrences starting between from and to, cancelled ones
+// included so clients can show them struck through.
+func ListSwampOccurrences(w http.ResponseWriter, r *http.Request) {
+	from, to, msg := parseOccurrenceRange(r.URL.Query())
+	if msg != "" {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, msg), http.StatusBadRequest)
+		return
+	}
+	swamp, ok := visibleSwamp(w, r)
+	if !ok {
+		return
+	}
+
+	series, err := storedSeries(database.DB, swamp)
+	var overrides []models.SwampOccurrence
+	if err != nil || database.DB.Where("swamp_id = ?", swamp.ID).Find(&overrides).Error != nil {
+		http.Error(w, `{"error": "Failed to load occurrences"}`, http.StatusInternalServerError)
+		return
+	}
+	swamp.Recurrence = series
+	occurrences := swampOccurrences(swamp, overrides, from, to)
+	if occurrences == nil {
+		occurrences = []models.Occurrence{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"meta":        map[string]interface{}{"from": from, "to": to, "totalResults": len(occurrences)},
+		"recurrence":  swamp.Recurrence,
+		"occurrences": occurrences,
+	})
+}
+
+// occurrenceItem is a swamp in an occurrence listing.
+type occurrenceItem struct {
+	Swamp      models.Swamp      `json:"swamp"`
+	Occurrence models.Occurrence `json:"occurrence"`
+}
+
+// ListOccurrences GET /api/swamp/occurrences
+// Lists every occurrence starting between from and to across the swamps
+// the caller may find, recurring or not, ordered by start. q, topicId and
+// ownerId filter as they do for GET /api/swamp. Cancelled occurrences are
+// left out.
+func ListOccurrences(w http.ResponseWriter, r *http.Request) {
+	from, to, msg := parseOccurrenceRange(r.URL.Query())
+	if msg != "" {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, msg), http.StatusBadRequest)
+		return
+	}
+	filters, msg := parseSwampQuery(r.URL.Query())
+	if msg != "" {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, msg), http.StatusBadRequest)
+		return
+	}
+	caller, _ := middleware.UserFromContext(r.Context())
+	query := swampQuery{Search: filters.Search, TopicIDs: filters.TopicIDs, OwnerID: filters.OwnerID, Viewer: policy.SubjectOf(caller)}
+
+	// Series are kept whatever their next start unless they were over
+	// before from, counting occurrences moved later; their occurrences are
+	// worked out below
+	var swamps []models.Swamp
+	err := query.scope(database.DB).Preload("Topic").Preload("Topics").Preload("Recurrence").
+		Where("status <> ?", models.SwampStatusCancelled).
+		Where("(start_time >= ? AND start_time < ?) OR "+
+			"id IN (SELECT swamp_id FROM swamp_recurrences WHERE start < ? AND (last_start IS NULL OR last_start >= ?)) OR "+
+			"id IN (SELECT swamp_id FROM swamp_occurrences WHERE start_time >= ? AND start_time < ?)",
+			from, to, to, from, from, to).
+		Find(&swamps).Error
+	if err != nil {
+		http.Error(w, `{"error": "Failed to load occurrences"}`, http.StatusInternalServerError)
+		return
+	}
+
+	var seriesIDs []int
+	for _, swamp := range swamps {
+		if swamp.Recurrence != nil {
+			seriesIDs = append(seriesIDs, swamp.ID)
+		}
+	}
+	overrides := map[uint][]models.SwampOccurrence{}
+	if len(seriesIDs) > 0 {
+		var rows []models.SwampOccurrence
+		if err := database.DB.Where("swamp_id IN ?", seriesIDs).Find(&rows).Error; err != nil {
+			http.Error(w, `{"error": "Failed to load occurrences"}`, http.StatusInternalServerError)
+			return
+		}
+		for _, row := range rows {
+			overrides[row.SwampID] = append(overrides[row.SwampID], row)
+		}
+	}
+
+	items := []occurrenceItem{}
+	for i := range swamps {
+		for _, occurrence := range swampOccurrences(&swamps[i], overrides[uint(swamps[i].ID)], from, to) {
+			if !occurrence.Cancelled {
+				items = append(items, occurrenceItem{Swamp: swamps[i], Occurrence: occurrence})
+			}
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Occurrence.StartTime.Before(items[j].Occurrence.StartTime)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"meta":         map[string]interface{}{"from": from, "to": to, "totalResults": len(items)},
+		"allDocuments": items,
+	})
+}
+
+// occurrenceFromRequest loads the series of swamp and the occurrence the
+// {start} URL parameter names by its original start, writing 404 or 409
+// when there is none or it is over.
+func occurrenceFromRequest(w http.ResponseWriter, r *http.Request, swamp *models.Swamp) (*models.SwampRecurrence, []models.SwampOccurrence, models.Occurrence, bool) {
+	series, err := storedSeries(database.DB, swamp)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to load occurrence"}`, http.StatusInternalServerError)
+		return nil, nil, models.Occurrence{}, false
+	}
+	if series == nil {
+		http.Error(w, `{"error": "Swamp does not repeat"}`, http.StatusConflict)
+		return nil, nil, models.Occurrence{}, false
+	}
+
+	raw, _ := url.PathUnescape(chi.URLParam(r, "start"))
+	start, err := time.Parse(time.RFC3339, raw)
+	if err != nil || !series.Includes(start) {
+		http.Error(w, `{"error": "Occurrence not found"}`, http.StatusNotFound)
+		return nil, nil, models.Occurrence{}, false
+	}
+	var overrides []models.SwampOccurrence
+	if err := database.DB.Where("swamp_id = ?", swamp.ID).Find(&overrides).Error; err != nil {
+		http.Error(w, `{"error": "Failed to load occurrence"}`, http.StatusInternalServerError)
+		return nil, nil, models.Occurrence{}, false
+	}
+
+	occurrence := series.Occurrence(start, overrides)
+	if swamp.Finished() || !occurrence.EndTime().After(time.Now()) {
+		http.Error(w, `{"error": "This occurrence has already ended"}`, http.StatusConflict)
+		return nil, nil, models.Occurrence{}, false
+	}
+	return series, overrides, occurrence, true
+}
+
+// overrideOf returns the stored override of occurrence, or a new one.
+func overrideOf(swamp *models.Swamp, occurrence models.Occurrence, overrides []models.SwampOccurrence) models.SwampOccurrence {
+	for _, override := range overrides {
+		if override.OriginalStart.Equal(occurrence.OriginalStart) {
+			return override
+		}
+	}
+	return models.SwampOccurrence{SwampID: uint(swamp.ID), OriginalStart: occurrence.OriginalStart}
+}
+
+// UpdateOccurrence PATCH /api/swamp/{id}/occurrences/{start}
+// Moves or resizes one occurrence of a series, named by the RFC3339 start
+// the rule gives it, without touching the others. As with swamps, a live
+// occurrence can be extended but not moved.
+func UpdateOccurrence(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := authorizeSwamp(w, r, policy.ManageSwamp)
+	if !ok {
+		return
+	}
+	series, overrides, occurrence, ok := occurrenceFromRequest(w, r, swamp)
+	if !ok {
+		return
+	}
+	if occurrence.Cancelled {
+		http.Error(w, `{"error": "This occurrence has been cancelled"}`, http.StatusConflict)
+		return
+	}
+
+	var input struct {
+		StartTime *string `json:"startTime"`
+		Duration  *int    `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+	override := overrideOf(swamp, occurrence, overrides)
+	if input.StartTime != nil {
+		if !occurrence.StartTime.After(time.Now()) {
+			http.Error(w, `{"error": "Cannot change the start time of a live occurrence"}`, http.StatusConflict)
+			return
+		}
+		start, err := time.Parse(time.RFC3339, *input.StartTime)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid start time format"}`, http.StatusBadRequest)
+			return
+		}
+		if !start.After(time.Now()) {
+			http.Error(w, `{"error": "Start time must be in the future"}`, http.StatusBadRequest)
+			return
+		}
+		start = start.UTC()
+		override.StartTime = &start
+	}
+	if input.Duration != nil {
+		if *input.Duration <= 0 {
+			http.Error(w, `{"error": "Duration must be positive"}`, http.StatusBadRequest)
+			return
+		}
+		override.Duration = input.Duration
+	}
+
+	if err := database.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&override).Error; err != nil {
+		http.Error(w, `{"error": "Failed to update occurrence"}`, http.StatusInternalServerError)
+		return
+	}
+	if _, err := lifecycle.NextOccurrence(database.DB, swamp, time.Now()); err != nil {
+		http.Error(w, `{"error": "Failed to update occurrence"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Occurrence updated",
+		"occurrence": series.Occurrence(occurrence.OriginalStart, []models.SwampOccurrence{override}),
+	})
+}
+
+// CancelOccurrence DELETE /api/swamp/{id}/occurrences/{start}
+// Cancels one occurrence of a series, like an EXDATE. Anyone in its room is
+// told and disconnected; the series goes on with the next occurrence.
+func CancelOccurrence(w http.ResponseWriter, r *http.Request) {
+	swamp, ok := authorizeSwamp(w, r, policy.ManageSwamp)
+	if !ok {
+		return
+	}
+	_, overrides, occurrence, ok := occurrenceFromRequest(w, r, swamp)
+	if !ok {
+		return
+	}
+
+	override := overrideOf(swamp, occurrence, overrides)
+	override.Cancelled = true
+	if err := database.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&override).Error; err != nil {
+		http.Error(w, `{"error": "Failed to cancel occurrence"}`, http.StatusInternalServerError)
+		return
+	}
+	if swamp.Status == models.SwampStatusLive && swamp.StartTime.Equal(occurrence.StartTime) {
+		webrtc.CloseRoom(swamp.UUID, swampCancelledEvent, "This occurrence was cancelled by its owner")
+	}
+	if _, err := lifecycle.NextOccurrence(database.DB, swamp, time.Now()); err != nil {
+		http.Error(w, `{"error": "Failed to cancel occurrence"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
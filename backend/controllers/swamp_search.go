@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"swamp/models"
+	"swamp/pkg/policy"
+
+	"gorm.io/gorm"
+)
+
+// swampStatusFilters maps the public status filter values to stored statuses.
+var swampStatusFilters = map[string][]string{
+	"upcoming": {models.SwampStatusScheduled, models.SwampStatusLobby},
+	"live":     {models.SwampStatusLive},
+	"ended":    {models.SwampStatusEnded},
+}
+
+// swampSort is a sort parameter value: the keyset it orders by and how to
+// read a swamp's sort key for cursors.
+type swampSort struct {
+	keyset
+	key func(models.Swamp) string
+}
+
+func swampStartKey(s models.Swamp) string   { return timeKey(s.StartTime) }
+func swampTitleKey(s models.Swamp) string   { return strings.ToLower(s.Title) }
+func swampCreatedKey(s models.Swamp) string { return timeKey(s.CreatedAt) }
+
+func parseStringKey(key string) (interface{}, error) { return key, nil }
+
+// swampSorts maps the sort parameter to its ordering. The id keeps the
+// order stable between pages.
+var swampSorts = map[string]swampSort{
+	"startTime":  {keyset{column: "start_time", parse: parseTimeKey}, swampStartKey},
+	"-startTime": {keyset{column: "start_time", desc: true, parse: parseTimeKey}, swampStartKey},
+	"title":      {keyset{column: "LOWER(title)", parse: parseStringKey}, swampTitleKey},
+	"-title":     {keyset{column: "LOWER(title)", desc: true, parse: parseStringKey}, swampTitleKey},
+	"createdAt":  {keyset{column: "created_at", parse: parseTimeKey}, swampCreatedKey},
+	"-createdAt": {keyset{column: "created_at", desc: true, parse: parseTimeKey}, swampCreatedKey},
+}
+
+const defaultSwampSort = "startTime"
+
+// swampQuery holds the parsed search parameters of GET /api/swamp.
+type swampQuery struct {
+	Search    string
+	TopicIDs  []uint
+	OwnerID   int
+	Statuses  []string
+	StartFrom *time.Time
+	StartTo   *time.Time
+	Sort      string
+	Viewer    policy.Subject // limits results to swamps the caller may find
+}
+
+// parseSwampQuery reads q, topicId, ownerId, status, startFrom, startTo and
+// sort. topicId and status take comma-separated lists. The string is the
+// message for a 400 response.
+func parseSwampQuery(values url.Values) (swampQuery, string) {
+	query := swampQuery{Search: strings.TrimSpace(values.Get("q")), Sort: defaultSwampSort}
+
+	for _, raw := range listParam(values, "topicId") {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return query, "topicId must be a list of topic ids"
+		}
+		query.TopicIDs = append(query.TopicIDs, uint(id))
+	}
+
+	if raw := values.Get("ownerId"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id < 1 {
+			return query, "ownerId must be a user id"
+		}
+		query.OwnerID = id
+	}
+
+	for _, raw := range listParam(values, "status") {
+		statuses, ok := swampStatusFilters[raw]
+		if !ok {
+			return query, "status must be upcoming, live or ended"
+		}
+		query.Statuses = append(query.Statuses, statuses...)
+	}
+
+	for param, target := range map[string]**time.Time{"startFrom": &query.StartFrom, "startTo": &query.StartTo} {
+		if raw := values.Get(param); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return query, param + " must be an RFC3339 time"
+			}
+			*target = &parsed
+		}
+	}
+	if query.StartFrom != nil && query.StartTo != nil && query.StartTo.Before(*query.StartFrom) {
+		return query, "startTo must not be before startFrom"
+	}
+
+	if raw := values.Get("sort"); raw != "" {
+		if _, ok := swampSorts[raw]; !ok {
+			return query, "sort must be one of startTime, title or createdAt, optionally prefixed with -"
+		}
+		query.Sort = raw
+	}
+	return query, ""
+}
+
+// scope applies the filters, leaving out cancelled swamps and those hidden
+// from the viewer. It is used for both the count and the page so
+// meta.totalResults matches the filters.
+func (q swampQuery) scope(db *gorm.DB) *gorm.DB {
+	db = db.Where("deleted = ?", false).Scopes(policy.VisibleSwamps(q.Viewer))
+	if q.Search != "" {
+		db = db.Where("LOWER(title) LIKE ? ESCAPE '\\'", "%"+escapeLike(strings.ToLower(q.Search))+"%")
+	}
+	if len(q.TopicIDs) > 0 {
+		// Match any of the swamp's topics, not just the primary one
+		db = db.Where("topic_id IN ? OR id IN (SELECT swamp_id FROM swamp_topics WHERE topic_id IN ?)", q.TopicIDs, q.TopicIDs)
+	}
+	if q.OwnerID != 0 {
+		db = db.Where("owner_id = ?", q.OwnerID)
+	}
+	if len(q.Statuses) > 0 {
+		db = db.Where("status IN ?", q.Statuses)
+	}
+	if q.StartFrom != nil {
+		db = db.Where("start_time >= ?", *q.StartFrom)
+	}
+	if q.StartTo != nil {
+		db = db.Where("start_time < ?", *q.StartTo)
+	}
+	return db
+}
+
+// listParam splits comma-separated and repeated query parameters.
+func listParam(values url.Values, name string) []string {
+	var items []string
+	for _, value := range values[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"fmt"
+
+	"swamp/models"
+
+	"gorm.io/gorm"
+)
+
+// maxSwampTopics caps how many topics one swamp can carry.
+const maxSwampTopics = 5
+
+// topicSet works out a swamp's topics from the request. TopicIDs replaces
+// the set and TopicID picks the primary; otherwise the primary stays put,
+// or falls back to the first listed topic if it was dropped from the set.
+// current is the stored set, nil on create. swamp.TopicID is updated; the
+// message is for a 400 response.
+func (f swampFields) topicSet(swamp *models.Swamp, current []uint) ([]uint, string) {
+	ids := current
+	if f.TopicIDs != nil {
+		ids = nil
+		for _, id := range *f.TopicIDs {
+			if id != 0 && !containsID(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		if (f.TopicID == nil || *f.TopicID == 0) && !containsID(ids, swamp.TopicID) {
+			swamp.TopicID = 0
+			if len(ids) > 0 {
+				swamp.TopicID = ids[0]
+			}
+		}
+	}
+	if swamp.TopicID != 0 && !containsID(ids, swamp.TopicID) {
+		ids = append([]uint{swamp.TopicID}, ids...)
+	}
+
+	if len(ids) > maxSwampTopics {
+		return nil, fmt.Sprintf("A swamp can have at most %d topics", maxSwampTopics)
+	}
+	return ids, ""
+}
+
+// checkNewTopics reports whether every topic in ids that is not already on
+// the swamp exists and has not been retired.
+func checkNewTopics(db *gorm.DB, ids, current []uint) (bool, error) {
+	var added []uint
+	for _, id := range ids {
+		if !containsID(current, id) {
+			added = append(added, id)
+		}
+	}
+	if len(added) == 0 {
+		return true, nil
+	}
+
+	var count int64
+	err := db.Model(&models.Topic{}).Where("id IN ? AND deleted = ?", added, false).Count(&count).Error
+	return count == int64(len(added)), err
+}
+
+// storedTopicIDs returns the topics stored for a swamp, primary first.
+func storedTopicIDs(db *gorm.DB, swamp *models.Swamp) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&models.SwampTopic{}).Where("swamp_id = ? AND topic_id <> ?", swamp.ID, swamp.TopicID).
+		Order("topic_id").Pluck("topic_id", &ids).Error
+	if swamp.TopicID != 0 {
+		ids = append([]uint{swamp.TopicID}, ids...)
+	}
+	return ids, err
+}
+
+// replaceSwampTopics stores ids as the swamp's topics.
+func replaceSwampTopics(tx *gorm.DB, swampID int, ids []uint) error {
+	if err := tx.Where("swamp_id = ?", swampID).Delete(&models.SwampTopic{}).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	rows := make([]models.SwampTopic, len(ids))
+	for i, id := range ids {
+		rows[i] = models.SwampTopic{SwampID: uint(swampID), TopicID: id}
+	}
+	return tx.Create(&rows).Error
+}
+
+func containsID(ids []uint, id uint) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -29,12 +29,55 @@ func CreateTopic(w http.ResponseWriter, r *http.Request) {
   json.NewEncoder(w).Encode(t)
 }
 
+// DeleteTopic DELETE /api/topics/{id}
+// Moderators retire topics; they stay attached to existing swamps but are
+// no longer listed.
+func DeleteTopic(w http.ResponseWriter, r *http.Request) {
+  topicID, err := strconv.Atoi(chi.URLParam(r,"id"))
+  if err != nil {
+    http.Error(w, `{"error":"invalid topic id"}`, http.StatusBadRequest)
+    return
+  }
+  result := database.DB.Model(&models.Topic{}).Where("id = ? AND deleted = ?", topicID, false).Update("deleted", true)
+  if result.Error != nil {
+    http.Error(w, `{"error":"could not delete topic"}`, http.StatusInternalServerError)
+    return
+  }
+  if result.RowsAffected == 0 {
+    http.Error(w, `{"error":"topic not found"}`, http.StatusNotFound)
+    return
+  }
+  w.WriteHeader(http.StatusNoContent)
+}
+
 // ListTopics GET /api/topics
+// Returns every topic, or with cursor (empty for the first page) and
+// recordsPerPage a page of them in id order.
 func ListTopics(w http.ResponseWriter, r *http.Request) {
   var topics []models.Topic
-  database.DB.Where("deleted = ?", false).Find(&topics)
+  if !r.URL.Query().Has("cursor") {
+    database.DB.Where("deleted = ?", false).Find(&topics)
+    w.Header().Set("Content-Type","application/json")
+    json.NewEncoder(w).Encode(topics)
+    return
+  }
+
+  recordsPerPage, _ := strconv.Atoi(r.URL.Query().Get("recordsPerPage"))
+  if recordsPerPage < 1 {
+    recordsPerPage = 10
+  }
+  topics, meta, err := paginate(database.DB.Where("deleted = ?", false), "id", keyset{},
+    r.URL.Query().Get("cursor"), recordsPerPage, func(t models.Topic) (string, uint) { return "", t.ID })
+  if err != nil {
+    http.Error(w, `{"error":"invalid cursor"}`, http.StatusBadRequest)
+    return
+  }
+  meta["recordsPerPage"] = recordsPerPage
   w.Header().Set("Content-Type","application/json")
-  json.NewEncoder(w).Encode(topics)
+  json.NewEncoder(w).Encode(map[string]interface{}{
+    "meta":         meta,
+    "allDocuments": topics,
+  })
 }
 
 // GetUserTopics GET /api/user/{userID}/topics
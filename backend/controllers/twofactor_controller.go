@@ -0,0 +1,343 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"swamp/database"
+	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/auth"
+	"swamp/pkg/ratelimit"
+	"swamp/pkg/totp"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer        = "The Swamp"
+	recoveryCodeCount = 10
+	recoveryAlphabet  = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// twoFactorLimiter caps second-factor guesses per user across challenge tokens.
+var twoFactorLimiter = ratelimit.New(5, 5*time.Minute)
+
+// SetupTwoFactor POST /api/2fa/setup
+// Starts enrollment by generating a secret. 2FA is not enforced until the
+// user proves their authenticator works via ConfirmTwoFactor.
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+	if user.TOTPEnabled {
+		http.Error(w, `{"error": "Two-factor authentication is already enabled"}`, http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to generate secret"}`, http.StatusInternalServerError)
+		return
+	}
+	if err := database.DB.Model(user).Update("totp_pending_secret", secret).Error; err != nil {
+		http.Error(w, `{"error": "Failed to start enrollment"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":     secret,
+		"otpauthUri": totp.URI(secret, totpIssuer, user.Email),
+	})
+}
+
+// ConfirmTwoFactor POST /api/2fa/confirm
+// Enables 2FA once a code from the pending secret checks out and returns the
+// recovery codes. They are shown only this once.
+func ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	if user.TOTPPendingSecret == "" {
+		http.Error(w, `{"error": "Two-factor setup has not been started"}`, http.StatusBadRequest)
+		return
+	}
+
+	step, valid := totp.Validate(user.TOTPPendingSecret, request.Code, time.Now())
+	if !valid {
+		http.Error(w, `{"error": "Invalid authentication code"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_enabled":        true,
+			"totp_secret":         user.TOTPPendingSecret,
+			"totp_pending_secret": "",
+			"totp_last_step":      step,
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, `{"error": "Failed to enable two-factor authentication"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+// DisableTwoFactor POST /api/2fa/disable
+// Requires the account password and a current code (or a recovery code).
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Password == "" || request.Code == "" {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Error(w, `{"error": "Two-factor authentication is not enabled"}`, http.StatusBadRequest)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password)) != nil {
+		http.Error(w, `{"error": "Invalid password"}`, http.StatusUnauthorized)
+		return
+	}
+	if !checkSecondFactorAttempt(w, user, request.Code) {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_enabled":        false,
+			"totp_secret":         "",
+			"totp_pending_secret": "",
+			"totp_last_step":      0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		http.Error(w, `{"error": "Failed to disable two-factor authentication"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes POST /api/2fa/recovery-codes
+// Replaces all recovery codes after checking a current authenticator code.
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "Authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Error(w, `{"error": "Two-factor authentication is not enabled"}`, http.StatusBadRequest)
+		return
+	}
+	if !checkSecondFactorAttempt(w, user, request.Code) {
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(database.DB, user.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to generate recovery codes"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recoveryCodes": codes})
+}
+
+// LoginTwoFactor POST /api/login/2fa
+// Second login step: exchanges the challenge token from Login plus a TOTP or
+// recovery code for a full session.
+func LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ChallengeToken string `json:"challengeToken"`
+		Code           string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ChallengeToken == "" || request.Code == "" {
+		http.Error(w, `{"error": "Invalid request format"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := auth.ParseChallengeToken(request.ChallengeToken)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid or expired challenge"}`, http.StatusUnauthorized)
+		return
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		http.Error(w, `{"error": "Invalid or expired challenge"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil ||
+		claims.Version != user.TokenVersion || !user.TOTPEnabled {
+		http.Error(w, `{"error": "Invalid or expired challenge"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if !checkSecondFactorAttempt(w, &user, request.Code) {
+		return
+	}
+
+	response, err := buildSession(database.DB, r, "Login successful", user, "")
+	if err != nil {
+		http.Error(w, `{"error": "Failed to issue token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// twoFactorChallenge is returned by Login instead of a session when 2FA is on.
+func twoFactorChallenge(user models.User) (map[string]interface{}, error) {
+	token, expiresAt, err := auth.IssueChallengeToken(user.ID, user.Email, user.TokenVersion)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message":           "Two-factor authentication required",
+		"twoFactorRequired": true,
+		"challengeToken":    token,
+		"expiresAt":         expiresAt,
+	}, nil
+}
+
+// checkSecondFactorAttempt applies the per-user guess limit around
+// verifySecondFactor and writes the error response on failure. The attempt
+// is counted before verifying, so parallel guesses cannot exceed the limit.
+func checkSecondFactorAttempt(w http.ResponseWriter, user *models.User, code string) bool {
+	key := strconv.FormatUint(uint64(user.ID), 10)
+	if allowed, wait := twoFactorLimiter.Allow(key); !allowed {
+		respondTooManyRequests(w, "Too many failed attempts, please try again later", wait)
+		return false
+	}
+
+	if !verifySecondFactor(database.DB, user, code) {
+		http.Error(w, `{"error": "Invalid authentication code"}`, http.StatusUnauthorized)
+		return false
+	}
+
+	twoFactorLimiter.Reset(key)
+	return true
+}
+
+// verifySecondFactor accepts a TOTP code that has not been used before or an
+// unused recovery code, consuming it either way.
+func verifySecondFactor(tx *gorm.DB, user *models.User, code string) bool {
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		// The conditional update rejects replays, including concurrent ones
+		result := tx.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return false
+		}
+		user.TOTPLastStep = step
+		return true
+	}
+
+	result := tx.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and stores a fresh set,
+// returning the plaintext codes.
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(code)}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode returns a code like "k7m2p-x9qrt" without look-alike characters.
+func generateRecoveryCode() (string, error) {
+	var b strings.Builder
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(recoveryAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// hashRecoveryCode normalizes case, spaces and dashes before hashing.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return auth.HashToken(normalized)
+}
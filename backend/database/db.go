@@ -20,11 +20,47 @@ func ConnectDB() *gorm.DB {
 	}
 
 	//AutoMigrate all models
-	err = db.AutoMigrate(&models.User{}, &models.OTP{}, &models.Swamp{}, &models.Topic{}, &models.UserTopic{}, &models.SwampTopic{})
+	err = db.AutoMigrate(&models.User{}, &models.OTP{}, &models.RefreshToken{}, &models.RecoveryCode{}, &models.APIKey{}, &models.DataExport{}, &models.UserIdentity{}, &models.OIDCLoginState{}, &models.ChatMessage{}, &models.Swamp{}, &models.SwampMember{}, &models.SwampInvitation{}, &models.SwampInviteLink{}, &models.SwampRecurrence{}, &models.SwampOccurrence{}, &models.SwampRSVP{}, &models.CalendarFeed{}, &models.Topic{}, &models.UserTopic{}, &models.SwampTopic{})
 	if err != nil {
 		log.Fatalf("Failed to migrate database %v:", err)
 	}
 
+	// OTPs used to be unique per email; they are now unique per email and purpose
+	if db.Migrator().HasIndex(&models.OTP{}, "idx_otps_email") {
+		if err := db.Migrator().DropIndex(&models.OTP{}, "idx_otps_email"); err != nil {
+			log.Fatalf("Failed to drop legacy OTP index: %v", err)
+		}
+	}
+
+	// Admins used to be flagged with is_admin; the role column replaces it
+	if db.Migrator().HasColumn(&models.User{}, "is_admin") {
+		if err := db.Exec("UPDATE users SET role = ? WHERE is_admin", models.RoleAdmin).Error; err != nil {
+			log.Fatalf("Failed to migrate admin flags: %v", err)
+		}
+		if err := db.Migrator().DropColumn(&models.User{}, "is_admin"); err != nil {
+			log.Fatalf("Failed to drop is_admin column: %v", err)
+		}
+	}
+
+	// Swamps cancelled before the status column existed were only flagged deleted
+	if err := db.Model(&models.Swamp{}).Unscoped().Where("deleted = ? AND status <> ?", true, models.SwampStatusCancelled).
+		Update("status", models.SwampStatusCancelled).Error; err != nil {
+		log.Fatalf("Failed to migrate cancelled swamps: %v", err)
+	}
+
+	// Swamps used to have a single topic; make sure it is in the join table
+	if err := db.Exec("INSERT INTO swamp_topics (swamp_id, topic_id) SELECT id, topic_id FROM swamps WHERE topic_id <> 0 ON CONFLICT DO NOTHING").Error; err != nil {
+		log.Fatalf("Failed to migrate swamp topics: %v", err)
+	}
+
+	// Title search uses LOWER(title) LIKE '%...%', which only a trigram index
+	// can serve. pg_trgm may need a superuser, so a failure is not fatal.
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		log.Printf("pg_trgm unavailable, swamp title search will not be indexed: %v", err)
+	} else if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_swamps_title_trgm ON swamps USING gin (LOWER(title) gin_trgm_ops)").Error; err != nil {
+		log.Printf("Failed to create swamp title search index: %v", err)
+	}
+
 	DB = db // Assign database instance to the global DB variable
 	fmt.Println("Database connected and tables migrated successfully!")
 	return DB
@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"log"
+
+	"swamp/database"
+	"swamp/models"
 	"swamp/pkg/chat"
 	w "swamp/pkg/webrtc"
 
@@ -13,6 +17,11 @@ func RoomChatWebsocket(c *websocket.Conn) {
 		return
 	}
 
+	identity, ok := socketIdentity(c)
+	if !ok {
+		return
+	}
+
 	w.RoomsLock.Lock()
 	room := w.Rooms[uuid]
 	w.RoomsLock.Unlock()
@@ -22,5 +31,22 @@ func RoomChatWebsocket(c *websocket.Conn) {
 	if room.Hub == nil {
 		return
 	}
-	chat.PeerChatConn(c.Conn, room.Hub)
+	identity, ok = enterRoom(c, identity, room)
+	if !ok {
+		return
+	}
+	chat.PeerChatConn(c.Conn, room.Hub, identity)
+}
+
+// persistChat returns a Hub.OnMessage hook that stores the room's messages
+// so they can be exported or anonymized later. The hub runs it off the
+// clients' read loops.
+func persistChat(roomUUID string) func(chat.Message) {
+	return func(message chat.Message) {
+		userID := message.UserID
+		record := models.ChatMessage{RoomUUID: roomUUID, UserID: &userID, AuthorName: message.User, Text: message.Text}
+		if err := database.DB.Create(&record).Error; err != nil {
+			log.Printf("failed to store chat message for room %s: %v", roomUUID, err)
+		}
+	}
 }
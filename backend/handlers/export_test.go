@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"time"
+
+	"swamp/pkg/ratelimit"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// EchoIdentity writes the authenticated user's name back to the client.
+func EchoIdentity(c *websocket.Conn) {
+	identity, ok := socketIdentity(c)
+	if !ok {
+		return
+	}
+	c.WriteMessage(websocket.TextMessage, []byte(identity.Name))
+}
+
+// ResetPasscodeLimits clears the passcode limiter between tests.
+func ResetPasscodeLimits() {
+	passcodeLimiter = ratelimit.New(10, 10*time.Minute)
+}
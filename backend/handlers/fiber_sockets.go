@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"fmt"
+	"swamp/database"
+	"swamp/models"
 	"swamp/pkg/chat"
 	w "swamp/pkg/webrtc"
 	"time"
@@ -18,11 +20,28 @@ func RoomWebsocket(c *websocket.Conn) {
 		return
 	}
 
-	_, _, room := createOrGetRoom(uuid)
-	w.RoomConn(c, room.Peers)
+	identity, ok := socketIdentity(c)
+	if !ok {
+		return
+	}
+	swamp, reason := liveSwamp(uuid, time.Now())
+	if reason != "" {
+		rejectSocket(c, reason)
+		return
+	}
+	identity, ok = admit(c, identity, swamp)
+	if !ok {
+		return
+	}
+
+	// ?waitlist=true queues the publisher when the room is full
+	_, _, room := createOrGetRoom(uuid, swamp)
+	w.RoomConn(c, room, identity, c.Query("waitlist") == "true")
 }
 
-func createOrGetRoom(uuid string) (string, string, *w.Room) {
+// createOrGetRoom returns the live room for uuid, opening it for swamp if
+// needed.
+func createOrGetRoom(uuid string, swamp *models.Swamp) (string, string, *w.Room) {
 	w.RoomsLock.Lock()
 	defer w.RoomsLock.Unlock()
 
@@ -50,9 +69,14 @@ func createOrGetRoom(uuid string) (string, string, *w.Room) {
 	hub := chat.NewHub()
 	p := &w.Peers{}
 	p.TrackLocals = make(map[string]*webrtc.TrackLocalStaticRTP)
+	hub.OnKick = func(userID uint) { p.Kick(userID) }
+	hub.OnMessage = persistChat(uuid)
 	room := &w.Room{
-		Peers: p,
-		Hub:   hub,
+		UUID:            uuid,
+		SwampID:         uint(swamp.ID),
+		MaxParticipants: swamp.MaxParticipants,
+		Peers:           p,
+		Hub:             hub,
 	}
 
 	w.Rooms[uuid] = room
@@ -62,15 +86,43 @@ func createOrGetRoom(uuid string) (string, string, *w.Room) {
 	return uuid, suuid, room
 }
 
+// liveSwamp loads the swamp behind uuid and, unless it is live at now,
+// explains why nobody may publish to its room. Going by the clock rather
+// than the stored status keeps a lagging scheduler from opening rooms late.
+func liveSwamp(uuid string, now time.Time) (*models.Swamp, string) {
+	var swamp models.Swamp
+	if err := database.DB.Unscoped().Where("uuid = ?", uuid).First(&swamp).Error; err != nil {
+		return nil, "Swamp not found"
+	}
+	switch {
+	case swamp.Deleted || swamp.DeletedAt.Valid || swamp.Status == models.SwampStatusCancelled:
+		return nil, "This swamp has been cancelled"
+	case swamp.LiveAt(now):
+		return &swamp, ""
+	case now.Before(swamp.StartTime):
+		return nil, "This swamp has not started yet"
+	default:
+		return nil, "This swamp has ended"
+	}
+}
+
 func RoomViewerWebsocket(c *websocket.Conn) {
 	uuid := c.Params("uuid")
 	if uuid == "" {
 		return
 	}
 
+	identity, ok := socketIdentity(c)
+	if !ok {
+		return
+	}
+
 	w.RoomsLock.Lock()
 	if peer, ok := w.Rooms[uuid]; ok {
 		w.RoomsLock.Unlock()
+		if _, ok := enterRoom(c, identity, peer); !ok {
+			return
+		}
 		roomViewerConn(c, peer.Peers)
 		return
 	}
@@ -85,6 +137,9 @@ func roomViewerConn(c *websocket.Conn, p *w.Peers) {
 	for {
 		select {
 		case <-ticker.C:
+			if p.IsClosed() {
+				return
+			}
 			w, err := c.Conn.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
@@ -100,10 +155,18 @@ func StreamWebsocket(c *websocket.Conn) {
 		return
 	}
 
+	identity, ok := socketIdentity(c)
+	if !ok {
+		return
+	}
+
 	w.RoomsLock.Lock()
 	if stream, ok := w.Streams[suuid]; ok {
 		w.RoomsLock.Unlock()
-		w.StreamConn(c, stream.Peers)
+		if _, ok := enterRoom(c, identity, stream); !ok {
+			return
+		}
+		w.StreamConn(c, stream.Peers, identity)
 		return
 	}
 	w.RoomsLock.Unlock()
@@ -115,9 +178,17 @@ func StreamViewerWebsocket(c *websocket.Conn) {
 		return
 	}
 
+	identity, ok := socketIdentity(c)
+	if !ok {
+		return
+	}
+
 	w.RoomsLock.Lock()
 	if stream, ok := w.Streams[suuid]; ok {
 		w.RoomsLock.Unlock()
+		if _, ok := enterRoom(c, identity, stream); !ok {
+			return
+		}
 		viewerConn(c, stream.Peers)
 		return
 	}
@@ -132,6 +203,9 @@ func viewerConn(c *websocket.Conn, p *w.Peers) {
 	for {
 		select {
 		case <-ticker.C:
+			if p.IsClosed() {
+				return
+			}
 			w, err := c.Conn.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
@@ -147,16 +221,32 @@ func StreamChatWebsocket(c *websocket.Conn) {
 		return
 	}
 
+	identity, ok := socketIdentity(c)
+	if !ok {
+		return
+	}
+
+	// The hub is created under the lock, as in createOrGetRoom, so that
+	// concurrent joins share one hub and its kick and persistence hooks
 	w.RoomsLock.Lock()
-	if stream, ok := w.Streams[suuid]; ok {
+	stream, ok := w.Streams[suuid]
+	if !ok {
 		w.RoomsLock.Unlock()
-		if stream.Hub == nil {
-			hub := chat.NewHub()
-			stream.Hub = hub
-			go hub.Run()
-		}
-		chat.PeerChatConn(c.Conn, stream.Hub)
 		return
 	}
+	if stream.Hub == nil {
+		hub := chat.NewHub()
+		hub.OnKick = func(userID uint) { stream.Peers.Kick(userID) }
+		hub.OnMessage = persistChat(stream.UUID)
+		stream.Hub = hub
+		go hub.Run()
+	}
+	hub := stream.Hub
 	w.RoomsLock.Unlock()
+
+	identity, ok = enterRoom(c, identity, stream)
+	if !ok {
+		return
+	}
+	chat.PeerChatConn(c.Conn, hub, identity)
 }
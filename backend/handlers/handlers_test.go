@@ -1,16 +1,40 @@
 package handlers_test
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"strings"
+	"swamp/database"
 	"swamp/handlers"
+	"swamp/models"
+	"swamp/pkg/auth"
+	w "swamp/pkg/webrtc"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	fiberws "github.com/gofiber/websocket/v2"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+// TestMain gives the package the signing key main reads from JWT_SECRET.
+func TestMain(m *testing.M) {
+	if err := auth.SetSigningKey("swamp-test-signing-key-0123456789"); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
 // TestWSHandler tests the WebSocket handler
 func TestWSHandler(t *testing.T) {
 	// Create test server
@@ -54,4 +78,376 @@ func TestWSHandler(t *testing.T) {
 			assert.Equal(t, testMessage, response)
 		}
 	})
-}
\ No newline at end of file
+}
+// TestSocketAuth tests the websocket handshake authentication
+func TestSocketAuth(t *testing.T) {
+	var err error
+	database.DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, database.DB.AutoMigrate(&models.User{}))
+
+	user := models.User{Email: "socket@example.com", FullName: "Socket User"}
+	database.DB.Create(&user)
+	token, _, err := auth.IssueAccessToken(user.ID, user.Email, user.TokenVersion)
+	assert.NoError(t, err)
+
+	app := fiber.New()
+	app.Get("/room/:uuid/websocket", handlers.SocketAuth, fiberws.New(handlers.EchoIdentity))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	wsURL := "ws://" + ln.Addr().String() + "/room/abc/websocket"
+
+	t.Run("Token in query string", func(t *testing.T) {
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL+"?token="+token, nil)
+		assert.NoError(t, err)
+		defer ws.Close()
+
+		_, msg, err := ws.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "Socket User", string(msg))
+	})
+
+	t.Run("Invalid token is rejected before upgrade", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?token=bogus", nil)
+		assert.Error(t, err)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("Token in first message", func(t *testing.T) {
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		assert.NoError(t, err)
+		defer ws.Close()
+
+		assert.NoError(t, ws.WriteJSON(map[string]string{"event": "auth", "data": token}))
+		_, msg, err := ws.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "Socket User", string(msg))
+	})
+
+	t.Run("Missing token closes the socket", func(t *testing.T) {
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		assert.NoError(t, err)
+		defer ws.Close()
+
+		assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte("hello")))
+		_, _, err = ws.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+	})
+}
+
+// TestChatSocketAPIKey checks that chat sockets accept scoped API keys and
+// media sockets do not
+func TestChatSocketAPIKey(t *testing.T) {
+	var err error
+	database.DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, database.DB.AutoMigrate(&models.User{}, &models.APIKey{}))
+
+	user := models.User{Email: "bot@example.com", FullName: "Chat Bot"}
+	database.DB.Create(&user)
+
+	newKey := func(scopes string) string {
+		key, hash, prefix, err := auth.NewAPIKey()
+		assert.NoError(t, err)
+		database.DB.Create(&models.APIKey{UserID: user.ID, Name: "bot", Prefix: prefix, KeyHash: hash, Scopes: scopes})
+		return key
+	}
+	chatKey := newKey(models.APIScopeChatWrite)
+	readKey := newKey(models.APIScopeSwampsRead)
+
+	app := fiber.New()
+	app.Get("/room/:uuid/chat/websocket", handlers.ChatSocketAuth, fiberws.New(handlers.EchoIdentity))
+	app.Get("/room/:uuid/websocket", handlers.SocketAuth, fiberws.New(handlers.EchoIdentity))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	base := "ws://" + ln.Addr().String() + "/room/abc"
+
+	bearer := func(key string) http.Header {
+		return http.Header{"Authorization": {"Bearer " + key}}
+	}
+
+	t.Run("chat accepts a key with chat:write", func(t *testing.T) {
+		ws, _, err := websocket.DefaultDialer.Dial(base+"/chat/websocket", bearer(chatKey))
+		assert.NoError(t, err)
+		defer ws.Close()
+
+		_, msg, err := ws.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "Chat Bot", string(msg))
+	})
+
+	t.Run("chat accepts a key in the first message", func(t *testing.T) {
+		ws, _, err := websocket.DefaultDialer.Dial(base+"/chat/websocket", nil)
+		assert.NoError(t, err)
+		defer ws.Close()
+
+		assert.NoError(t, ws.WriteJSON(map[string]string{"event": "auth", "data": chatKey}))
+		_, msg, err := ws.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "Chat Bot", string(msg))
+	})
+
+	t.Run("chat rejects a key in the URL", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(base+"/chat/websocket?token="+chatKey, nil)
+		assert.Error(t, err)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("chat rejects a key without the scope", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(base+"/chat/websocket", bearer(readKey))
+		assert.Error(t, err)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("media sockets reject API keys", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(base+"/websocket", bearer(chatKey))
+		assert.Error(t, err)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+}
+
+// TestRoomWebsocketLiveWindow checks that publishers are refused outside a
+// swamp's live window and from private swamps they were not invited to
+func TestRoomWebsocketLiveWindow(t *testing.T) {
+	var err error
+	database.DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, database.DB.AutoMigrate(&models.User{}, &models.Swamp{}, &models.SwampMember{}, &models.SwampInvitation{}))
+
+	user := models.User{Email: "host@example.com", FullName: "Host"}
+	database.DB.Create(&user)
+	token, _, err := auth.IssueAccessToken(user.ID, user.Email, user.TokenVersion)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	for _, swamp := range []models.Swamp{
+		{UUID: "upcoming", StartTime: now.Add(time.Hour), Duration: 30, Status: models.SwampStatusScheduled},
+		{UUID: "finished", StartTime: now.Add(-time.Hour), Duration: 30, Status: models.SwampStatusLive},
+		{UUID: "called-off", StartTime: now.Add(-time.Minute), Duration: 30, Status: models.SwampStatusCancelled, Deleted: true},
+		{UUID: "invite-only", OwnerID: int(user.ID) + 1, StartTime: now.Add(-time.Minute), Duration: 30, Status: models.SwampStatusLive,
+			Visibility: models.SwampVisibilityPrivate},
+	} {
+		database.DB.Create(&swamp)
+	}
+
+	app := fiber.New()
+	app.Get("/room/:uuid/websocket", handlers.SocketAuth, fiberws.New(handlers.RoomWebsocket))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	tests := []struct {
+		uuid   string
+		reason string
+	}{
+		{uuid: "upcoming", reason: "This swamp has not started yet"},
+		{uuid: "finished", reason: "This swamp has ended"},
+		{uuid: "called-off", reason: "This swamp has been cancelled"},
+		{uuid: "nowhere", reason: "Swamp not found"},
+		{uuid: "invite-only", reason: "This swamp is private"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uuid, func(t *testing.T) {
+			ws, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/room/"+tt.uuid+"/websocket?token="+token, nil)
+			assert.NoError(t, err)
+			defer ws.Close()
+
+			_, _, err = ws.ReadMessage()
+			var closeErr *websocket.CloseError
+			if assert.ErrorAs(t, err, &closeErr) {
+				assert.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+				assert.Equal(t, tt.reason, closeErr.Text)
+			}
+		})
+	}
+}
+
+// TestRoomPasscode checks that passcode-protected rooms ask for it and
+// throttle wrong guesses per IP
+func TestRoomPasscode(t *testing.T) {
+	var err error
+	database.DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, database.DB.AutoMigrate(&models.User{}, &models.Swamp{}, &models.SwampMember{}, &models.SwampInvitation{}))
+	handlers.ResetPasscodeLimits()
+
+	owner := models.User{Email: "owner@example.com", FullName: "Owner"}
+	guest := models.User{Email: "guest@example.com", FullName: "Guest"}
+	database.DB.Create(&owner)
+	database.DB.Create(&guest)
+	ownerToken, _, _ := auth.IssueAccessToken(owner.ID, owner.Email, owner.TokenVersion)
+	guestToken, _, _ := auth.IssueAccessToken(guest.ID, guest.Email, guest.TokenVersion)
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("open sesame"), bcrypt.MinCost)
+	database.DB.Create(&models.Swamp{UUID: "locked", OwnerID: int(owner.ID), StartTime: time.Now().Add(-time.Minute),
+		Duration: 30, Status: models.SwampStatusLive, PasscodeHash: string(hash)})
+	w.RoomsLock.Lock()
+	w.Rooms = map[string]*w.Room{"locked": {UUID: "locked", Peers: &w.Peers{}}}
+	w.RoomsLock.Unlock()
+
+	app := fiber.New()
+	app.Get("/room/:uuid/viewer/websocket", handlers.SocketAuth, fiberws.New(handlers.RoomViewerWebsocket))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	dialWith := func(query string, header http.Header, frame string) (string, error) {
+		ws, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/room/locked/viewer/websocket?"+query, header)
+		if !assert.NoError(t, err) {
+			return "", err
+		}
+		defer ws.Close()
+		if frame != "" {
+			ws.WriteMessage(websocket.TextMessage, []byte(frame))
+		}
+		_, msg, err := ws.ReadMessage()
+		return string(msg), err
+	}
+	dial := func(token, passcode string) (string, error) {
+		frame := ""
+		if passcode != "" {
+			frame = fmt.Sprintf(`{"event":"passcode","data":%q}`, passcode)
+		}
+		return dialWith("token="+token, nil, frame)
+	}
+	closeReason := func(err error) string {
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return closeErr.Text
+		}
+		return ""
+	}
+
+	t.Run("missing passcode", func(t *testing.T) {
+		// The query string is not read, so passcodes stay out of access logs
+		_, err := dialWith("token="+guestToken+"&passcode="+url.QueryEscape("open sesame"), nil, `{"event":"passcode","data":""}`)
+		assert.Equal(t, "Passcode required", closeReason(err))
+	})
+
+	t.Run("right passcode", func(t *testing.T) {
+		msg, err := dial(guestToken, "open sesame")
+		assert.NoError(t, err)
+		assert.Equal(t, "0", msg)
+
+		msg, err = dialWith("token="+guestToken, http.Header{"X-Swamp-Passcode": {"open sesame"}}, "")
+		assert.NoError(t, err)
+		assert.Equal(t, "0", msg)
+	})
+
+	t.Run("parallel guesses share the limit", func(t *testing.T) {
+		var wg sync.WaitGroup
+		reasons := make([]string, 20)
+		for i := range reasons {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := dial(guestToken, fmt.Sprintf("guess-%d", i))
+				reasons[i] = closeReason(err)
+			}(i)
+		}
+		wg.Wait()
+
+		incorrect := 0
+		for _, reason := range reasons {
+			if reason == "Incorrect passcode" {
+				incorrect++
+			}
+		}
+		assert.Equal(t, 10, incorrect)
+		handlers.ResetPasscodeLimits()
+	})
+
+	t.Run("wrong guesses are throttled", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			_, err := dial(guestToken, fmt.Sprintf("guess-%d", i))
+			assert.Equal(t, "Incorrect passcode", closeReason(err))
+		}
+		_, err := dial(guestToken, "open sesame")
+		assert.Equal(t, "Too many passcode attempts, please try again later", closeReason(err))
+	})
+
+	t.Run("the owner needs no passcode", func(t *testing.T) {
+		msg, err := dial(ownerToken, "")
+		assert.NoError(t, err)
+		assert.Equal(t, "0", msg)
+	})
+}
+
+// TestStreamChatHub checks that viewers joining a stream's chat at the same
+// time all end up in one hub
+func TestStreamChatHub(t *testing.T) {
+	var err error
+	database.DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, database.DB.AutoMigrate(&models.User{}, &models.Swamp{}, &models.SwampMember{},
+		&models.SwampInvitation{}, &models.ChatMessage{}))
+	sqlDB, _ := database.DB.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	owner := models.User{Email: "host@example.com", FullName: "Host"}
+	database.DB.Create(&owner)
+	database.DB.Create(&models.Swamp{UUID: "chatty", OwnerID: int(owner.ID), StartTime: time.Now().Add(-time.Minute),
+		Duration: 30, Status: models.SwampStatusLive})
+	stream := &w.Room{UUID: "chatty", Peers: &w.Peers{}}
+	w.RoomsLock.Lock()
+	w.Streams = map[string]*w.Room{"chatty-stream": stream}
+	w.RoomsLock.Unlock()
+
+	app := fiber.New()
+	app.Get("/stream/:suuid/chat/websocket", handlers.ChatSocketAuth, fiberws.New(handlers.StreamChatWebsocket))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	viewers := make([]*websocket.Conn, 8)
+	var wg sync.WaitGroup
+	for i := range viewers {
+		viewer := models.User{Email: fmt.Sprintf("viewer%d@example.com", i), FullName: fmt.Sprintf("Viewer %d", i)}
+		database.DB.Create(&viewer)
+		token, _, _ := auth.IssueAccessToken(viewer.ID, viewer.Email, viewer.TokenVersion)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			viewers[i], _, _ = websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/stream/chatty-stream/chat/websocket?token="+token, nil)
+		}(i)
+	}
+	wg.Wait()
+	for _, ws := range viewers {
+		if !assert.NotNil(t, ws) {
+			return
+		}
+		defer ws.Close()
+	}
+	// Give every handler time to register with its hub
+	time.Sleep(200 * time.Millisecond)
+
+	assert.NoError(t, viewers[0].WriteMessage(websocket.TextMessage, []byte("hello")))
+	for i, ws := range viewers {
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := ws.ReadMessage()
+		assert.NoError(t, err, "viewer %d", i)
+		assert.Contains(t, string(msg), `"text":"hello"`)
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	"swamp/models"
+	"swamp/pkg/auth"
+	"swamp/pkg/ratelimit"
+
+	"github.com/gofiber/websocket/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passcodeHeader carries the room passcode for clients that can set headers
+// on the handshake. Browsers send a passcode message instead.
+const passcodeHeader = "X-Swamp-Passcode"
+
+// passcodeLimiter caps wrong room passcodes per client IP, across swamps.
+var passcodeLimiter = ratelimit.New(10, 10*time.Minute)
+
+// checkPasscode makes callers of a passcode-protected swamp present it in
+// the X-Swamp-Passcode header or an {"event":"passcode","data":"..."} frame
+// sent after authenticating. It is kept out of the URL so it does not end up
+// in access logs. Hosts and moderators are let through. The socket is closed
+// when the check fails.
+func checkPasscode(c *websocket.Conn, identity auth.Identity, swamp *models.Swamp) bool {
+	if swamp == nil || swamp.PasscodeHash == "" || identity.Moderator {
+		return true
+	}
+
+	passcode := socketPasscode(c)
+	if passcode == "" {
+		rejectSocket(c, "Passcode required")
+		return false
+	}
+
+	// The guess is counted before comparing so parallel ones share the limit
+	ip, _ := c.Locals(clientIPLocal).(string)
+	if allowed, _ := passcodeLimiter.Allow(ip); !allowed {
+		rejectSocket(c, "Too many passcode attempts, please try again later")
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(swamp.PasscodeHash), []byte(passcode)) != nil {
+		rejectSocket(c, "Incorrect passcode")
+		return false
+	}
+	passcodeLimiter.Release(ip)
+	return true
+}
+
+// socketPasscode reads the passcode from the handshake header or, failing
+// that, from the next frame. It returns "" when neither carries one.
+func socketPasscode(c *websocket.Conn) string {
+	if passcode := c.Headers(passcodeHeader); passcode != "" {
+		return passcode
+	}
+
+	c.SetReadDeadline(time.Now().Add(authMessageWait))
+	_, raw, err := c.ReadMessage()
+	c.SetReadDeadline(time.Time{})
+	if err != nil {
+		return ""
+	}
+	var message authMessage
+	if err := json.Unmarshal(raw, &message); err != nil || message.Event != "passcode" {
+		return ""
+	}
+	return message.Data
+}
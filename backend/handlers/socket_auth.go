@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"swamp/database"
+	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/auth"
+	"swamp/pkg/policy"
+	w "swamp/pkg/webrtc"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+const (
+	identityLocal   = "identity"
+	apiKeyLocal     = "apiKeyScope"
+	clientIPLocal   = "clientIP"
+	authCookie      = "access_token"
+	authMessageWait = 5 * time.Second
+)
+
+// authMessage is the optional first frame for clients that cannot put the
+// token in the query string or a cookie. Passcode frames share its shape.
+type authMessage struct {
+	Event string `json:"event"`
+	Data  string `json:"data"`
+}
+
+// SocketAuth runs before the websocket upgrade. A token found in the "token"
+// query parameter, the access_token cookie or an Authorization header is
+// verified here so bad callers get a plain 401 and never reach the handler.
+// Requests without any token are upgraded and must authenticate with their
+// first message instead. URLs end up in access logs, so the query parameter
+// only takes short-lived access tokens, never API keys.
+func SocketAuth(c *fiber.Ctx) error {
+	return authenticateSocket(c, "")
+}
+
+// ChatSocketAuth is SocketAuth for chat sockets, which bots may also join
+// with a personal API key granted the chat:write scope.
+func ChatSocketAuth(c *fiber.Ctx) error {
+	return authenticateSocket(c, models.APIScopeChatWrite)
+}
+
+// authenticateSocket accepts API keys only when apiKeyScope is set.
+func authenticateSocket(c *fiber.Ctx, apiKeyScope string) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+	c.Locals(apiKeyLocal, apiKeyScope)
+	c.Locals(clientIPLocal, c.IP())
+
+	if auth.IsAPIKey(c.Query("token")) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "API keys must be sent in the Authorization header"})
+	}
+	token := handshakeToken(c)
+	if token == "" {
+		return c.Next()
+	}
+
+	user, err := userFromCredential(token, apiKeyScope)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Locals(identityLocal, identityOf(user))
+	return c.Next()
+}
+
+func handshakeToken(c *fiber.Ctx) string {
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+	if token := c.Cookies(authCookie); token != "" {
+		return token
+	}
+	if header := c.Get(fiber.HeaderAuthorization); len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// socketIdentity returns the caller resolved during the handshake or, failing
+// that, reads an {"event":"auth","data":"<token>"} frame. The connection is
+// closed when neither yields a valid user.
+func socketIdentity(c *websocket.Conn) (auth.Identity, bool) {
+	if identity, ok := c.Locals(identityLocal).(auth.Identity); ok {
+		return identity, true
+	}
+
+	c.SetReadDeadline(time.Now().Add(authMessageWait))
+	_, raw, err := c.ReadMessage()
+	c.SetReadDeadline(time.Time{})
+	if err != nil {
+		rejectSocket(c, "Authentication required")
+		return auth.Identity{}, false
+	}
+
+	var message authMessage
+	if err := json.Unmarshal(raw, &message); err != nil || message.Event != "auth" || message.Data == "" {
+		rejectSocket(c, "Authentication required")
+		return auth.Identity{}, false
+	}
+
+	scope, _ := c.Locals(apiKeyLocal).(string)
+	user, err := userFromCredential(message.Data, scope)
+	if err != nil {
+		rejectSocket(c, err.Error())
+		return auth.Identity{}, false
+	}
+
+	return identityOf(user), true
+}
+
+// userFromCredential resolves an access token or, where the socket allows
+// it, a personal API key.
+func userFromCredential(credential, apiKeyScope string) (*models.User, error) {
+	if apiKeyScope != "" && auth.IsAPIKey(credential) {
+		user, _, err := middleware.UserFromAPIKey(credential, apiKeyScope)
+		return user, err
+	}
+	return middleware.UserFromToken(credential)
+}
+
+func identityOf(user *models.User) auth.Identity {
+	return auth.Identity{UserID: user.ID, Name: user.FullName, Role: user.Role}
+}
+
+// privateSwampReason is the close reason for callers without an invitation.
+const privateSwampReason = "This swamp is private"
+
+// roomSwamp loads the swamp behind a live room, or returns nil.
+func roomSwamp(room *w.Room) *models.Swamp {
+	var swamp models.Swamp
+	if room == nil || room.UUID == "" || database.DB.Where("uuid = ?", room.UUID).First(&swamp).Error != nil {
+		return nil
+	}
+	return &swamp
+}
+
+// enterRoom closes the socket and reports false unless identity may join
+// room; see admit.
+func enterRoom(c *websocket.Conn, identity auth.Identity, room *w.Room) (auth.Identity, bool) {
+	return admit(c, identity, roomSwamp(room))
+}
+
+// admit checks that identity may join swamp's room, using the same
+// visibility rules as the REST API, and that they know its passcode. It
+// returns identity with the moderator flag resolved, or closes the socket
+// and reports false.
+func admit(c *websocket.Conn, identity auth.Identity, swamp *models.Swamp) (auth.Identity, bool) {
+	subject := policy.Subject{UserID: identity.UserID, Role: identity.Role}
+	if !policy.Can(database.DB, subject, policy.ViewSwamp, swamp) {
+		rejectSocket(c, privateSwampReason)
+		return identity, false
+	}
+	identity = moderatorOf(identity, swamp)
+	return identity, checkPasscode(c, identity, swamp)
+}
+
+// moderatorOf resolves whether identity may moderate swamp, using the same
+// policy as the REST API.
+func moderatorOf(identity auth.Identity, swamp *models.Swamp) auth.Identity {
+	subject := policy.Subject{UserID: identity.UserID, Role: identity.Role}
+	identity.Moderator = policy.Can(database.DB, subject, policy.ModerateSwamp, swamp)
+	return identity
+}
+
+// rejectSocket sends a policy-violation close frame and drops the connection.
+func rejectSocket(c *websocket.Conn, reason string) {
+	c.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason),
+		time.Now().Add(time.Second))
+	c.Close()
+}
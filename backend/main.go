@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"time"
 
+	"swamp/controllers"
 	"swamp/database"
+	"swamp/pkg/auth"
+	"swamp/pkg/lifecycle"
+	"swamp/pkg/mail"
+	"swamp/pkg/sso"
 	"swamp/routers"
 
 	"github.com/go-chi/chi/v5"
@@ -32,7 +38,7 @@ func startChiServer() {
 	r := chi.NewRouter()
 	r.Use(chi_cors.Handler(chi_cors.Options{
 		AllowedOrigins:   []string{"http://localhost:5173", "http://localhost:5174"}, 
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Content-Type", "Authorization"},
 		AllowCredentials: true,
 	}))
@@ -45,7 +51,7 @@ func startFiberServer() {
 	app := fiber.New()
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "http://localhost:5173,http://localhost:5174", 
-		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
+		AllowMethods:     "GET,POST,PUT,PATCH,DELETE,OPTIONS",
 		AllowHeaders:     "Content-Type,Authorization",
 		AllowCredentials: true,
 	}))
@@ -54,8 +60,35 @@ func startFiberServer() {
 	log.Fatal(app.Listen(":8081"))
 }
 
+func setupAuth() {
+	if err := auth.SigningKeyFromEnv(); err != nil {
+		log.Fatalf("Failed to configure token signing: %v", err)
+	}
+}
+
+func setupMailer() {
+	mailer, err := mail.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
+	controllers.Mailer = mailer
+}
+
+func setupSSO() {
+	providers, err := sso.LoadFromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to configure OpenID Connect providers: %v", err)
+	}
+	controllers.SSOProviders = providers
+}
+
 func main() {
+	setupAuth()
 	db = setupDatabase()
+	setupMailer()
+	setupSSO()
+	controllers.ResumeDataExports()
+	go lifecycle.Run(context.Background(), db)
 
 	// Start both servers in separate goroutines
 	go startChiServer()
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"swamp/database"
+	"swamp/models"
+	"swamp/pkg/auth"
+)
+
+const apiKeyContextKey contextKey = "apiKey"
+
+// lastUsedResolution limits last_used_at writes to one per key per minute.
+const lastUsedResolution = time.Minute
+
+var (
+	errInvalidAPIKey = errors.New("Invalid, expired or revoked API key")
+	errMissingScope  = errors.New("API key is missing the required scope")
+)
+
+// RequireAuthOrAPIKey is RequireAuth for routes scripts may call: it also
+// accepts a personal API key ("Authorization: Bearer swk_...") that was
+// granted scope. Routes without it only accept login sessions, so keys
+// cannot manage the account or mint more keys.
+func RequireAuthOrAPIKey(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		sessionOnly := RequireAuth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			credential := bearerToken(r)
+			if !auth.IsAPIKey(credential) {
+				sessionOnly.ServeHTTP(w, r)
+				return
+			}
+
+			user, key, err := UserFromAPIKey(credential, scope)
+			if errors.Is(err, errMissingScope) {
+				respondWithError(w, http.StatusForbidden, err.Error(), nil)
+				return
+			}
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, err.Error(), nil)
+				return
+			}
+
+			ctx := context.WithValue(WithUser(r.Context(), user), apiKeyContextKey, key)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// UserFromAPIKey verifies a personal API key, checks it carries scope and
+// loads its owner. It is shared by the Chi middleware and the chat socket.
+func UserFromAPIKey(credential, scope string) (*models.User, *models.APIKey, error) {
+	var key models.APIKey
+	if err := database.DB.Where("key_hash = ?", auth.HashToken(credential)).First(&key).Error; err != nil || !key.Active() {
+		return nil, nil, errInvalidAPIKey
+	}
+	if !key.HasScope(scope) {
+		return nil, nil, errMissingScope
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, key.UserID).Error; err != nil {
+		return nil, nil, errUnknownUser
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedResolution {
+		database.DB.Model(&key).Update("last_used_at", now)
+	}
+	return &user, &key, nil
+}
+
+// APIKeyFromContext returns the key that authenticated the request, if any.
+func APIKeyFromContext(ctx context.Context) (*models.APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey).(*models.APIKey)
+	return key, ok && key != nil
+}
@@ -1,11 +1,13 @@
 package middleware
 
 import (
-	"fmt"
 	"encoding/json"
 	"io"
 	"net/http"
 	"strings"
+	"unicode"
+
+	"swamp/models"
 )
 
 // ValidationError represents a validation error
@@ -27,8 +29,10 @@ type RequestOTPPayload struct {
 }
 
 type VerifyOTPPayload struct {
-	Email string `json:"email"`
-	Code  string `json:"otp"`
+	Email    string `json:"email"`
+	Code     string `json:"otp"`
+	FullName string `json:"fullName"`
+	Password string `json:"password"`
 }
 
 type LoginPayload struct {
@@ -36,6 +40,35 @@ type LoginPayload struct {
 	Password string `json:"password"`
 }
 
+type ResetPasswordPayload struct {
+	Email    string `json:"email"`
+	Code     string `json:"otp"`
+	Password string `json:"password"`
+}
+
+type TwoFactorLoginPayload struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+}
+
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type ChangeEmailPayload struct {
+	NewEmail string `json:"newEmail"`
+}
+
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type CreateAPIKeyPayload struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
 // ValidateRequest middleware validates the request payload
 func ValidateRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -52,12 +85,24 @@ func ValidateRequest(next http.Handler) http.Handler {
 
 		// Select the correct payload type based on the endpoint
 		switch path {
-		case "/api/request-otp":
+		case "/api/request-otp", "/api/password/forgot":
 			payload = &RequestOTPPayload{}
 		case "/api/verify-otp":
 			payload = &VerifyOTPPayload{}
 		case "/api/login":
 			payload = &LoginPayload{}
+		case "/api/password/reset":
+			payload = &ResetPasswordPayload{}
+		case "/api/login/2fa":
+			payload = &TwoFactorLoginPayload{}
+		case "/api/token/refresh", "/api/logout":
+			payload = &RefreshTokenPayload{}
+		case "/api/me/api-keys":
+			payload = &CreateAPIKeyPayload{}
+		case "/api/me/email":
+			payload = &ChangeEmailPayload{}
+		case "/api/me/password":
+			payload = &ChangePasswordPayload{}
 		default:
 			// If path is not in our list, skip validation
 			next.ServeHTTP(w, r)
@@ -81,13 +126,24 @@ func ValidateRequest(next http.Handler) http.Handler {
 
 		// Validate the payload based on the endpoint
 		switch path {
-		case "/api/request-otp":
+		case "/api/request-otp", "/api/password/forgot":
 			validationErrors = validateRequestOTP(payload.(*RequestOTPPayload))
 		case "/api/verify-otp":
-			fmt.Println("payload", payload)
 			validationErrors = validateVerifyOTP(payload.(*VerifyOTPPayload))
 		case "/api/login":
 			validationErrors = validateLogin(payload.(*LoginPayload))
+		case "/api/password/reset":
+			validationErrors = validateResetPassword(payload.(*ResetPasswordPayload))
+		case "/api/login/2fa":
+			validationErrors = validateTwoFactorLogin(payload.(*TwoFactorLoginPayload))
+		case "/api/token/refresh", "/api/logout":
+			validationErrors = validateRefreshToken(payload.(*RefreshTokenPayload))
+		case "/api/me/api-keys":
+			validationErrors = validateCreateAPIKey(payload.(*CreateAPIKeyPayload))
+		case "/api/me/email":
+			validationErrors = validateChangeEmail(payload.(*ChangeEmailPayload))
+		case "/api/me/password":
+			validationErrors = validateChangePassword(payload.(*ChangePasswordPayload))
 		}
 
 		// If there are validation errors, return a 400 response
@@ -120,28 +176,48 @@ func validateRequestOTP(payload *RequestOTPPayload) []ValidationError {
 	return errors
 }
 
-// Validate VerifyOTP payload
+// Validate VerifyOTP payload (signup)
 func validateVerifyOTP(payload *VerifyOTPPayload) []ValidationError {
+	errors := validateEmailAndCode(payload.Email, payload.Code)
+
+	name := strings.TrimSpace(payload.FullName)
+	if name == "" {
+		errors = append(errors, ValidationError{
+			Field:   "fullName",
+			Message: "Full name is required",
+		})
+	} else if len(name) < 2 || len(name) > 100 {
+		errors = append(errors, ValidationError{
+			Field:   "fullName",
+			Message: "Full name must be between 2 and 100 characters",
+		})
+	}
+
+	return append(errors, validatePasswordStrength(payload.Password)...)
+}
+
+// validateEmailAndCode checks the fields shared by every OTP verification
+func validateEmailAndCode(email, code string) []ValidationError {
 	var errors []ValidationError
-	fmt.Println("payload", payload)
-	if payload.Email == "" {
+
+	if email == "" {
 		errors = append(errors, ValidationError{
 			Field:   "email",
 			Message: "Email is required",
 		})
-	} else if !isValidEmail(payload.Email) {
+	} else if !isValidEmail(email) {
 		errors = append(errors, ValidationError{
 			Field:   "email",
 			Message: "Invalid email format",
 		})
 	}
 
-	if payload.Code == "" {
+	if code == "" {
 		errors = append(errors, ValidationError{
 			Field:   "code",
 			Message: "OTP code is required",
 		})
-	} else if len(payload.Code) != 6 {
+	} else if len(code) != 6 {
 		errors = append(errors, ValidationError{
 			Field:   "code",
 			Message: "OTP code must be 6 characters",
@@ -151,6 +227,44 @@ func validateVerifyOTP(payload *VerifyOTPPayload) []ValidationError {
 	return errors
 }
 
+// validatePasswordStrength applies to every new password: at least 8
+// characters with an upper-case letter, a lower-case letter and a digit.
+func validatePasswordStrength(password string) []ValidationError {
+	if password == "" {
+		return []ValidationError{{
+			Field:   "password",
+			Message: "Password is required",
+		}}
+	}
+
+	var hasUpper, hasLower, hasDigit bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		}
+	}
+
+	var errors []ValidationError
+	if len(password) < 8 {
+		errors = append(errors, ValidationError{
+			Field:   "password",
+			Message: "Password must be at least 8 characters",
+		})
+	}
+	if !hasUpper || !hasLower || !hasDigit {
+		errors = append(errors, ValidationError{
+			Field:   "password",
+			Message: "Password must contain upper-case, lower-case and numeric characters",
+		})
+	}
+	return errors
+}
+
 // Validate Login payload
 func validateLogin(payload *LoginPayload) []ValidationError {
 	var errors []ValidationError
@@ -182,6 +296,120 @@ func validateLogin(payload *LoginPayload) []ValidationError {
 	return errors
 }
 
+// Validate ResetPassword payload
+func validateResetPassword(payload *ResetPasswordPayload) []ValidationError {
+	errors := validateEmailAndCode(payload.Email, payload.Code)
+	return append(errors, validatePasswordStrength(payload.Password)...)
+}
+
+// Validate second login step payload
+func validateTwoFactorLogin(payload *TwoFactorLoginPayload) []ValidationError {
+	var errors []ValidationError
+
+	if payload.ChallengeToken == "" {
+		errors = append(errors, ValidationError{
+			Field:   "challengeToken",
+			Message: "Challenge token is required",
+		})
+	}
+
+	if payload.Code == "" {
+		errors = append(errors, ValidationError{
+			Field:   "code",
+			Message: "Authentication or recovery code is required",
+		})
+	}
+
+	return errors
+}
+
+// Validate refresh/logout payload
+func validateRefreshToken(payload *RefreshTokenPayload) []ValidationError {
+	var errors []ValidationError
+
+	if payload.RefreshToken == "" {
+		errors = append(errors, ValidationError{
+			Field:   "refreshToken",
+			Message: "Refresh token is required",
+		})
+	}
+
+	return errors
+}
+
+// Validate ChangeEmail payload
+func validateChangeEmail(payload *ChangeEmailPayload) []ValidationError {
+	var errors []ValidationError
+
+	if payload.NewEmail == "" {
+		errors = append(errors, ValidationError{
+			Field:   "newEmail",
+			Message: "New email is required",
+		})
+	} else if !isValidEmail(payload.NewEmail) {
+		errors = append(errors, ValidationError{
+			Field:   "newEmail",
+			Message: "Invalid email format",
+		})
+	}
+
+	return errors
+}
+
+// Validate ChangePassword payload
+func validateChangePassword(payload *ChangePasswordPayload) []ValidationError {
+	var errors []ValidationError
+
+	if payload.CurrentPassword == "" {
+		errors = append(errors, ValidationError{
+			Field:   "currentPassword",
+			Message: "Current password is required",
+		})
+	}
+
+	for _, e := range validatePasswordStrength(payload.NewPassword) {
+		e.Field = "newPassword"
+		errors = append(errors, e)
+	}
+
+	return errors
+}
+
+// Validate CreateAPIKey payload
+func validateCreateAPIKey(payload *CreateAPIKeyPayload) []ValidationError {
+	var errors []ValidationError
+
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		errors = append(errors, ValidationError{
+			Field:   "name",
+			Message: "Name is required",
+		})
+	} else if len(name) > 100 {
+		errors = append(errors, ValidationError{
+			Field:   "name",
+			Message: "Name must be at most 100 characters",
+		})
+	}
+
+	if len(payload.Scopes) == 0 {
+		errors = append(errors, ValidationError{
+			Field:   "scopes",
+			Message: "At least one scope is required",
+		})
+	}
+	for _, scope := range payload.Scopes {
+		if !models.ValidAPIScope(scope) {
+			errors = append(errors, ValidationError{
+				Field:   "scopes",
+				Message: "Unknown scope " + scope + "; valid scopes are " + strings.Join(models.APIScopes, ", "),
+			})
+		}
+	}
+
+	return errors
+}
+
 // Helper function to validate email format
 func isValidEmail(email string) bool {
 	// Simple email validation - can be made more robust
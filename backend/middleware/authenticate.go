@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"swamp/database"
+	"swamp/models"
+	"swamp/pkg/auth"
+)
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+var (
+	errInvalidToken   = errors.New("Invalid or expired token")
+	errUnknownUser    = errors.New("User no longer exists")
+	errSessionRevoked = errors.New("Session has been revoked")
+)
+
+// RequireAuth rejects requests without a valid bearer token and stores the
+// caller's models.User in the request context for downstream handlers.
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := bearerToken(r)
+		if tokenString == "" {
+			respondWithError(w, http.StatusUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		user, err := UserFromToken(tokenString)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error(), nil)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+	})
+}
+
+// UserFromToken verifies an access token and loads the user it was issued to.
+// It is shared by the Chi middleware and the Fiber socket handshake.
+func UserFromToken(tokenString string) (*models.User, error) {
+	claims, err := auth.ParseAccessToken(tokenString)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, errUnknownUser
+	}
+
+	// Tokens minted before the last "log out everywhere" are stale
+	if claims.Version != user.TokenVersion {
+		return nil, errSessionRevoked
+	}
+
+	return &user, nil
+}
+
+// UserFromContext returns the authenticated user stored by RequireAuth.
+func UserFromContext(ctx context.Context) (*models.User, bool) {
+	user, ok := ctx.Value(userContextKey).(*models.User)
+	return user, ok && user != nil
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
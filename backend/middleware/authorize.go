@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"swamp/database"
+	"swamp/models"
+	"swamp/pkg/policy"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// WithUser returns a copy of ctx carrying user as the authenticated caller.
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// IsSelfOrAdmin reports whether user may modify a resource owned by ownerID.
+func IsSelfOrAdmin(user *models.User, ownerID uint) bool {
+	if user == nil {
+		return false
+	}
+	return user.ID == ownerID || user.IsAdmin()
+}
+
+// RequireSelfOrAdmin guards user-scoped routes such as /api/user/{userID}/...
+// so that only the user named by the URL parameter, or an admin, gets through.
+// It must run after RequireAuth.
+func RequireSelfOrAdmin(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				respondWithError(w, http.StatusUnauthorized, "Authentication required", nil)
+				return
+			}
+
+			targetID, err := strconv.ParseUint(chi.URLParam(r, param), 10, 64)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid user id", nil)
+				return
+			}
+
+			if !IsSelfOrAdmin(user, uint(targetID)) {
+				respondWithError(w, http.StatusForbidden, "You do not have permission to modify this resource", nil)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePermission guards routes for site-wide actions such as creating
+// topics. Swamp-scoped actions need the swamp and are checked in the
+// controller with policy.Can. It must run after RequireAuth.
+func RequirePermission(action policy.Action) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				respondWithError(w, http.StatusUnauthorized, "Authentication required", nil)
+				return
+			}
+
+			if !policy.Can(database.DB, policy.SubjectOf(user), action, nil) {
+				respondWithError(w, http.StatusForbidden, "You do not have permission to perform this action", nil)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"swamp/database"
+	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/auth"
+	"swamp/pkg/policy"
+
+	"github.com/glebarez/sqlite"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// TestMain gives the package the signing key main reads from JWT_SECRET.
+func TestMain(m *testing.M) {
+	if err := auth.SetSigningKey("swamp-test-signing-key-0123456789"); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func initTestDB(t *testing.T) {
+	var err error
+	database.DB, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to connect test database: %v", err)
+	}
+
+	err = database.DB.AutoMigrate(&models.User{})
+	if err != nil {
+		t.Fatalf("failed to migrate schema: %v", err)
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	initTestDB(t)
+
+	user := models.User{Email: "auth@example.com", FullName: "Auth User"}
+	database.DB.Create(&user)
+
+	validToken, _, err := auth.IssueAccessToken(user.ID, user.Email, 0)
+	assert.NoError(t, err)
+	orphanToken, _, err := auth.IssueAccessToken(user.ID+100, "ghost@example.com", 0)
+	assert.NoError(t, err)
+	staleToken, _, err := auth.IssueAccessToken(user.ID, user.Email, -1)
+	assert.NoError(t, err)
+
+	handler := middleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := middleware.UserFromContext(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, user.ID, u.ID)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name           string
+		header         string
+		expectedStatus int
+	}{
+		{name: "missing header", header: "", expectedStatus: http.StatusUnauthorized},
+		{name: "malformed header", header: "Token abc", expectedStatus: http.StatusUnauthorized},
+		{name: "bad signature", header: "Bearer " + validToken + "x", expectedStatus: http.StatusUnauthorized},
+		{name: "unknown user", header: "Bearer " + orphanToken, expectedStatus: http.StatusUnauthorized},
+		{name: "revoked session", header: "Bearer " + staleToken, expectedStatus: http.StatusUnauthorized},
+		{name: "valid token", header: "Bearer " + validToken, expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/swamp", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+}
+
+func TestRequireSelfOrAdmin(t *testing.T) {
+	r := chi.NewRouter()
+	r.With(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			caller := &models.User{Model: gorm.Model{ID: 1}}
+			if req.Header.Get("X-Admin") == "1" {
+				caller = &models.User{Model: gorm.Model{ID: 2}, Role: models.RoleAdmin}
+			}
+			next.ServeHTTP(w, req.WithContext(middleware.WithUser(req.Context(), caller)))
+		})
+	}, middleware.RequireSelfOrAdmin("userID")).
+		Post("/api/user/{userID}/topics", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+	tests := []struct {
+		name           string
+		path           string
+		admin          bool
+		expectedStatus int
+	}{
+		{name: "own resource", path: "/api/user/1/topics", expectedStatus: http.StatusNoContent},
+		{name: "someone else's resource", path: "/api/user/3/topics", expectedStatus: http.StatusForbidden},
+		{name: "admin on someone else's resource", path: "/api/user/3/topics", admin: true, expectedStatus: http.StatusNoContent},
+		{name: "invalid id", path: "/api/user/abc/topics", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, nil)
+			if tt.admin {
+				req.Header.Set("X-Admin", "1")
+			}
+			rec := httptest.NewRecorder()
+
+			r.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	handler := middleware.RequirePermission(policy.CreateTopic)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	tests := []struct {
+		name           string
+		caller         *models.User
+		expectedStatus int
+	}{
+		{name: "anonymous", expectedStatus: http.StatusUnauthorized},
+		{name: "regular user", caller: &models.User{Model: gorm.Model{ID: 1}, Role: models.RoleUser}, expectedStatus: http.StatusForbidden},
+		{name: "moderator", caller: &models.User{Model: gorm.Model{ID: 2}, Role: models.RoleModerator}, expectedStatus: http.StatusCreated},
+		{name: "admin", caller: &models.User{Model: gorm.Model{ID: 3}, Role: models.RoleAdmin}, expectedStatus: http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/topics", nil)
+			if tt.caller != nil {
+				req = req.WithContext(middleware.WithUser(req.Context(), tt.caller))
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+}
+
+func TestRequireAuthOrAPIKey(t *testing.T) {
+	initTestDB(t)
+	database.DB.AutoMigrate(&models.APIKey{})
+
+	user := models.User{Email: "bot@example.com", FullName: "Bot Owner"}
+	database.DB.Create(&user)
+
+	newKey := func(scopes string, expiresAt, revokedAt *time.Time) (string, uint) {
+		key, hash, prefix, err := auth.NewAPIKey()
+		assert.NoError(t, err)
+		record := models.APIKey{UserID: user.ID, Name: "bot", Prefix: prefix, KeyHash: hash,
+			Scopes: scopes, ExpiresAt: expiresAt, RevokedAt: revokedAt}
+		database.DB.Create(&record)
+		return key, record.ID
+	}
+	past := time.Now().Add(-time.Hour)
+	writeKey, writeKeyID := newKey(models.APIScopeSwampsRead+" "+models.APIScopeSwampsWrite, nil, nil)
+	readKey, _ := newKey(models.APIScopeSwampsRead, nil, nil)
+	expiredKey, _ := newKey(models.APIScopeSwampsWrite, &past, nil)
+	revokedKey, _ := newKey(models.APIScopeSwampsWrite, nil, &past)
+	accessToken, _, _ := auth.IssueAccessToken(user.ID, user.Email, user.TokenVersion)
+
+	handler := middleware.RequireAuthOrAPIKey(models.APIScopeSwampsWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := middleware.UserFromContext(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, user.ID, u.ID)
+		w.WriteHeader(http.StatusOK)
+	}))
+	sessionOnly := middleware.RequireAuth(handler)
+
+	tests := []struct {
+		name           string
+		handler        http.Handler
+		credential     string
+		expectedStatus int
+	}{
+		{name: "key with scope", handler: handler, credential: writeKey, expectedStatus: http.StatusOK},
+		{name: "key without scope", handler: handler, credential: readKey, expectedStatus: http.StatusForbidden},
+		{name: "expired key", handler: handler, credential: expiredKey, expectedStatus: http.StatusUnauthorized},
+		{name: "revoked key", handler: handler, credential: revokedKey, expectedStatus: http.StatusUnauthorized},
+		{name: "unknown key", handler: handler, credential: "swk_nope", expectedStatus: http.StatusUnauthorized},
+		{name: "access token still works", handler: handler, credential: accessToken, expectedStatus: http.StatusOK},
+		{name: "session-only route refuses keys", handler: sessionOnly, credential: writeKey, expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/swamp", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.credential)
+			rec := httptest.NewRecorder()
+
+			tt.handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+
+	var used models.APIKey
+	database.DB.First(&used, writeKeyID)
+	assert.NotNil(t, used.LastUsedAt)
+}
+
+func TestValidateRequestSignup(t *testing.T) {
+	handler := middleware.ValidateRequest(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name           string
+		payload        map[string]string
+		expectedStatus int
+		expectedField  string
+	}{
+		{
+			name:           "valid signup",
+			payload:        map[string]string{"email": "a@example.com", "otp": "123456", "fullName": "Ann Example", "password": "Secure123"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing full name",
+			payload:        map[string]string{"email": "a@example.com", "otp": "123456", "password": "Secure123"},
+			expectedStatus: http.StatusBadRequest,
+			expectedField:  `"field":"fullName"`,
+		},
+		{
+			name:           "empty password",
+			payload:        map[string]string{"email": "a@example.com", "otp": "123456", "fullName": "Ann Example"},
+			expectedStatus: http.StatusBadRequest,
+			expectedField:  `"field":"password"`,
+		},
+		{
+			name:           "weak password",
+			payload:        map[string]string{"email": "a@example.com", "otp": "123456", "fullName": "Ann Example", "password": "password"},
+			expectedStatus: http.StatusBadRequest,
+			expectedField:  `"field":"password"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.payload)
+			req := httptest.NewRequest("POST", "/api/verify-otp", bytes.NewBuffer(body))
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expectedField != "" {
+				assert.Contains(t, rec.Body.String(), tt.expectedField)
+			}
+		})
+	}
+}
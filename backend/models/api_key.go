@@ -0,0 +1,62 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Scopes a personal API key can be granted. Sessions from the login flow
+// are not scoped.
+const (
+	APIScopeSwampsRead  = "swamps:read"  // list and view swamps and topics
+	APIScopeSwampsWrite = "swamps:write" // create swamps
+	APIScopeChatWrite   = "chat:write"   // join swamp chat over WebSocket
+)
+
+// APIScopes lists every scope a key can be granted.
+var APIScopes = []string{APIScopeSwampsRead, APIScopeSwampsWrite, APIScopeChatWrite}
+
+// APIKey is a long-lived credential a user creates for scripts. Only the
+// SHA-256 of the key is stored; Prefix lets the owner recognise it.
+type APIKey struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index;not null"`
+	Name       string `gorm:"not null"`
+	Prefix     string `gorm:"not null"`
+	KeyHash    string `gorm:"uniqueIndex;not null"`
+	Scopes     string `gorm:"not null"` // space-separated
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// Active reports whether the key can still authenticate.
+func (k *APIKey) Active() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt))
+}
+
+// ScopeList returns the granted scopes.
+func (k *APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+// HasScope reports whether the key was granted scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidAPIScope reports whether scope can be granted to a key.
+func ValidAPIScope(scope string) bool {
+	for _, s := range APIScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
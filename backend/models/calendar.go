@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// SwampRSVP records that a user plans to attend a swamp. Swamps a user has
+// RSVP'd to show up in their calendar feed.
+type SwampRSVP struct {
+	SwampID   uint      `gorm:"primaryKey" json:"swampId"`
+	UserID    uint      `gorm:"primaryKey;index" json:"userId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CalendarFeed is a user's subscribable iCalendar feed. Calendar apps can
+// only send the secret token in the feed URL, so like API keys only its
+// SHA-256 is stored; rotating it cuts off every existing subscription.
+type CalendarFeed struct {
+	UserID     uint       `gorm:"primaryKey" json:"-"`
+	TokenHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// ChatMessage is a persisted chat line from a swamp room. AuthorName is a
+// snapshot of the sender's name at the time; UserID is cleared when the
+// account is deleted.
+type ChatMessage struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	RoomUUID   string    `gorm:"index;not null" json:"roomUuid"`
+	UserID     *uint     `gorm:"index" json:"userId"`
+	AuthorName string    `json:"authorName"`
+	Text       string    `json:"text"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Data export states, in order.
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// Data export archive formats.
+const (
+	ExportFormatJSON = "json"
+	ExportFormatZIP  = "zip"
+)
+
+// DataExport tracks a personal data export requested by a user. The archive
+// is built in the background and kept until ExpiresAt.
+type DataExport struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"index;not null"`
+	Status      string `gorm:"not null;default:pending"`
+	Format      string `gorm:"not null"`
+	FilePath    string
+	Size        int64
+	Error       string
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+	ExpiresAt   *time.Time
+}
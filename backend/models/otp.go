@@ -6,13 +6,25 @@ import (
 	"gorm.io/gorm"
 )
 
-// OTP model for storing OTP codes
+// OTP purposes; a code is only accepted by the flow it was issued for.
+const (
+	OTPPurposeSignup        = "signup"
+	OTPPurposePasswordReset = "password_reset"
+	OTPPurposeEmailChange   = "email_change" // sent to the new address
+)
+
+// OTP model for storing OTP codes. Each email has at most one code per purpose.
 type OTP struct {
-	ID        uint      `gorm:"primaryKey"`
-	Email     string    `gorm:"uniqueIndex;not null"`
-	Code      string    `gorm:"not null"`
-	ExpiresAt time.Time `gorm:"not null"`
-	CreatedAt time.Time
+	ID          uint      `gorm:"primaryKey"`
+	Email       string    `gorm:"uniqueIndex:idx_otps_email_purpose;not null"`
+	Purpose     string    `gorm:"uniqueIndex:idx_otps_email_purpose;not null;default:signup"`
+	Code        string    `gorm:"not null" json:"-"` // bcrypt hash, never the plain code
+	ExpiresAt   time.Time `gorm:"not null"`
+	Attempts    int       `gorm:"not null;default:0"` // failed verifications for the current code
+	LockedUntil *time.Time
+	LastSentAt  time.Time
+	RequestIP   string
+	CreatedAt   time.Time
 }
 
 // BeforeCreate sets the expiration time for OTP
@@ -20,3 +32,8 @@ func (otp *OTP) BeforeCreate(tx *gorm.DB) (err error) {
 	otp.ExpiresAt = time.Now().Add(5 * time.Minute) // OTP expires in 5 minutes
 	return nil
 }
+
+// Locked reports whether verification is blocked after too many failed attempts.
+func (otp *OTP) Locked() bool {
+	return otp.LockedUntil != nil && time.Now().Before(*otp.LockedUntil)
+}
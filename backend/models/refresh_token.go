@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// RefreshToken is a persisted, single-use session credential. Tokens created by
+// rotating an earlier one share its FamilyID so a replayed token can revoke the
+// whole chain.
+type RefreshToken struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"index;not null"`
+	FamilyID  string    `gorm:"index;not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	RevokedAt *time.Time
+	UserAgent string
+	IPAddress string
+	CreatedAt time.Time
+}
+
+// Active reports whether the token can still be exchanged.
+func (t *RefreshToken) Active() bool {
+	return t.RevokedAt == nil && time.Now().Before(t.ExpiresAt)
+}
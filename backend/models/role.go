@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Site-wide roles, from least to most privileged.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Per-swamp roles. The owner is always Swamp.OwnerID; co-hosts are stored
+// as SwampMember rows.
+const (
+	SwampRoleOwner  = "owner"
+	SwampRoleCoHost = "co_host"
+)
+
+var roleRank = map[string]int{RoleUser: 0, RoleModerator: 1, RoleAdmin: 2}
+
+// ValidRole reports whether role is one of the site-wide roles.
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role grants at least the privileges of min.
+// Unknown roles are treated as RoleUser.
+func RoleAtLeast(role, min string) bool {
+	return roleRank[role] >= roleRank[min]
+}
+
+// SwampMember grants a user a role in a single swamp.
+type SwampMember struct {
+	SwampID   uint      `gorm:"primaryKey" json:"swampId"`
+	UserID    uint      `gorm:"primaryKey" json:"userId"`
+	Role      string    `gorm:"not null;default:co_host" json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
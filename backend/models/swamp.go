@@ -12,13 +12,25 @@ type Swamp struct {
 	UUID            string `gorm:"uniqueIndex"`
 	Title           string
 	// Topics          []int `gorm:"type:integer[]"`
-	OwnerID         int
+	OwnerID         int       `gorm:"index"`
 	MaxParticipants int
-	StartTime       time.Time
+	StartTime       time.Time `gorm:"index;index:idx_swamps_status_start_time,priority:2"`
 	Duration        int
 	CreatedAt       time.Time
 	UpdatedAt       time.Time
 	Deleted         bool
-	TopicID uint  `gorm:"not null" json:"TopicID"`
+	Status          string `gorm:"index;index:idx_swamps_status_start_time,priority:1;not null;default:scheduled"` // see swamp_status.go
+	Visibility      string `gorm:"index;not null;default:public"` // see swamp_invitation.go
+	PasscodeHash    string `json:"-"` // bcrypt hash of the optional room passcode
+	PasscodeRequired bool  `gorm:"-"` // set from PasscodeHash when loaded
+	TopicID uint  `gorm:"not null;index" json:"TopicID"` // primary topic, always among Topics
   	Topic   Topic `gorm:"foreignKey:TopicID" json:"Topic"`
+	Topics  []Topic `gorm:"many2many:swamp_topics;joinForeignKey:SwampID;joinReferences:TopicID" json:"Topics"`
+	Recurrence *SwampRecurrence `gorm:"foreignKey:SwampID" json:"Recurrence,omitempty"` // nil unless the swamp repeats
+}
+
+// AfterFind tells clients whether to ask for a passcode without exposing it.
+func (s *Swamp) AfterFind(tx *gorm.DB) error {
+	s.PasscodeRequired = s.PasscodeHash != ""
+	return nil
 }
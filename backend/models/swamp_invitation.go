@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// Swamp visibility modes.
+const (
+	SwampVisibilityPublic   = "public"   // listed and open to everyone
+	SwampVisibilityUnlisted = "unlisted" // open to anyone who has the link, left out of listings
+	SwampVisibilityPrivate  = "private"  // hosts and invited users only
+)
+
+// ValidVisibility reports whether visibility is one of the modes above.
+func ValidVisibility(visibility string) bool {
+	switch visibility {
+	case SwampVisibilityPublic, SwampVisibilityUnlisted, SwampVisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+// SwampInvitation lets one person into a private swamp. It is keyed to
+// either an account or an email address, so people can be invited before
+// they sign up.
+type SwampInvitation struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	SwampID   uint      `gorm:"index;not null" json:"swampId"`
+	UserID    *uint     `gorm:"index" json:"userId,omitempty"`
+	Email     string    `gorm:"index" json:"email,omitempty"` // lowercased
+	InvitedBy uint      `gorm:"not null" json:"invitedBy"`
+	LinkID    *uint     `json:"linkId,omitempty"` // the invite link that was redeemed, if any
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SwampInviteLink is a shareable link that turns into an invitation for
+// whoever redeems it. Only the SHA-256 of its token is stored.
+type SwampInviteLink struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	SwampID   uint       `gorm:"index;not null" json:"swampId"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	CreatedBy uint       `gorm:"not null" json:"createdBy"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	MaxUses   int        `gorm:"not null;default:0" json:"maxUses"` // 0 means unlimited
+	Uses      int        `gorm:"not null;default:0" json:"uses"`
+	RevokedAt *time.Time `json:"revokedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Expired reports whether the link is past its expiry.
+func (l *SwampInviteLink) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// UsedUp reports whether the link has no uses left.
+func (l *SwampInviteLink) UsedUp() bool {
+	return l.MaxUses > 0 && l.Uses >= l.MaxUses
+}
+
+// Active reports whether the link can still be redeemed.
+func (l *SwampInviteLink) Active(now time.Time) bool {
+	return l.RevokedAt == nil && !l.Expired(now) && !l.UsedUp()
+}
@@ -0,0 +1,206 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"swamp/pkg/recurrence"
+
+	"gorm.io/gorm"
+)
+
+// SwampRecurrence makes a swamp repeat. The swamp row itself always
+// describes the current or next occurrence, so listings, the feed and the
+// room lifecycle treat a series like any other swamp; the series is kept
+// here and the scheduler moves the swamp on once an occurrence ends.
+type SwampRecurrence struct {
+	SwampID   uint       `gorm:"primaryKey" json:"-"`
+	Rule      string     `gorm:"not null" json:"rule"`                 // RRULE value, see pkg/recurrence
+	Start     time.Time  `gorm:"not null" json:"start"`                // DTSTART of the series
+	Duration  int        `gorm:"not null" json:"duration"`             // minutes, unless an occurrence overrides it
+	Timezone  string     `gorm:"not null;default:UTC" json:"timezone"` // the rule repeats on wall-clock time here
+	LastStart *time.Time `gorm:"index" json:"-"`                       // start of the final occurrence, nil if the rule never ends
+	CreatedAt time.Time  `json:"-"`
+	UpdatedAt time.Time  `json:"-"`
+}
+
+// SwampOccurrence changes or cancels a single occurrence of a series. It is
+// keyed by the start the rule gives the occurrence, like RECURRENCE-ID in
+// iCalendar, and stored in UTC.
+type SwampOccurrence struct {
+	SwampID       uint       `gorm:"primaryKey" json:"-"`
+	OriginalStart time.Time  `gorm:"primaryKey" json:"originalStart"`
+	StartTime     *time.Time `json:"startTime,omitempty"`
+	Duration      *int       `json:"duration,omitempty"`
+	Cancelled     bool       `gorm:"not null;default:false" json:"cancelled"`
+	UpdatedAt     time.Time  `json:"-"`
+}
+
+// Occurrence is one instance of a swamp with any override applied.
+type Occurrence struct {
+	OriginalStart time.Time `json:"originalStart"`
+	StartTime     time.Time `json:"startTime"`
+	Duration      int       `json:"duration"`
+	Cancelled     bool      `json:"cancelled"`
+	Modified      bool      `json:"modified"` // moved or resized on its own
+}
+
+// EndTime is StartTime plus Duration minutes.
+func (o Occurrence) EndTime() time.Time {
+	return o.StartTime.Add(time.Duration(o.Duration) * time.Minute)
+}
+
+// BeforeSave records where the series ends, so listings can skip series
+// that are over without expanding them.
+func (r *SwampRecurrence) BeforeSave(tx *gorm.DB) error {
+	r.LastStart = nil
+	if rule, err := recurrence.Parse(r.Rule); err == nil {
+		if last, ok := rule.Last(r.Start.In(r.Location())); ok {
+			last = last.UTC()
+			r.LastStart = &last
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the series start is an occurrence of its rule.
+func (r *SwampRecurrence) Matches() bool {
+	rule, err := recurrence.Parse(r.Rule)
+	return err == nil && rule.Matches(r.Start.In(r.Location()))
+}
+
+// First returns the start of the first occurrence. It is Start itself
+// unless the series was saved before Start had to match the rule; false
+// means the rule never matches.
+func (r *SwampRecurrence) First() (time.Time, bool) {
+	rule, err := recurrence.Parse(r.Rule)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var first time.Time
+	found := false
+	rule.Each(r.Start.In(r.Location()), func(start time.Time) bool {
+		first, found = start, true
+		return false
+	})
+	return first.UTC(), found
+}
+
+// Location is the zone the rule repeats in, UTC if the stored one is unknown.
+func (r *SwampRecurrence) Location() *time.Location {
+	if loc, err := time.LoadLocation(r.Timezone); err == nil && r.Timezone != "" {
+		return loc
+	}
+	return time.UTC
+}
+
+// Includes reports whether the rule gives an occurrence starting at t.
+func (r *SwampRecurrence) Includes(t time.Time) bool {
+	rule, err := recurrence.Parse(r.Rule)
+	return err == nil && rule.Includes(r.Start.In(r.Location()), t)
+}
+
+// Occurrence returns the occurrence the rule starts at originalStart, with
+// its override from overrides applied.
+func (r *SwampRecurrence) Occurrence(originalStart time.Time, overrides []SwampOccurrence) Occurrence {
+	occurrence := Occurrence{OriginalStart: originalStart.UTC(), StartTime: originalStart.UTC(), Duration: r.Duration}
+	for _, override := range overrides {
+		if !override.OriginalStart.Equal(originalStart) {
+			continue
+		}
+		if override.StartTime != nil {
+			occurrence.StartTime = override.StartTime.UTC()
+			occurrence.Modified = true
+		}
+		if override.Duration != nil {
+			occurrence.Duration = *override.Duration
+			occurrence.Modified = true
+		}
+		occurrence.Cancelled = override.Cancelled
+	}
+	return occurrence
+}
+
+// Occurrences lists the occurrences starting in [from, to), cancelled ones
+// included, ordered by start. Occurrences moved into or out of the range
+// count where they now start.
+func (r *SwampRecurrence) Occurrences(from, to time.Time, overrides []SwampOccurrence) []Occurrence {
+	rule, err := recurrence.Parse(r.Rule)
+	if err != nil {
+		return nil
+	}
+
+	var occurrences []Occurrence
+	seen := map[int64]bool{}
+	for _, start := range rule.Between(r.Start.In(r.Location()), from, to) {
+		seen[start.Unix()] = true
+		if occurrence := r.Occurrence(start, overrides); inRange(occurrence.StartTime, from, to) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	for _, override := range overrides {
+		if override.StartTime == nil || seen[override.OriginalStart.Unix()] {
+			continue
+		}
+		if occurrence := r.Occurrence(override.OriginalStart, overrides); inRange(occurrence.StartTime, from, to) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+
+	sort.Slice(occurrences, func(i, j int) bool { return occurrences[i].StartTime.Before(occurrences[j].StartTime) })
+	return occurrences
+}
+
+// Next returns the occurrence that is running at now or, failing that, the
+// first one after it, skipping cancelled ones. It reports false once the
+// series is over.
+func (r *SwampRecurrence) Next(now time.Time, overrides []SwampOccurrence) (Occurrence, bool) {
+	rule, err := recurrence.Parse(r.Rule)
+	if err != nil {
+		return Occurrence{}, false
+	}
+
+	moved := map[int64]bool{}
+	longest := r.Duration
+	for _, override := range overrides {
+		if override.StartTime != nil {
+			moved[override.OriginalStart.Unix()] = true
+		}
+		if override.Duration != nil && *override.Duration > longest {
+			longest = *override.Duration
+		}
+	}
+	lookback := time.Duration(longest) * time.Minute
+
+	var next *Occurrence
+	// Occurrences that kept their start come in order; moved ones may not
+	rule.Each(r.Start.In(r.Location()), func(start time.Time) bool {
+		if moved[start.Unix()] || !start.Add(lookback).After(now) {
+			return true
+		}
+		occurrence := r.Occurrence(start, overrides)
+		if occurrence.Cancelled || !occurrence.EndTime().After(now) {
+			return true
+		}
+		next = &occurrence
+		return false
+	})
+	for _, override := range overrides {
+		if override.StartTime == nil || override.Cancelled {
+			continue
+		}
+		occurrence := r.Occurrence(override.OriginalStart, overrides)
+		if occurrence.EndTime().After(now) && (next == nil || occurrence.StartTime.Before(next.StartTime)) {
+			next = &occurrence
+		}
+	}
+
+	if next == nil {
+		return Occurrence{}, false
+	}
+	return *next, true
+}
+
+func inRange(t, from, to time.Time) bool {
+	return !t.Before(from) && t.Before(to)
+}
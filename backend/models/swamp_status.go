@@ -0,0 +1,70 @@
+package models
+
+import "time"
+
+// Swamp lifecycle states. A swamp moves forward through scheduled, lobby,
+// live and ended as time passes; cancelled can be reached from any state
+// before ended.
+const (
+	SwampStatusScheduled = "scheduled"
+	SwampStatusLobby     = "lobby"
+	SwampStatusLive      = "live"
+	SwampStatusEnded     = "ended"
+	SwampStatusCancelled = "cancelled"
+)
+
+// SwampLobbyWindow is how long before StartTime the lobby opens.
+const SwampLobbyWindow = 10 * time.Minute
+
+var statusOrder = map[string]int{
+	SwampStatusScheduled: 0,
+	SwampStatusLobby:     1,
+	SwampStatusLive:      2,
+	SwampStatusEnded:     3,
+}
+
+// CanTransition reports whether a swamp may move from one status to another.
+// Time-driven states only move forward, possibly skipping some when the
+// scheduler was not running, except that rescheduling can close a lobby
+// again. Ended and cancelled are final.
+func CanTransition(from, to string) bool {
+	if from == SwampStatusEnded || from == SwampStatusCancelled {
+		return false
+	}
+	if to == SwampStatusCancelled || (from == SwampStatusLobby && to == SwampStatusScheduled) {
+		return true
+	}
+	fromRank, ok := statusOrder[from]
+	toRank, known := statusOrder[to]
+	return ok && known && toRank > fromRank
+}
+
+// Finished reports whether the swamp has ended or was cancelled.
+func (s *Swamp) Finished() bool {
+	return s.Status == SwampStatusEnded || s.Status == SwampStatusCancelled
+}
+
+// EndTime is StartTime plus Duration minutes.
+func (s *Swamp) EndTime() time.Time {
+	return s.StartTime.Add(time.Duration(s.Duration) * time.Minute)
+}
+
+// StatusAt returns the status StartTime and Duration call for at now,
+// ignoring cancellation.
+func (s *Swamp) StatusAt(now time.Time) string {
+	switch {
+	case now.Before(s.StartTime.Add(-SwampLobbyWindow)):
+		return SwampStatusScheduled
+	case now.Before(s.StartTime):
+		return SwampStatusLobby
+	case now.Before(s.EndTime()):
+		return SwampStatusLive
+	default:
+		return SwampStatusEnded
+	}
+}
+
+// LiveAt reports whether publishers may be in the room at now.
+func (s *Swamp) LiveAt(now time.Time) bool {
+	return !s.Finished() && s.StatusAt(now) == SwampStatusLive
+}
@@ -1,7 +1,8 @@
 package models
 
 // SwampTopic is the join table between Swamps and Topics
-// It records which topics are associated with which swamp.
+// It records which topics are associated with which swamp, including the
+// primary one in Swamp.TopicID.
 // Composite primary key ensures uniqueness.
 
 type SwampTopic struct {
@@ -9,4 +9,27 @@ type User struct {
 	FullName string `json:"full_name"`
 	Email    string `json:"email" gorm:"uniqueIndex"`
 	Password string `json:"-"` // Password is not exposed in JSON responses
+	// Role is the site-wide role: RoleUser, RoleModerator or RoleAdmin
+	Role string `json:"role" gorm:"not null;default:user;index"`
+
+	// Profile
+	Bio       string `json:"bio"`
+	AvatarURL string `json:"avatar_url"`
+	Timezone  string `json:"timezone"` // IANA name, e.g. "Europe/Berlin"
+	// PendingEmail awaits confirmation with an OTP sent to it
+	PendingEmail string `json:"-"`
+
+	// TokenVersion is embedded in access tokens; bumping it invalidates all of them
+	TokenVersion int `json:"-" gorm:"not null;default:0"`
+
+	// Two-factor authentication (RFC 6238 TOTP)
+	TOTPEnabled       bool   `json:"totp_enabled" gorm:"not null;default:false"`
+	TOTPSecret        string `json:"-"`
+	TOTPPendingSecret string `json:"-"` // set during enrollment until the first code is confirmed
+	TOTPLastStep      int64  `json:"-"` // last accepted time step, so a code cannot be replayed
+}
+
+// IsAdmin reports whether the user holds the site-wide admin role.
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
 }
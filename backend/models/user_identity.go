@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to an account at an external OpenID Connect
+// provider. The (Provider, Subject) pair is stable even if the email changes.
+type UserIdentity struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	Provider  string `gorm:"uniqueIndex:idx_user_identities_provider_subject;not null"`
+	Subject   string `gorm:"uniqueIndex:idx_user_identities_provider_subject;not null"`
+	Email     string
+	CreatedAt time.Time
+}
+
+// OIDCLoginState holds what the callback needs to finish an authorization-code
+// flow: the PKCE verifier and nonce never leave the server.
+type OIDCLoginState struct {
+	ID           uint      `gorm:"primaryKey"`
+	StateHash    string    `gorm:"uniqueIndex;not null"`
+	Provider     string    `gorm:"not null"`
+	Nonce        string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"`
+	ExpiresAt    time.Time `gorm:"not null"`
+	CreatedAt    time.Time
+}
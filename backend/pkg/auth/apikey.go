@@ -0,0 +1,23 @@
+package auth
+
+import "strings"
+
+// APIKeyPrefix marks personal API keys so they can be told apart from
+// access tokens wherever a bearer credential is accepted.
+const APIKeyPrefix = "swk_"
+
+// NewAPIKey returns a new personal API key, its hash for storage and a short
+// display prefix that identifies it in listings.
+func NewAPIKey() (key, hash, display string, err error) {
+	token, _, err := NewOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + token
+	return key, HashToken(key), key[:len(APIKeyPrefix)+6], nil
+}
+
+// IsAPIKey reports whether a bearer credential looks like a personal API key.
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
@@ -0,0 +1,11 @@
+package auth
+
+// Identity is the authenticated caller attached to a socket connection so the
+// WebRTC and chat layers know who they are talking to.
+type Identity struct {
+	UserID uint   `json:"userId"`
+	Name   string `json:"name"`
+	Role   string `json:"role,omitempty"` // site-wide role
+	// Moderator is resolved per room: the caller may remove other participants
+	Moderator bool `json:"moderator,omitempty"`
+}
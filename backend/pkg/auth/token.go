@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	issuer            = "swamp"
+	AccessTokenTTL    = 15 * time.Minute
+	ChallengeTokenTTL = 5 * time.Minute
+
+	purposeAccess    = "access"
+	purposeChallenge = "2fa_challenge"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Claims is the payload carried by every token we sign. Purpose keeps a
+// two-factor challenge token from being used as an access token.
+type Claims struct {
+	Email   string `json:"email"`
+	Version int    `json:"ver"`
+	Purpose string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the numeric user ID stored in the subject claim.
+func (c *Claims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	return uint(id), nil
+}
+
+// minSecretLength is the shortest JWT_SECRET accepted; HS256 keys should
+// carry at least 256 bits.
+const minSecretLength = 32
+
+// ErrNoSigningKey is returned when tokens are issued before SetSigningKey.
+var ErrNoSigningKey = errors.New("token signing key is not configured")
+
+var signingKey []byte
+
+// SetSigningKey sets the HMAC secret tokens are signed and verified with.
+// It is called once at startup, before any request is served.
+func SetSigningKey(secret string) error {
+	if len(secret) < minSecretLength {
+		return fmt.Errorf("JWT_SECRET must be at least %d characters", minSecretLength)
+	}
+	signingKey = []byte(secret)
+	return nil
+}
+
+// SigningKeyFromEnv sets the signing key from JWT_SECRET. There is no
+// fallback, so servers refuse to start rather than sign with a known key.
+func SigningKeyFromEnv() error {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return errors.New("JWT_SECRET must be set")
+	}
+	return SetSigningKey(secret)
+}
+
+// IssueAccessToken signs a short-lived HS256 token for the given user. The
+// version must match the user's current TokenVersion for the token to be accepted.
+func IssueAccessToken(userID uint, email string, version int) (string, time.Time, error) {
+	return issue(userID, email, version, purposeAccess, AccessTokenTTL)
+}
+
+// IssueChallengeToken signs the token returned by the first login step when
+// two-factor authentication is enabled. It only proves the password was correct.
+func IssueChallengeToken(userID uint, email string, version int) (string, time.Time, error) {
+	return issue(userID, email, version, purposeChallenge, ChallengeTokenTTL)
+}
+
+// ParseAccessToken verifies the signature and expiry of a token and returns its claims.
+func ParseAccessToken(tokenString string) (*Claims, error) {
+	return parse(tokenString, purposeAccess)
+}
+
+// ParseChallengeToken verifies a token issued by IssueChallengeToken.
+func ParseChallengeToken(tokenString string) (*Claims, error) {
+	return parse(tokenString, purposeChallenge)
+}
+
+func issue(userID uint, email string, version int, purpose string, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	claims := Claims{
+		Email:   email,
+		Version: version,
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	if len(signingKey) == 0 {
+		return "", time.Time{}, ErrNoSigningKey
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(signingKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+func parse(tokenString, purpose string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if len(signingKey) == 0 {
+			return nil, ErrNoSigningKey
+		}
+		return signingKey, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid || claims.Purpose != purpose {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
@@ -2,10 +2,13 @@ package chat
 
 import (
 	"bytes"
+	"encoding/json"
 	"log"
 	"time"
 
 	"github.com/fasthttp/websocket"
+
+	"swamp/pkg/auth"
 )
 
 const (
@@ -26,14 +29,70 @@ var upgrader = websocket.FastHTTPUpgrader{
 }
 
 type Client struct {
-	Hub  *Hub
-	Conn *websocket.Conn
-	Send chan []byte
+	Hub      *Hub
+	Conn     *websocket.Conn
+	Send     chan []byte
+	Identity auth.Identity
+}
+
+// Message is the envelope broadcast to the room. Sender fields are filled in
+// from the authenticated identity so clients cannot post as someone else.
+type Message struct {
+	User   string `json:"user"`
+	UserID uint   `json:"userId"`
+	Text   string `json:"text"`
+}
+
+// kickCommand is sent by moderators to remove a participant from the room.
+type kickCommand struct {
+	Event  string `json:"event"`
+	UserID uint   `json:"userId"`
+}
+
+// moderate handles a moderation frame and reports whether raw was one.
+// Frames from non-moderators are never treated as commands.
+func (c *Client) moderate(raw []byte) bool {
+	if !c.Identity.Moderator {
+		return false
+	}
+	var command kickCommand
+	if err := json.Unmarshal(raw, &command); err != nil || command.Event != "kick" || command.UserID == 0 {
+		return false
+	}
+	if command.UserID != c.Identity.UserID {
+		c.Hub.Kick(command.UserID)
+	}
+	return true
+}
+
+// stamp wraps an incoming frame in a Message attributed to this client.
+// Plain-text frames are accepted as the message text.
+func (c *Client) stamp(raw []byte) (Message, []byte) {
+	var incoming struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &incoming); err != nil || incoming.Text == "" {
+		incoming.Text = string(raw)
+	}
+
+	message := Message{
+		User:   c.Identity.Name,
+		UserID: c.Identity.UserID,
+		Text:   incoming.Text,
+	}
+	out, err := json.Marshal(message)
+	if err != nil {
+		return message, raw
+	}
+	return message, out
 }
 
 func (c *Client) readPump() {
 	defer func() {
-		c.Hub.unregister <- c
+		select {
+		case c.Hub.unregister <- c:
+		case <-c.Hub.done:
+		}
 		c.Conn.Close()
 	}()
 	c.Conn.SetReadLimit(maxMessageSize)
@@ -48,7 +107,16 @@ func (c *Client) readPump() {
 			break
 		}
 		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-		c.Hub.broadcast <- message
+		if c.moderate(message) {
+			continue
+		}
+		stamped, out := c.stamp(message)
+		c.Hub.queueMessage(stamped)
+		select {
+		case c.Hub.broadcast <- out:
+		case <-c.Hub.done:
+			return
+		}
 	}
 }
 
@@ -90,9 +158,14 @@ func (c *Client) writePump() {
 	}
 }
 
-func PeerChatConn(c *websocket.Conn, hub *Hub) {
-	client := &Client{Hub: hub, Conn: c, Send: make(chan []byte, 256)}
-	client.Hub.register <- client
+func PeerChatConn(c *websocket.Conn, hub *Hub, identity auth.Identity) {
+	client := &Client{Hub: hub, Conn: c, Send: make(chan []byte, 256), Identity: identity}
+	select {
+	case client.Hub.register <- client:
+	case <-hub.done:
+		c.Close()
+		return
+	}
 
 	go client.writePump()
 	client.readPump()
@@ -1,10 +1,29 @@
 package chat
 
+import "sync"
+
+// messageQueueSize is how many messages may wait for OnMessage before
+// senders are held back.
+const messageQueueSize = 256
+
 type Hub struct {
 	clients    map[*Client]bool
 	broadcast  chan []byte
 	register   chan *Client
 	unregister chan *Client
+	kick       chan uint
+	shutdown   chan []byte
+	messages   chan Message
+	done       chan struct{}
+	closeOnce  sync.Once
+
+	// OnKick, if set, is called after a user is removed from the chat so the
+	// room can drop their media connections too.
+	OnKick func(userID uint)
+	// OnMessage, if set, is called with every chat message, e.g. to persist
+	// it. Calls happen in order on a goroutine of their own, so a slow hook
+	// does not hold up the room.
+	OnMessage func(message Message)
 }
 
 func NewHub() *Hub {
@@ -12,13 +31,29 @@ func NewHub() *Hub {
 		broadcast:  make(chan []byte),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		kick:       make(chan uint),
+		shutdown:   make(chan []byte),
+		messages:   make(chan Message, messageQueueSize),
+		done:       make(chan struct{}),
 		clients:    make(map[*Client]bool),
 	}
 }
 
 func (h *Hub) Run() {
+	go h.deliverMessages()
 	for {
 		select {
+		case final := <-h.shutdown:
+			for client := range h.clients {
+				select {
+				case client.Send <- final:
+				default:
+				}
+				close(client.Send)
+				delete(h.clients, client)
+			}
+			close(h.done)
+			return
 		case client := <-h.register:
 			h.clients[client] = true
 		case client := <-h.unregister:
@@ -26,6 +61,16 @@ func (h *Hub) Run() {
 				delete(h.clients, client)
 				close(client.Send)
 			}
+		case userID := <-h.kick:
+			for client := range h.clients {
+				if client.Identity.UserID == userID {
+					delete(h.clients, client)
+					close(client.Send)
+				}
+			}
+			if h.OnKick != nil {
+				go h.OnKick(userID)
+			}
 		case message := <-h.broadcast:
 			for client := range h.clients {
 				select {
@@ -38,3 +83,53 @@ func (h *Hub) Run() {
 		}
 	}
 }
+
+// queueMessage hands message to OnMessage without waiting for it to run.
+func (h *Hub) queueMessage(message Message) {
+	if h.OnMessage == nil {
+		return
+	}
+	select {
+	case h.messages <- message:
+	case <-h.done:
+	}
+}
+
+// deliverMessages calls OnMessage for queued messages until the hub stops,
+// then for those still waiting.
+func (h *Hub) deliverMessages() {
+	for {
+		select {
+		case message := <-h.messages:
+			h.OnMessage(message)
+		case <-h.done:
+			for {
+				select {
+				case message := <-h.messages:
+					h.OnMessage(message)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Kick disconnects every chat client belonging to userID.
+func (h *Hub) Kick(userID uint) {
+	select {
+	case h.kick <- userID:
+	case <-h.done:
+	}
+}
+
+// Close sends final to every client, disconnects them and stops Run. Later
+// calls and joins are ignored.
+func (h *Hub) Close(final []byte) {
+	h.closeOnce.Do(func() {
+		select {
+		case h.shutdown <- final:
+		case <-h.done:
+		}
+	})
+}
@@ -0,0 +1,213 @@
+// Package export collects everything stored about a user into a personal
+// data archive for privacy requests.
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"swamp/models"
+
+	"gorm.io/gorm"
+)
+
+// Archive is the full export. Credentials and secrets are never included:
+// models carry them with json:"-" and sessions and keys are summarised.
+type Archive struct {
+	GeneratedAt      time.Time                `json:"generatedAt"`
+	User             models.User              `json:"user"`
+	TopicPreferences []TopicPreference        `json:"topicPreferences"`
+	OwnedSwamps      []models.Swamp           `json:"ownedSwamps"`
+	SwampRoles       []models.SwampMember     `json:"swampRoles"`
+	Invitations      []models.SwampInvitation `json:"invitations"`
+	RSVPs            []models.SwampRSVP       `json:"rsvps"`
+	CalendarFeed     *models.CalendarFeed     `json:"calendarFeed"` // nil without a feed; the token hash is left out
+	ChatMessages     []models.ChatMessage     `json:"chatMessages"`
+	Sessions         []Session                `json:"sessions"`
+	APIKeys          []APIKey                 `json:"apiKeys"`
+	LinkedIdentities []LinkedIdentity         `json:"linkedIdentities"`
+}
+
+type TopicPreference struct {
+	TopicID uint   `json:"topicId"`
+	Name    string `json:"name"`
+}
+
+// Session is one login, i.e. one refresh token family.
+type Session struct {
+	StartedAt  time.Time  `json:"startedAt"`
+	LastUsedAt time.Time  `json:"lastUsedAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	UserAgent  string     `json:"userAgent"`
+	IPAddress  string     `json:"ipAddress"`
+}
+
+type APIKey struct {
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+type LinkedIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Build gathers the archive for userID.
+func Build(db *gorm.DB, userID uint) (*Archive, error) {
+	archive := &Archive{GeneratedAt: time.Now().UTC()}
+
+	if err := db.First(&archive.User, userID).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Table("user_topics").
+		Select("user_topics.topic_id AS topic_id, topics.name AS name").
+		Joins("LEFT JOIN topics ON topics.id = user_topics.topic_id").
+		Where("user_topics.user_id = ?", userID).
+		Order("user_topics.topic_id").
+		Scan(&archive.TopicPreferences).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Preload("Topic").Where("owner_id = ?", userID).Order("start_time").
+		Find(&archive.OwnedSwamps).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Where("user_id = ?", userID).Order("created_at").
+		Find(&archive.SwampRoles).Error; err != nil {
+		return nil, err
+	}
+
+	// Invitations sent to the address before signing up are the user's too
+	if err := db.Where("user_id = ? OR email = ?", userID, strings.ToLower(archive.User.Email)).Order("created_at").
+		Find(&archive.Invitations).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Where("user_id = ?", userID).Order("created_at").
+		Find(&archive.RSVPs).Error; err != nil {
+		return nil, err
+	}
+
+	var feeds []models.CalendarFeed
+	if err := db.Where("user_id = ?", userID).Limit(1).Find(&feeds).Error; err != nil {
+		return nil, err
+	}
+	if len(feeds) > 0 {
+		archive.CalendarFeed = &feeds[0]
+	}
+
+	if err := db.Where("user_id = ?", userID).Order("created_at").
+		Find(&archive.ChatMessages).Error; err != nil {
+		return nil, err
+	}
+
+	sessions, err := sessionHistory(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	archive.Sessions = sessions
+
+	var keys []models.APIKey
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		archive.APIKeys = append(archive.APIKeys, APIKey{
+			Name: k.Name, Prefix: k.Prefix, Scopes: k.ScopeList(), CreatedAt: k.CreatedAt,
+			LastUsedAt: k.LastUsedAt, ExpiresAt: k.ExpiresAt, RevokedAt: k.RevokedAt,
+		})
+	}
+
+	var identities []models.UserIdentity
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	for _, i := range identities {
+		archive.LinkedIdentities = append(archive.LinkedIdentities, LinkedIdentity{
+			Provider: i.Provider, Subject: i.Subject, Email: i.Email, CreatedAt: i.CreatedAt,
+		})
+	}
+
+	return archive, nil
+}
+
+// sessionHistory folds refresh tokens into one entry per login.
+func sessionHistory(db *gorm.DB, userID uint) ([]Session, error) {
+	var tokens []models.RefreshToken
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+
+	var sessions []Session
+	index := make(map[string]int)
+	for _, t := range tokens {
+		i, seen := index[t.FamilyID]
+		if !seen {
+			index[t.FamilyID] = len(sessions)
+			sessions = append(sessions, Session{StartedAt: t.CreatedAt, UserAgent: t.UserAgent, IPAddress: t.IPAddress})
+			i = len(sessions) - 1
+		}
+		// Tokens are ordered, so the last one describes the session's current state
+		s := &sessions[i]
+		s.LastUsedAt = t.CreatedAt
+		s.ExpiresAt = t.ExpiresAt
+		s.RevokedAt = t.RevokedAt
+	}
+	return sessions, nil
+}
+
+// WriteJSON writes the archive as a single indented JSON document.
+func WriteJSON(w io.Writer, archive *Archive) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(archive)
+}
+
+// WriteZIP writes one JSON file per section plus the complete export.json.
+func WriteZIP(w io.Writer, archive *Archive) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"export.json", archive},
+		{"user.json", archive.User},
+		{"topic_preferences.json", archive.TopicPreferences},
+		{"owned_swamps.json", archive.OwnedSwamps},
+		{"swamp_roles.json", archive.SwampRoles},
+		{"invitations.json", archive.Invitations},
+		{"rsvps.json", archive.RSVPs},
+		{"calendar_feed.json", archive.CalendarFeed},
+		{"chat_messages.json", archive.ChatMessages},
+		{"sessions.json", archive.Sessions},
+		{"api_keys.json", archive.APIKeys},
+		{"linked_identities.json", archive.LinkedIdentities},
+	}
+	for _, file := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: archive.GeneratedAt})
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(fw)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,120 @@
+// Package feed ranks swamps for a user's "For You" feed.
+package feed
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"swamp/models"
+)
+
+// Weights of the ranking signals. Topic overlap dominates so a user's
+// interests win over whatever happens to be busy.
+const (
+	topicWeight       = 3.0
+	liveWeight        = 1.5
+	participantWeight = 0.3
+	soonWeight        = 1.0
+)
+
+// Candidate is a swamp with what we know about it relative to the caller.
+type Candidate struct {
+	Swamp         models.Swamp
+	MatchedTopics []string // names of the caller's topics this swamp covers
+	TopicCount    int      // topics on the swamp
+	Participants  int      // connected to the live room right now
+}
+
+// Item is a ranked swamp and why it was recommended.
+type Item struct {
+	Swamp       models.Swamp `json:"swamp"`
+	Score       float64      `json:"score"`
+	Explanation string       `json:"explanation"`
+}
+
+// Rank scores candidates by topic overlap, live participants and how soon
+// they start, best first. Ties go to the earlier start.
+func Rank(candidates []Candidate, now time.Time) []Item {
+	items := make([]Item, 0, len(candidates))
+	for _, c := range candidates {
+		score, reasons := 0.0, []string{}
+
+		if len(c.MatchedTopics) > 0 && c.TopicCount > 0 {
+			score += topicWeight * float64(len(c.MatchedTopics)) / float64(c.TopicCount)
+			reasons = append(reasons, matchReason(c.MatchedTopics))
+		}
+
+		if c.Swamp.Status == models.SwampStatusLive {
+			score += liveWeight + participantWeight*math.Log1p(float64(c.Participants))
+			reasons = append(reasons, liveReason(c.Participants))
+		} else {
+			until := c.Swamp.StartTime.Sub(now)
+			if until < 0 {
+				until = 0
+			}
+			score += soonWeight / (1 + until.Hours()/24)
+			reasons = append(reasons, "starts in "+humanize(until))
+		}
+
+		items = append(items, Item{Swamp: c.Swamp, Score: math.Round(score*1000) / 1000, Explanation: sentence(reasons)})
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score > items[j].Score
+		}
+		return items[i].Swamp.StartTime.Before(items[j].Swamp.StartTime)
+	})
+	return items
+}
+
+func matchReason(topics []string) string {
+	if len(topics) == 1 {
+		return "matches your interest in " + topics[0]
+	}
+	return "matches your interests in " + strings.Join(topics[:len(topics)-1], ", ") + " and " + topics[len(topics)-1]
+}
+
+func liveReason(participants int) string {
+	switch participants {
+	case 0:
+		return "live now"
+	case 1:
+		return "live now with 1 participant"
+	default:
+		return fmt.Sprintf("live now with %d participants", participants)
+	}
+}
+
+// humanize renders a duration the way people say it: "5 minutes",
+// "3 hours", "2 days".
+func humanize(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case d < time.Minute:
+		return "less than a minute"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 48*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/(24*time.Hour)), "day")
+	}
+}
+
+// sentence joins reasons and capitalizes the first letter.
+func sentence(reasons []string) string {
+	s := strings.Join(reasons, "; ")
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
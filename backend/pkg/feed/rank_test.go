@@ -0,0 +1,41 @@
+package feed_test
+
+import (
+	"testing"
+	"time"
+
+	"swamp/models"
+	"swamp/pkg/feed"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRank(t *testing.T) {
+	now := time.Now()
+	swamp := func(uuid, status string, start time.Duration) models.Swamp {
+		return models.Swamp{UUID: uuid, Status: status, StartTime: now.Add(start), Duration: 60}
+	}
+
+	items := feed.Rank([]feed.Candidate{
+		{Swamp: swamp("soon", models.SwampStatusLobby, 5*time.Minute), TopicCount: 1},
+		{Swamp: swamp("busy", models.SwampStatusLive, -10*time.Minute), TopicCount: 1, Participants: 40},
+		{Swamp: swamp("match", models.SwampStatusScheduled, 72*time.Hour), TopicCount: 2, MatchedTopics: []string{"Go", "Rust"}},
+		{Swamp: swamp("quiet", models.SwampStatusLive, -time.Minute), TopicCount: 1},
+		{Swamp: swamp("half", models.SwampStatusScheduled, 72*time.Hour), TopicCount: 2, MatchedTopics: []string{"Go"}},
+	}, now)
+
+	var order []string
+	for _, item := range items {
+		order = append(order, item.Swamp.UUID)
+	}
+	assert.Equal(t, []string{"match", "busy", "half", "quiet", "soon"}, order)
+
+	explanations := map[string]string{}
+	for _, item := range items {
+		explanations[item.Swamp.UUID] = item.Explanation
+	}
+	assert.Equal(t, "Matches your interests in Go and Rust; starts in 3 days", explanations["match"])
+	assert.Equal(t, "Live now with 40 participants", explanations["busy"])
+	assert.Equal(t, "Live now", explanations["quiet"])
+	assert.Equal(t, "Starts in 5 minutes", explanations["soon"])
+}
@@ -0,0 +1,194 @@
+// Package ical writes iCalendar (RFC 5545) files so swamps can be added to
+// calendar apps.
+package ical
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const prodID = "-//Swamp//Swamp Calendar//EN"
+
+// maxLineOctets is where content lines are folded.
+const maxLineOctets = 75
+
+// Event is a VEVENT. Times are written in UTC unless Location is set, in
+// which case they carry its IANA name as TZID so a recurring event keeps
+// its wall-clock time, and the calendar describes the zone in a VTIMEZONE.
+type Event struct {
+	UID          string
+	Start        time.Time
+	Duration     time.Duration
+	Summary      string
+	Description  string
+	Categories   []string
+	Location     *time.Location
+	Rule         string      // RRULE value of a recurring event
+	ExDates      []time.Time // occurrences of the rule that do not happen
+	RecurrenceID *time.Time  // set when the event replaces one occurrence
+	Modified     time.Time   // LAST-MODIFIED and DTSTAMP; now when zero
+}
+
+// Calendar is a VCALENDAR.
+type Calendar struct {
+	Name   string // shown by calendar apps that subscribe to the feed
+	Events []Event
+}
+
+// Encode writes the calendar to w with CRLF line endings and long lines
+// folded, as RFC 5545 asks.
+func (c Calendar) Encode(w io.Writer) error {
+	var b strings.Builder
+	line := func(name, value string) {
+		b.WriteString(fold(name + ":" + value))
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", prodID)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME", escape(c.Name))
+	}
+	c.timezones(line)
+	for _, event := range c.Events {
+		modified := event.Modified
+		if modified.IsZero() {
+			modified = time.Now()
+		}
+
+		line("BEGIN", "VEVENT")
+		line("UID", escape(event.UID))
+		line("DTSTAMP", utc(modified))
+		line("LAST-MODIFIED", utc(modified))
+		b.WriteString(fold(dateTime("DTSTART", event.Location, event.Start)))
+		line("DURATION", duration(event.Duration))
+		if event.RecurrenceID != nil {
+			b.WriteString(fold(dateTime("RECURRENCE-ID", event.Location, *event.RecurrenceID)))
+		}
+		if event.Rule != "" {
+			line("RRULE", event.Rule)
+		}
+		if len(event.ExDates) > 0 {
+			b.WriteString(fold(dateTime("EXDATE", event.Location, event.ExDates...)))
+		}
+		line("SUMMARY", escape(event.Summary))
+		if event.Description != "" {
+			line("DESCRIPTION", escape(event.Description))
+		}
+		if len(event.Categories) > 0 {
+			categories := make([]string, len(event.Categories))
+			for i, category := range event.Categories {
+				categories[i] = escape(category)
+			}
+			line("CATEGORIES", strings.Join(categories, ","))
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// zoneHorizon is how far past now and the last event time zones are
+// described; recurring events go on beyond it with the zone's yearly rules.
+const zoneHorizon = 2
+
+// timezones writes a VTIMEZONE for every zone the events name in a TZID.
+func (c Calendar) timezones(line func(name, value string)) {
+	type span struct {
+		loc      *time.Location
+		from, to time.Time
+	}
+	spans := map[string]*span{}
+	for _, event := range c.Events {
+		if event.Location == nil || event.Location == time.UTC {
+			continue
+		}
+		times := append([]time.Time{event.Start}, event.ExDates...)
+		if event.RecurrenceID != nil {
+			times = append(times, *event.RecurrenceID)
+		}
+		zone := spans[event.Location.String()]
+		if zone == nil {
+			zone = &span{loc: event.Location, from: event.Start, to: event.Start}
+			spans[event.Location.String()] = zone
+		}
+		for _, t := range times {
+			if t.Before(zone.from) {
+				zone.from = t
+			}
+			if t.After(zone.to) {
+				zone.to = t
+			}
+		}
+	}
+
+	names := make([]string, 0, len(spans))
+	for name := range spans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	for _, name := range names {
+		zone := spans[name]
+		to := zone.to
+		if now.After(to) {
+			to = now
+		}
+		vtimezone(line, zone.loc, zone.from.UTC().Truncate(24*time.Hour).Add(-24*time.Hour), to.AddDate(zoneHorizon, 0, 0))
+	}
+}
+
+func utc(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// dateTime formats a date-time property, with a TZID when loc is set and
+// not UTC.
+func dateTime(name string, loc *time.Location, times ...time.Time) string {
+	values := make([]string, len(times))
+	if loc == nil || loc == time.UTC {
+		for i, t := range times {
+			values[i] = utc(t)
+		}
+		return name + ":" + strings.Join(values, ",")
+	}
+	for i, t := range times {
+		values[i] = t.In(loc).Format("20060102T150405")
+	}
+	return name + ";TZID=" + loc.String() + ":" + strings.Join(values, ",")
+}
+
+func duration(d time.Duration) string {
+	return fmt.Sprintf("PT%dM", int(d.Minutes()))
+}
+
+// escape escapes a TEXT value.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\r\n", `\n`, "\n", `\n`, "\r", "").Replace(s)
+}
+
+// fold ends line with CRLF, breaking it into continuation lines of at most
+// 75 octets without splitting UTF-8 sequences.
+func fold(line string) string {
+	var b strings.Builder
+	octets := 0
+	for _, r := range line {
+		size := utf8.RuneLen(r)
+		if octets+size > maxLineOctets {
+			b.WriteString("\r\n ")
+			octets = 1
+		}
+		b.WriteRune(r)
+		octets += size
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
@@ -0,0 +1,138 @@
+package ical_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"swamp/pkg/ical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encode(t *testing.T, calendar ical.Calendar) string {
+	var b strings.Builder
+	assert.NoError(t, calendar.Encode(&b))
+	return b.String()
+}
+
+func TestEncodeEvent(t *testing.T) {
+	start := time.Date(2026, time.March, 30, 9, 0, 0, 0, time.UTC)
+	out := encode(t, ical.Calendar{Name: "Team", Events: []ical.Event{{
+		UID:         "abc@swamp",
+		Start:       start,
+		Duration:    45 * time.Minute,
+		Summary:     "Retro; wins, losses",
+		Description: "Topic: Go\nBring notes",
+		Categories:  []string{"Go", "Ops, infra"},
+		Modified:    start.Add(-time.Hour),
+	}}})
+
+	assert.True(t, strings.HasPrefix(out, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	assert.True(t, strings.HasSuffix(out, "END:VEVENT\r\nEND:VCALENDAR\r\n"))
+	assert.NotContains(t, strings.ReplaceAll(out, "\r\n", ""), "\n")
+	for _, line := range []string{
+		"X-WR-CALNAME:Team",
+		"UID:abc@swamp",
+		"DTSTAMP:20260330T080000Z",
+		"DTSTART:20260330T090000Z",
+		"DURATION:PT45M",
+		`SUMMARY:Retro\; wins\, losses`,
+		`DESCRIPTION:Topic: Go\nBring notes`,
+		`CATEGORIES:Go,Ops\, infra`,
+	} {
+		assert.Contains(t, out, "\r\n"+line+"\r\n")
+	}
+	assert.NotContains(t, out, "RRULE")
+}
+
+func TestEncodeSeries(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone data unavailable")
+	}
+	start := time.Date(2026, time.March, 23, 9, 0, 0, 0, berlin)
+	moved := start.AddDate(0, 0, 14)
+	out := encode(t, ical.Calendar{Events: []ical.Event{
+		{
+			UID:      "series@swamp",
+			Start:    start,
+			Duration: 15 * time.Minute,
+			Summary:  "Standup",
+			Location: berlin,
+			Rule:     "FREQ=WEEKLY;COUNT=4",
+			ExDates:  []time.Time{start.AddDate(0, 0, 7)},
+		},
+		{
+			UID:          "series@swamp",
+			Start:        moved.Add(2 * time.Hour),
+			Duration:     15 * time.Minute,
+			Summary:      "Standup",
+			Location:     berlin,
+			RecurrenceID: &moved,
+		},
+	}})
+
+	for _, line := range []string{
+		"DTSTART;TZID=Europe/Berlin:20260323T090000",
+		"RRULE:FREQ=WEEKLY;COUNT=4",
+		"EXDATE;TZID=Europe/Berlin:20260330T090000",
+		"DTSTART;TZID=Europe/Berlin:20260406T110000",
+		"RECURRENCE-ID;TZID=Europe/Berlin:20260406T090000",
+	} {
+		assert.Contains(t, out, "\r\n"+line+"\r\n")
+	}
+	assert.Equal(t, 2, strings.Count(out, "BEGIN:VEVENT"))
+	assert.Equal(t, 1, strings.Count(out, "RRULE:FREQ=WEEKLY"))
+
+	// The TZID is described once, ending in the zone's yearly rules
+	assert.Equal(t, 1, strings.Count(out, "BEGIN:VTIMEZONE"))
+	assert.Less(t, strings.Index(out, "END:VTIMEZONE"), strings.Index(out, "BEGIN:VEVENT"))
+	for _, lines := range []string{
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/Berlin\r\n",
+		"BEGIN:DAYLIGHT\r\nDTSTART:20260329T020000\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\nTZNAME:CEST\r\n",
+		"TZOFFSETFROM:+0200\r\nTZOFFSETTO:+0100\r\nTZNAME:CET\r\nRRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU\r\n",
+		"TZNAME:CEST\r\nRRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU\r\n",
+	} {
+		assert.Contains(t, out, lines)
+	}
+}
+
+func TestTimezones(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	tokyo, err2 := time.LoadLocation("Asia/Tokyo")
+	if err != nil || err2 != nil {
+		t.Skip("time zone data unavailable")
+	}
+	start := time.Date(2026, time.March, 2, 9, 0, 0, 0, time.UTC)
+	out := encode(t, ical.Calendar{Events: []ical.Event{
+		{UID: "ny@swamp", Start: start.In(newYork), Location: newYork, Rule: "FREQ=DAILY"},
+		{UID: "tokyo@swamp", Start: start.In(tokyo), Location: tokyo, Rule: "FREQ=DAILY"},
+		{UID: "utc@swamp", Start: start, Location: time.UTC},
+	}})
+
+	assert.Equal(t, 2, strings.Count(out, "BEGIN:VTIMEZONE"))
+	assert.Contains(t, out, "RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU\r\n")
+	assert.Contains(t, out, "RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU\r\n")
+	// A zone without daylight saving has a single observance
+	tokyoZone := out[strings.Index(out, "TZID:Asia/Tokyo"):]
+	tokyoZone = tokyoZone[:strings.Index(tokyoZone, "END:VTIMEZONE")]
+	assert.Equal(t, 1, strings.Count(tokyoZone, "BEGIN:STANDARD"))
+	assert.NotContains(t, tokyoZone, "DAYLIGHT")
+	assert.Contains(t, tokyoZone, "TZOFFSETTO:+0900\r\nTZNAME:JST\r\n")
+
+	assert.Contains(t, out, "\r\nDTSTART:20260302T090000Z\r\n")
+	assert.NotContains(t, encode(t, ical.Calendar{Events: []ical.Event{{UID: "utc@swamp", Start: start}}}), "VTIMEZONE")
+}
+
+func TestLongLinesAreFolded(t *testing.T) {
+	summary := strings.Repeat("Sümpfe ", 30)
+	out := encode(t, ical.Calendar{Events: []ical.Event{{UID: "long@swamp", Summary: summary}}})
+
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), 75)
+		assert.True(t, strings.ToValidUTF8(line, "?") == line, "fold split a character: %q", line)
+	}
+	unfolded := strings.ReplaceAll(out, "\r\n ", "")
+	assert.Contains(t, unfolded, "\r\nSUMMARY:"+summary+"\r\n")
+}
@@ -0,0 +1,140 @@
+package ical
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxZoneYears bounds how much history a VTIMEZONE covers.
+const maxZoneYears = 100
+
+// transition is a change of UTC offset in a location.
+type transition struct {
+	at         time.Time // instant of the change
+	fromOffset int       // seconds east of UTC before it
+	toOffset   int       // and after it
+	name       string    // abbreviation after it, like CEST
+	dst        bool
+}
+
+// transitions lists the offset changes of loc between from and to. The Go
+// time package keeps them private, so days are stepped through and each
+// change is narrowed down to the second.
+func transitions(loc *time.Location, from, to time.Time) []transition {
+	var found []transition
+	_, offset := from.In(loc).Zone()
+	for day := from; day.Before(to); {
+		next := day.Add(24 * time.Hour)
+		if _, nextOffset := next.In(loc).Zone(); nextOffset != offset {
+			lo, hi := day, next
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if _, midOffset := mid.In(loc).Zone(); midOffset == offset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			name, toOffset := hi.In(loc).Zone()
+			found = append(found, transition{at: hi, fromOffset: offset, toOffset: toOffset, name: name, dst: hi.In(loc).IsDST()})
+			offset = toOffset
+		}
+		day = next
+	}
+	return found
+}
+
+// yearlyRule returns the RRULE that puts t's onset in every later year, like
+// FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU, if next is where it puts the following
+// one.
+func yearlyRule(t, next transition) (string, bool) {
+	local := t.at.Add(time.Duration(t.fromOffset) * time.Second).UTC()
+	nextLocal := next.at.Add(time.Duration(next.fromOffset) * time.Second).UTC()
+	if nextLocal.Year() != local.Year()+1 || nextLocal.Month() != local.Month() ||
+		nextLocal.Weekday() != local.Weekday() || nextLocal.Format("150405") != local.Format("150405") {
+		return "", false
+	}
+
+	day := weekdayNames[local.Weekday()]
+	if lastWeek(local) && lastWeek(nextLocal) {
+		return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=-1%s", local.Month(), day), true
+	}
+	if week := (local.Day()-1)/7 + 1; week == (nextLocal.Day()-1)/7+1 {
+		return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", local.Month(), week, day), true
+	}
+	return "", false
+}
+
+var weekdayNames = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// lastWeek reports whether t falls in the last seven days of its month.
+func lastWeek(t time.Time) bool {
+	return t.AddDate(0, 0, 7).Month() != t.Month()
+}
+
+// vtimezone writes the VTIMEZONE that TZID=loc refers to, covering from to
+// to. Once the zone settles into yearly daylight saving rules, the last two
+// onsets carry them as RRULEs so later years keep working; other changes
+// are listed one by one.
+func vtimezone(line func(name, value string), loc *time.Location, from, to time.Time) {
+	if earliest := to.AddDate(-maxZoneYears, 0, 0); from.Before(earliest) {
+		from = earliest
+	}
+	changes := transitions(loc, from, to)
+	tail := 0
+	var rules [2]string
+	if n := len(changes); n >= 4 {
+		first, okFirst := yearlyRule(changes[n-4], changes[n-2])
+		second, okSecond := yearlyRule(changes[n-3], changes[n-1])
+		if okFirst && okSecond {
+			tail, rules = 4, [2]string{first, second}
+		}
+	}
+
+	line("BEGIN", "VTIMEZONE")
+	line("TZID", loc.String())
+	name, offset := from.In(loc).Zone()
+	observance(line, transition{at: from, fromOffset: offset, toOffset: offset, name: name, dst: from.In(loc).IsDST()}, "")
+	for _, change := range changes[:len(changes)-tail] {
+		observance(line, change, "")
+	}
+	if tail > 0 {
+		observance(line, changes[len(changes)-4], rules[0])
+		observance(line, changes[len(changes)-3], rules[1])
+	}
+	line("END", "VTIMEZONE")
+}
+
+// observance writes the STANDARD or DAYLIGHT component starting at t, whose
+// DTSTART is the local time just before the change.
+func observance(line func(name, value string), t transition, rule string) {
+	kind := "STANDARD"
+	if t.dst {
+		kind = "DAYLIGHT"
+	}
+	line("BEGIN", kind)
+	line("DTSTART", t.at.Add(time.Duration(t.fromOffset)*time.Second).UTC().Format("20060102T150405"))
+	line("TZOFFSETFROM", utcOffset(t.fromOffset))
+	line("TZOFFSETTO", utcOffset(t.toOffset))
+	if t.name != "" && !strings.ContainsAny(t.name, "+-") {
+		line("TZNAME", escape(t.name))
+	}
+	if rule != "" {
+		line("RRULE", rule)
+	}
+	line("END", kind)
+}
+
+// utcOffset formats seconds east of UTC as +hhmm, or +hhmmss when needed.
+func utcOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	value := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		value += fmt.Sprintf("%02d", seconds%60)
+	}
+	return value
+}
@@ -0,0 +1,155 @@
+// Package lifecycle moves swamps through their states as StartTime and
+// Duration come and go, moves recurring swamps on to their next occurrence
+// and tears rooms down once a swamp ends.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"swamp/models"
+	"swamp/pkg/webrtc"
+
+	"gorm.io/gorm"
+)
+
+// Interval is how often Run checks for swamps to advance.
+const Interval = 15 * time.Second
+
+// EndedEvent is sent to everyone still in a room when its swamp ends.
+const EndedEvent = "swamp_ended"
+
+var ErrInvalidTransition = errors.New("invalid swamp status transition")
+
+var activeStatuses = []string{models.SwampStatusScheduled, models.SwampStatusLobby, models.SwampStatusLive}
+
+// Transition moves swamp to status. The update only applies if the stored
+// status is still the one swamp was loaded with, so a concurrent cancel is
+// never overwritten; it reports whether the row changed.
+func Transition(db *gorm.DB, swamp *models.Swamp, status string) (bool, error) {
+	if swamp.Status == status {
+		return false, nil
+	}
+	if !models.CanTransition(swamp.Status, status) {
+		return false, ErrInvalidTransition
+	}
+
+	result := db.Model(&models.Swamp{}).
+		Where("id = ? AND status = ?", swamp.ID, swamp.Status).
+		Update("status", status)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	swamp.Status = status
+	return true, nil
+}
+
+// Advance moves every active swamp to the status its times call for at now
+// and closes the rooms of those that ended. It returns how many changed.
+func Advance(db *gorm.DB, now time.Time) (int, error) {
+	var swamps []models.Swamp
+	err := db.Preload("Recurrence").Where("deleted = ? AND status IN ? AND start_time <= ?", false, activeStatuses, now.Add(models.SwampLobbyWindow)).
+		Find(&swamps).Error
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for i := range swamps {
+		swamp := &swamps[i]
+		status := swamp.StatusAt(now)
+		if status == models.SwampStatusEnded && swamp.Recurrence != nil {
+			// Only the occurrence is over; the series may go on
+			ok, err := NextOccurrence(db, swamp, now)
+			if err != nil {
+				log.Printf("swamp %d: %v", swamp.ID, err)
+			} else if ok {
+				changed++
+			}
+			continue
+		}
+		ok, err := Transition(db, swamp, status)
+		if err != nil {
+			log.Printf("swamp %d: %v", swamp.ID, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		changed++
+		if swamp.Status == models.SwampStatusEnded {
+			webrtc.CloseRoom(swamp.UUID, EndedEvent, "This swamp has ended")
+		}
+	}
+	return changed, nil
+}
+
+// NextOccurrence points a recurring swamp at its current or next
+// occurrence: StartTime and Duration are taken from it and the status
+// starts over from what that occurrence calls for at now. A live room the
+// swamp no longer points at is closed, and once the series is over the
+// swamp ends. Like Transition it never overwrites a concurrent change, and
+// it reports whether the row changed. One-off and finished swamps are left
+// alone.
+func NextOccurrence(db *gorm.DB, swamp *models.Swamp, now time.Time) (bool, error) {
+	if swamp.Finished() {
+		return false, nil
+	}
+	var series models.SwampRecurrence
+	if err := db.Where("swamp_id = ?", swamp.ID).First(&series).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	var overrides []models.SwampOccurrence
+	if err := db.Where("swamp_id = ?", swamp.ID).Find(&overrides).Error; err != nil {
+		return false, err
+	}
+
+	occurrence, ok := series.Next(now, overrides)
+	if !ok {
+		ended, err := Transition(db, swamp, models.SwampStatusEnded)
+		if ended {
+			webrtc.CloseRoom(swamp.UUID, EndedEvent, "This swamp has ended")
+		}
+		return ended, err
+	}
+
+	next := *swamp
+	next.StartTime, next.Duration = occurrence.StartTime, occurrence.Duration
+	next.Status = next.StatusAt(now)
+	if next.StartTime.Equal(swamp.StartTime) && next.Duration == swamp.Duration && next.Status == swamp.Status {
+		return false, nil
+	}
+
+	result := db.Model(&models.Swamp{}).
+		Where("id = ? AND status = ?", swamp.ID, swamp.Status).
+		Updates(map[string]interface{}{"start_time": next.StartTime, "duration": next.Duration, "status": next.Status})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	if swamp.Status == models.SwampStatusLive && !next.StartTime.Equal(swamp.StartTime) {
+		webrtc.CloseRoom(swamp.UUID, EndedEvent, "This occurrence has ended")
+	}
+	swamp.StartTime, swamp.Duration, swamp.Status = next.StartTime, next.Duration, next.Status
+	return true, nil
+}
+
+// Run calls Advance every Interval until ctx is done.
+func Run(ctx context.Context, db *gorm.DB) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := Advance(db, time.Now()); err != nil {
+			log.Printf("swamp scheduler: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
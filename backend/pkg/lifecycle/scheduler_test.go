@@ -0,0 +1,159 @@
+package lifecycle_test
+
+import (
+	"testing"
+	"time"
+
+	"swamp/models"
+	"swamp/pkg/lifecycle"
+	"swamp/pkg/webrtc"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		allowed  bool
+	}{
+		{models.SwampStatusScheduled, models.SwampStatusLobby, true},
+		{models.SwampStatusScheduled, models.SwampStatusEnded, true},
+		{models.SwampStatusLobby, models.SwampStatusScheduled, true},
+		{models.SwampStatusLive, models.SwampStatusLobby, false},
+		{models.SwampStatusLive, models.SwampStatusCancelled, true},
+		{models.SwampStatusEnded, models.SwampStatusCancelled, false},
+		{models.SwampStatusCancelled, models.SwampStatusLive, false},
+		{models.SwampStatusScheduled, "paused", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, models.CanTransition(tt.from, tt.to))
+		})
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Swamp{}, &models.SwampRecurrence{}, &models.SwampOccurrence{}))
+
+	now := time.Now()
+	newSwamp := func(uuid string, start time.Time, status string) models.Swamp {
+		swamp := models.Swamp{UUID: uuid, Title: uuid, StartTime: start, Duration: 30, Status: status, TopicID: 1}
+		assert.NoError(t, db.Create(&swamp).Error)
+		return swamp
+	}
+	later := newSwamp("later", now.Add(time.Hour), models.SwampStatusScheduled)
+	soon := newSwamp("soon", now.Add(5*time.Minute), models.SwampStatusScheduled)
+	started := newSwamp("started", now.Add(-time.Minute), models.SwampStatusLobby)
+	over := newSwamp("over", now.Add(-time.Hour), models.SwampStatusLive)
+	missed := newSwamp("missed", now.Add(-time.Hour), models.SwampStatusScheduled)
+	cancelled := newSwamp("cancelled", now.Add(-time.Minute), models.SwampStatusCancelled)
+
+	room := &webrtc.Room{UUID: over.UUID, Peers: &webrtc.Peers{}}
+	webrtc.RoomsLock.Lock()
+	webrtc.Rooms = map[string]*webrtc.Room{over.UUID: room}
+	webrtc.Streams = map[string]*webrtc.Room{"over-stream": room}
+	webrtc.RoomsLock.Unlock()
+
+	changed, err := lifecycle.Advance(db, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, changed)
+
+	expected := map[uint]string{
+		uint(later.ID):     models.SwampStatusScheduled,
+		uint(soon.ID):      models.SwampStatusLobby,
+		uint(started.ID):   models.SwampStatusLive,
+		uint(over.ID):      models.SwampStatusEnded,
+		uint(missed.ID):    models.SwampStatusEnded,
+		uint(cancelled.ID): models.SwampStatusCancelled,
+	}
+	for id, status := range expected {
+		var swamp models.Swamp
+		db.First(&swamp, id)
+		assert.Equal(t, status, swamp.Status, swamp.UUID)
+	}
+
+	assert.True(t, room.Peers.IsClosed())
+	webrtc.RoomsLock.RLock()
+	assert.Empty(t, webrtc.Rooms)
+	assert.Empty(t, webrtc.Streams)
+	webrtc.RoomsLock.RUnlock()
+
+	changed, err = lifecycle.Advance(db, now)
+	assert.NoError(t, err)
+	assert.Zero(t, changed)
+}
+
+func TestTransitionDoesNotOverwriteConcurrentChange(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Swamp{}))
+
+	swamp := models.Swamp{UUID: "raced", StartTime: time.Now(), Duration: 30, Status: models.SwampStatusLobby, TopicID: 1}
+	db.Create(&swamp)
+	db.Model(&models.Swamp{}).Where("id = ?", swamp.ID).Update("status", models.SwampStatusCancelled)
+
+	changed, err := lifecycle.Transition(db, &swamp, models.SwampStatusLive)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = lifecycle.Transition(db, &models.Swamp{Status: models.SwampStatusEnded}, models.SwampStatusLive)
+	assert.ErrorIs(t, err, lifecycle.ErrInvalidTransition)
+}
+
+func TestAdvanceRecurringSwamps(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Swamp{}, &models.SwampRecurrence{}, &models.SwampOccurrence{}))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	// Daily series whose second occurrence ended half an hour ago
+	first := now.Add(-25 * time.Hour)
+	newSeries := func(uuid, rule string) models.Swamp {
+		swamp := models.Swamp{UUID: uuid, Title: uuid, StartTime: first, Duration: 30, Status: models.SwampStatusLive, TopicID: 1}
+		assert.NoError(t, db.Create(&swamp).Error)
+		assert.NoError(t, db.Create(&models.SwampRecurrence{SwampID: uint(swamp.ID), Rule: rule, Start: first, Duration: 30, Timezone: "UTC"}).Error)
+		return swamp
+	}
+	ongoing := newSeries("ongoing", "FREQ=DAILY")
+	finished := newSeries("finished", "FREQ=DAILY;COUNT=2")
+	moved := newSeries("moved", "FREQ=DAILY")
+
+	// The second occurrence of "moved" was pushed back into the lobby window
+	movedTo := now.Add(5 * time.Minute)
+	assert.NoError(t, db.Create(&models.SwampOccurrence{SwampID: uint(moved.ID), OriginalStart: first.Add(24 * time.Hour), StartTime: &movedTo}).Error)
+
+	room := &webrtc.Room{UUID: ongoing.UUID, Peers: &webrtc.Peers{}}
+	webrtc.RoomsLock.Lock()
+	webrtc.Rooms = map[string]*webrtc.Room{ongoing.UUID: room}
+	webrtc.Streams = map[string]*webrtc.Room{}
+	webrtc.RoomsLock.Unlock()
+
+	changed, err := lifecycle.Advance(db, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, changed)
+
+	load := func(id int) models.Swamp {
+		var swamp models.Swamp
+		db.First(&swamp, id)
+		return swamp
+	}
+	swamp := load(ongoing.ID)
+	assert.Equal(t, models.SwampStatusScheduled, swamp.Status)
+	assert.True(t, swamp.StartTime.Equal(first.Add(48*time.Hour)), swamp.StartTime)
+	assert.True(t, room.Peers.IsClosed())
+
+	assert.Equal(t, models.SwampStatusEnded, load(finished.ID).Status)
+
+	swamp = load(moved.ID)
+	assert.Equal(t, models.SwampStatusLobby, swamp.Status)
+	assert.True(t, swamp.StartTime.Equal(movedTo), swamp.StartTime)
+
+	changed, err = lifecycle.Advance(db, now)
+	assert.NoError(t, err)
+	assert.Zero(t, changed)
+}
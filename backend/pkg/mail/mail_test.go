@@ -0,0 +1,68 @@
+package mail_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"swamp/pkg/mail"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOTPMessage(t *testing.T) {
+	msg, err := mail.OTPMessage("user@example.com", "123456", 5*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "user@example.com", msg.To)
+	assert.Contains(t, msg.Text, "123456")
+	assert.Contains(t, msg.Text, "5 minutes")
+	assert.Contains(t, msg.HTML, "123456")
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := mail.NewMemoryMailer()
+	assert.NoError(t, m.Send(mail.Message{To: "a@example.com", Subject: "first"}))
+	assert.NoError(t, m.Send(mail.Message{To: "b@example.com", Subject: "other"}))
+	assert.NoError(t, m.Send(mail.Message{To: "a@example.com", Subject: "second"}))
+
+	assert.Len(t, m.Messages(), 3)
+	last, ok := m.Last("a@example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "second", last.Subject)
+
+	_, ok = m.Last("nobody@example.com")
+	assert.False(t, ok)
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m, err := mail.NewFileMailer(dir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.Send(mail.Message{To: "a@example.com", Subject: "hello", Text: "code 42"}))
+	assert.NoError(t, m.Send(mail.Message{To: "b@example.com", Subject: "again"}))
+
+	raw, err := os.ReadFile(filepath.Join(dir, "outbox.jsonl"))
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	assert.Len(t, lines, 2)
+
+	var first mail.Message
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, "a@example.com", first.To)
+	assert.Equal(t, "code 42", first.Text)
+}
+
+func TestNewSMTPMailerValidation(t *testing.T) {
+	_, err := mail.NewSMTPMailer(mail.SMTPConfig{})
+	assert.Error(t, err)
+
+	_, err = mail.NewSMTPMailer(mail.SMTPConfig{Host: "smtp.example.com", From: "no-reply@example.com", TLS: "bogus"})
+	assert.Error(t, err)
+
+	_, err = mail.NewSMTPMailer(mail.SMTPConfig{Host: "smtp.example.com", From: "The Swamp <no-reply@example.com>"})
+	assert.NoError(t, err)
+}
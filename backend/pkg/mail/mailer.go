@@ -0,0 +1,70 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Message is a single outgoing email with text and HTML bodies.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// Mailer delivers messages. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// FromEnv builds the mailer selected by MAIL_DRIVER:
+//
+//	smtp   - deliver through SMTP_HOST/SMTP_PORT (see SMTPConfig)
+//	file   - append messages to MAIL_CAPTURE_DIR (default "mail-outbox")
+//	memory - keep messages in process memory
+//
+// Outside production the default is "file" so OTP codes can be read locally.
+func FromEnv() (Mailer, error) {
+	driver := os.Getenv("MAIL_DRIVER")
+	if driver == "" {
+		if os.Getenv("ENVIRONMENT") == "PRODUCTION" {
+			driver = "smtp"
+		} else {
+			driver = "file"
+		}
+	}
+
+	switch driver {
+	case "smtp":
+		port, err := strconv.Atoi(getenv("SMTP_PORT", "587"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+		}
+		return NewSMTPMailer(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     getenv("SMTP_FROM", "The Swamp <no-reply@swamp.local>"),
+			TLS:      TLSMode(getenv("SMTP_TLS", string(TLSStartTLS))),
+		})
+	case "file":
+		dir := getenv("MAIL_CAPTURE_DIR", "mail-outbox")
+		log.Printf("mail: capturing outgoing mail in %s", dir)
+		return NewFileMailer(dir)
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,60 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates/*
+var templateFS embed.FS
+
+var (
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+)
+
+// OTPData is passed to the otp.txt and otp.html templates.
+type OTPData struct {
+	Code    string
+	Minutes int
+}
+
+// OTPMessage renders the one-time-code email for the given recipient.
+func OTPMessage(to, code string, ttl time.Duration) (Message, error) {
+	return render(to, "Your Swamp verification code", "otp", OTPData{
+		Code:    code,
+		Minutes: int(ttl.Minutes()),
+	})
+}
+
+// PasswordResetMessage renders the password reset code email.
+func PasswordResetMessage(to, code string, ttl time.Duration) (Message, error) {
+	return render(to, "Reset your Swamp password", "password_reset", OTPData{
+		Code:    code,
+		Minutes: int(ttl.Minutes()),
+	})
+}
+
+// EmailChangeMessage renders the code sent to a new address to confirm an
+// email change.
+func EmailChangeMessage(to, code string, ttl time.Duration) (Message, error) {
+	return render(to, "Confirm your new Swamp email address", "email_change", OTPData{
+		Code:    code,
+		Minutes: int(ttl.Minutes()),
+	})
+}
+
+// render executes name.txt and name.html with the same data.
+func render(to, subject, name string, data interface{}) (Message, error) {
+	var text, html bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return Message{}, err
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return Message{}, err
+	}
+	return Message{To: to, Subject: subject, Text: text.String(), HTML: html.String()}, nil
+}
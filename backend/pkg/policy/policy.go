@@ -0,0 +1,131 @@
+// Package policy decides who may do what. It is shared by the Chi
+// controllers and the Fiber socket handlers so both apply the same rules.
+package policy
+
+import (
+	"swamp/models"
+
+	"gorm.io/gorm"
+)
+
+// Action is something a caller wants to do.
+type Action string
+
+const (
+	CreateTopic   Action = "topic:create"
+	ModerateTopic Action = "topic:moderate" // rename or retire topics
+	ManageRoles   Action = "user:roles"     // change site-wide roles
+
+	ViewSwamp     Action = "swamp:view"     // see a swamp and enter its room
+	FindSwamp     Action = "swamp:find"     // look a swamp up by its sequential ID rather than its link
+	ManageSwamp   Action = "swamp:manage"   // edit or cancel a swamp, invite people
+	ManageCoHosts Action = "swamp:co-hosts" // add or remove co-hosts
+	ModerateSwamp Action = "swamp:moderate" // remove participants from a live swamp
+)
+
+// Subject is the caller a decision is made for.
+type Subject struct {
+	UserID uint
+	Role   string
+}
+
+// SubjectOf returns the policy subject for a loaded user.
+func SubjectOf(user *models.User) Subject {
+	if user == nil {
+		return Subject{}
+	}
+	return Subject{UserID: user.ID, Role: user.Role}
+}
+
+// Can reports whether subject may perform action. Swamp-scoped actions need
+// the swamp; site-wide ones ignore it. Admins may do everything.
+func Can(db *gorm.DB, subject Subject, action Action, swamp *models.Swamp) bool {
+	if subject.UserID == 0 {
+		return false
+	}
+	if subject.Role == models.RoleAdmin {
+		return true
+	}
+
+	switch action {
+	case CreateTopic, ModerateTopic:
+		return models.RoleAtLeast(subject.Role, models.RoleModerator)
+	case ManageRoles:
+		return false
+	}
+
+	if swamp == nil {
+		return false
+	}
+
+	switch action {
+	case ViewSwamp:
+		if swamp.Visibility != models.SwampVisibilityPrivate || models.RoleAtLeast(subject.Role, models.RoleModerator) {
+			return true
+		}
+		return SwampRole(db, subject.UserID, swamp) != "" || Invited(db, subject.UserID, swamp)
+	case FindSwamp:
+		// IDs can be walked, so unlisted swamps are only found that way by
+		// people already involved with them
+		if swamp.Visibility != models.SwampVisibilityUnlisted {
+			return Can(db, subject, ViewSwamp, swamp)
+		}
+		if models.RoleAtLeast(subject.Role, models.RoleModerator) {
+			return true
+		}
+		return SwampRole(db, subject.UserID, swamp) != "" || Invited(db, subject.UserID, swamp)
+	case ManageSwamp, ManageCoHosts:
+		return SwampRole(db, subject.UserID, swamp) == models.SwampRoleOwner
+	case ModerateSwamp:
+		if models.RoleAtLeast(subject.Role, models.RoleModerator) {
+			return true
+		}
+		return SwampRole(db, subject.UserID, swamp) != ""
+	}
+	return false
+}
+
+// SwampRole returns the user's role in the swamp, or "" if they have none.
+func SwampRole(db *gorm.DB, userID uint, swamp *models.Swamp) string {
+	if userID == 0 || swamp == nil {
+		return ""
+	}
+	if uint(swamp.OwnerID) == userID {
+		return models.SwampRoleOwner
+	}
+
+	var member models.SwampMember
+	if err := db.Where("swamp_id = ? AND user_id = ?", swamp.ID, userID).First(&member).Error; err != nil {
+		return ""
+	}
+	return member.Role
+}
+
+// Invited reports whether the user holds an invitation to the swamp, either
+// to their account or to their email address.
+func Invited(db *gorm.DB, userID uint, swamp *models.Swamp) bool {
+	if userID == 0 || swamp == nil {
+		return false
+	}
+
+	var count int64
+	db.Model(&models.SwampInvitation{}).
+		Where("swamp_id = ? AND (user_id = ? OR email = (SELECT LOWER(email) FROM users WHERE id = ?))", swamp.ID, userID, userID).
+		Count(&count)
+	return count > 0
+}
+
+// VisibleSwamps scopes a swamp query to what subject should find in
+// listings: public swamps plus any they host or were invited to. Unlisted
+// swamps stay out of everyone else's listings even though they can be
+// opened directly.
+func VisibleSwamps(subject Subject) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if subject.UserID == 0 {
+			return db.Where("visibility = ?", models.SwampVisibilityPublic)
+		}
+		return db.Where("visibility = ? OR owner_id = ? OR id IN (SELECT swamp_id FROM swamp_members WHERE user_id = ?) OR "+
+			"id IN (SELECT swamp_id FROM swamp_invitations WHERE user_id = ? OR email = (SELECT LOWER(email) FROM users WHERE id = ?))",
+			models.SwampVisibilityPublic, subject.UserID, subject.UserID, subject.UserID, subject.UserID)
+	}
+}
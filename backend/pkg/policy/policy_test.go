@@ -0,0 +1,75 @@
+package policy_test
+
+import (
+	"testing"
+
+	"swamp/models"
+	"swamp/pkg/policy"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestCan(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.SwampMember{}, &models.SwampInvitation{}, &models.User{}))
+
+	swamp := &models.Swamp{ID: 7, OwnerID: 1}
+	db.Create(&models.SwampMember{SwampID: 7, UserID: 2, Role: models.SwampRoleCoHost})
+	private := &models.Swamp{ID: 7, OwnerID: 1, Visibility: models.SwampVisibilityPrivate}
+	unlisted := &models.Swamp{ID: 8, OwnerID: 1, Visibility: models.SwampVisibilityUnlisted}
+	guestID, emailGuestID := uint(6), uint(7)
+	db.Create(&models.User{Model: gorm.Model{ID: emailGuestID}, Email: "Guest@Example.com"})
+	db.Create(&models.SwampInvitation{SwampID: 7, UserID: &guestID, InvitedBy: 1})
+	db.Create(&models.SwampInvitation{SwampID: 7, Email: "guest@example.com", InvitedBy: 1})
+
+	owner := policy.Subject{UserID: 1, Role: models.RoleUser}
+	coHost := policy.Subject{UserID: 2, Role: models.RoleUser}
+	stranger := policy.Subject{UserID: 3, Role: models.RoleUser}
+	moderator := policy.Subject{UserID: 4, Role: models.RoleModerator}
+	admin := policy.Subject{UserID: 5, Role: models.RoleAdmin}
+	guest := policy.Subject{UserID: guestID, Role: models.RoleUser}
+	emailGuest := policy.Subject{UserID: emailGuestID, Role: models.RoleUser}
+
+	tests := []struct {
+		name    string
+		subject policy.Subject
+		action  policy.Action
+		swamp   *models.Swamp
+		allowed bool
+	}{
+		{name: "user cannot create topics", subject: stranger, action: policy.CreateTopic},
+		{name: "moderator creates topics", subject: moderator, action: policy.CreateTopic, allowed: true},
+		{name: "moderator cannot change roles", subject: moderator, action: policy.ManageRoles},
+		{name: "admin changes roles", subject: admin, action: policy.ManageRoles, allowed: true},
+		{name: "anonymous is refused", subject: policy.Subject{}, action: policy.ModerateSwamp, swamp: swamp},
+		{name: "owner manages swamp", subject: owner, action: policy.ManageSwamp, swamp: swamp, allowed: true},
+		{name: "co-host cannot manage swamp", subject: coHost, action: policy.ManageSwamp, swamp: swamp},
+		{name: "co-host cannot appoint co-hosts", subject: coHost, action: policy.ManageCoHosts, swamp: swamp},
+		{name: "co-host moderates", subject: coHost, action: policy.ModerateSwamp, swamp: swamp, allowed: true},
+		{name: "site moderator moderates", subject: moderator, action: policy.ModerateSwamp, swamp: swamp, allowed: true},
+		{name: "stranger cannot moderate", subject: stranger, action: policy.ModerateSwamp, swamp: swamp},
+		{name: "swamp actions need a swamp", subject: owner, action: policy.ManageSwamp},
+		{name: "admin manages any swamp", subject: admin, action: policy.ManageSwamp, swamp: swamp, allowed: true},
+		{name: "anyone views a public swamp", subject: stranger, action: policy.ViewSwamp, swamp: swamp, allowed: true},
+		{name: "anyone views an unlisted swamp", subject: stranger, action: policy.ViewSwamp, swamp: unlisted, allowed: true},
+		{name: "anyone finds a public swamp", subject: stranger, action: policy.FindSwamp, swamp: swamp, allowed: true},
+		{name: "stranger cannot find an unlisted swamp", subject: stranger, action: policy.FindSwamp, swamp: unlisted},
+		{name: "owner finds an unlisted swamp", subject: owner, action: policy.FindSwamp, swamp: unlisted, allowed: true},
+		{name: "moderator finds an unlisted swamp", subject: moderator, action: policy.FindSwamp, swamp: unlisted, allowed: true},
+		{name: "invited user finds a private swamp", subject: guest, action: policy.FindSwamp, swamp: private, allowed: true},
+		{name: "stranger cannot view a private swamp", subject: stranger, action: policy.ViewSwamp, swamp: private},
+		{name: "co-host views a private swamp", subject: coHost, action: policy.ViewSwamp, swamp: private, allowed: true},
+		{name: "invited user views a private swamp", subject: guest, action: policy.ViewSwamp, swamp: private, allowed: true},
+		{name: "invited email views a private swamp", subject: emailGuest, action: policy.ViewSwamp, swamp: private, allowed: true},
+		{name: "site moderator views a private swamp", subject: moderator, action: policy.ViewSwamp, swamp: private, allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, policy.Can(db, tt.subject, tt.action, tt.swamp))
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter allows at most Max events per key within a sliding Window. It is kept
+// in memory, so limits are per process.
+type Limiter struct {
+	mu     sync.Mutex
+	max    int
+	window time.Duration
+	events map[string][]time.Time
+}
+
+func New(max int, window time.Duration) *Limiter {
+	return &Limiter{
+		max:    max,
+		window: window,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// Allow records an event for key if it is within the limit. When the limit is
+// exhausted it returns false and how long until the next event is allowed.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	recent := l.prune(key, now)
+	if len(recent) >= l.max {
+		return false, recent[0].Add(l.window).Sub(now)
+	}
+
+	l.events[key] = append(recent, now)
+	return true, 0
+}
+
+// Blocked reports whether key has exhausted its limit without recording an event.
+func (l *Limiter) Blocked(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	recent := l.prune(key, now)
+	if len(recent) >= l.max {
+		return true, recent[0].Add(l.window).Sub(now)
+	}
+	return false, 0
+}
+
+// Release forgets the latest event for key, for attempts counted up front
+// that turned out not to count against the limit.
+func (l *Limiter) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if recent := l.prune(key, time.Now()); len(recent) > 0 {
+		l.events[key] = recent[:len(recent)-1]
+	}
+}
+
+// Reset forgets all events for key, e.g. after a successful attempt.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.events, key)
+}
+
+// prune drops events older than the window; callers must hold mu.
+func (l *Limiter) prune(key string, now time.Time) []time.Time {
+	events := l.events[key]
+	cutoff := now.Add(-l.window)
+	i := 0
+	for i < len(events) && !events[i].After(cutoff) {
+		i++
+	}
+	events = events[i:]
+	if len(events) == 0 {
+		delete(l.events, key)
+		return nil
+	}
+	l.events[key] = events
+	return events
+}
@@ -0,0 +1,331 @@
+// Package recurrence parses and expands the subset of RFC 5545 recurrence
+// rules swamps support: FREQ=DAILY, WEEKLY or MONTHLY with INTERVAL, COUNT
+// or UNTIL, BYDAY for weekly rules and BYMONTHDAY for monthly ones.
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ of a rule.
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// maxEmptyPeriods stops expansion of rules that can never match again,
+// such as BYMONTHDAY=30 every twelve months starting in February.
+const maxEmptyPeriods = 1000
+
+// MaxPeriods caps how many days, weeks or months after its start a series
+// is expanded, so no request can make it iterate for long: about 55 years
+// of a daily rule.
+const MaxPeriods = 20000
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+var weekdayNames = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// Rule is a parsed RRULE. Occurrences keep the wall-clock time of the
+// series start in its location, so a 09:00 standup stays at 09:00 across
+// daylight saving changes.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int        // 0 when unbounded or bounded by Until
+	Until      *time.Time // inclusive
+	ByDay      []time.Weekday
+	ByMonthDay []int // negative days count from the end of the month
+}
+
+// Parse reads an RRULE value like "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10",
+// with or without the "RRULE:" prefix. UNTIL takes a UTC date-time
+// (20261231T090000Z) or a date, which includes the whole UTC day. Errors
+// wrap ErrInvalidRule and never repeat the input.
+func Parse(value string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	value = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "RRULE:")
+	if value == "" {
+		return rule, fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	}
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !ok || val == "" {
+			return rule, fmt.Errorf("%w: parts must be KEY=VALUE pairs separated by semicolons", ErrInvalidRule)
+		}
+		if seen[key] {
+			return rule, fmt.Errorf("%w: %s given twice", ErrInvalidRule, key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "FREQ":
+			switch freq := Frequency(val); freq {
+			case Daily, Weekly, Monthly:
+				rule.Freq = freq
+			default:
+				return rule, fmt.Errorf("%w: FREQ must be DAILY, WEEKLY or MONTHLY", ErrInvalidRule)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("%w: INTERVAL must be a positive number", ErrInvalidRule)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("%w: COUNT must be a positive number", ErrInvalidRule)
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, err := parseUntil(val)
+			if err != nil {
+				return rule, fmt.Errorf("%w: UNTIL must look like 20261231T090000Z or 20261231", ErrInvalidRule)
+			}
+			rule.Until = &until
+		case "BYDAY":
+			for _, name := range strings.Split(val, ",") {
+				day, ok := weekdays[name]
+				if !ok {
+					return rule, fmt.Errorf("%w: BYDAY takes MO, TU, WE, TH, FR, SA or SU", ErrInvalidRule)
+				}
+				rule.ByDay = append(rule.ByDay, day)
+			}
+		case "BYMONTHDAY":
+			for _, raw := range strings.Split(val, ",") {
+				day, err := strconv.Atoi(raw)
+				if err != nil || day == 0 || day < -31 || day > 31 {
+					return rule, fmt.Errorf("%w: BYMONTHDAY takes days from 1 to 31 or -1 to -31", ErrInvalidRule)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, day)
+			}
+		case "WKST":
+			if val != "MO" {
+				return rule, fmt.Errorf("%w: only WKST=MO is supported", ErrInvalidRule)
+			}
+		default:
+			return rule, fmt.Errorf("%w: only FREQ, INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY and WKST are supported", ErrInvalidRule)
+		}
+	}
+
+	switch {
+	case rule.Freq == "":
+		return rule, fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	case rule.Count != 0 && rule.Until != nil:
+		return rule, fmt.Errorf("%w: COUNT and UNTIL cannot be combined", ErrInvalidRule)
+	case len(rule.ByDay) > 0 && rule.Freq != Weekly:
+		return rule, fmt.Errorf("%w: BYDAY is only supported with FREQ=WEEKLY", ErrInvalidRule)
+	case len(rule.ByMonthDay) > 0 && rule.Freq != Monthly:
+		return rule, fmt.Errorf("%w: BYMONTHDAY is only supported with FREQ=MONTHLY", ErrInvalidRule)
+	}
+	return rule, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	if until, err := time.Parse("20060102T150405Z", value); err == nil {
+		return until, nil
+	}
+	day, err := time.Parse("20060102", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.Add(24*time.Hour - time.Second), nil
+}
+
+// String returns the rule in canonical RRULE form, without the prefix.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		names := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			names[i] = weekdayNames[day]
+		}
+		parts = append(parts, "BYDAY="+strings.Join(names, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, day := range r.ByMonthDay {
+			days[i] = strconv.Itoa(day)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Each calls fn with the start of every occurrence of a series starting at
+// dtstart, in order, until fn returns false or the rule runs out. Starts
+// are in dtstart's location and stop after MaxPeriods periods. Periods
+// before dtstart produce nothing, so a dtstart the rule does not match is
+// not an occurrence itself; callers keep the two in step with Matches.
+func (r Rule) Each(dtstart time.Time, fn func(time.Time) bool) {
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+	emitted, empty := 0, 0
+	for period := 0; empty < maxEmptyPeriods && period*interval < MaxPeriods; period++ {
+		starts := r.period(dtstart, period*interval)
+		if len(starts) == 0 {
+			empty++
+			continue
+		}
+		empty = 0
+		for _, start := range starts {
+			if start.Before(dtstart) {
+				continue
+			}
+			if r.Until != nil && start.After(*r.Until) {
+				return
+			}
+			if !fn(start) {
+				return
+			}
+			emitted++
+			if r.Count > 0 && emitted >= r.Count {
+				return
+			}
+		}
+	}
+}
+
+// Between returns the occurrence starts in [from, to).
+func (r Rule) Between(dtstart, from, to time.Time) []time.Time {
+	var starts []time.Time
+	r.Each(dtstart, func(start time.Time) bool {
+		if !start.Before(to) {
+			return false
+		}
+		if !start.Before(from) {
+			starts = append(starts, start)
+		}
+		return true
+	})
+	return starts
+}
+
+// Includes reports whether t is the start of an occurrence.
+func (r Rule) Includes(dtstart, t time.Time) bool {
+	found := false
+	r.Each(dtstart, func(start time.Time) bool {
+		found = start.Equal(t)
+		return start.Before(t)
+	})
+	return found
+}
+
+// Matches reports whether dtstart is itself an occurrence of the rule, as
+// RFC 5545 expects of the DTSTART of a series.
+func (r Rule) Matches(dtstart time.Time) bool {
+	if r.Until != nil && dtstart.After(*r.Until) {
+		return false
+	}
+	for _, start := range r.period(dtstart, 0) {
+		if start.Equal(dtstart) {
+			return true
+		}
+	}
+	return false
+}
+
+// Last returns the start of the final occurrence of a series starting at
+// dtstart, or false if the rule never ends or never matches.
+func (r Rule) Last(dtstart time.Time) (time.Time, bool) {
+	if r.Count == 0 && r.Until == nil {
+		return time.Time{}, false
+	}
+	var last time.Time
+	found := false
+	r.Each(dtstart, func(start time.Time) bool {
+		last, found = start, true
+		return true
+	})
+	return last, found
+}
+
+// period returns the candidate starts of the offset-th day, week or month
+// after dtstart's, in order.
+func (r Rule) period(dtstart time.Time, offset int) []time.Time {
+	year, month, day := dtstart.Date()
+	hour, min, sec := dtstart.Clock()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hour, min, sec, dtstart.Nanosecond(), dtstart.Location())
+	}
+
+	switch r.Freq {
+	case Daily:
+		return []time.Time{at(year, month, day+offset)}
+	case Weekly:
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{dtstart.Weekday()}
+		}
+		// Weeks start on Monday
+		monday := day - (int(dtstart.Weekday())+6)%7 + 7*offset
+		var starts []time.Time
+		for _, weekday := range days {
+			starts = append(starts, at(year, month, monday+(int(weekday)+6)%7))
+		}
+		sortTimes(starts)
+		return dedupe(starts)
+	case Monthly:
+		days := r.ByMonthDay
+		if len(days) == 0 {
+			days = []int{day}
+		}
+		first := time.Date(year, month+time.Month(offset), 1, 0, 0, 0, 0, dtstart.Location())
+		length := time.Date(first.Year(), first.Month()+1, 0, 0, 0, 0, 0, dtstart.Location()).Day()
+		var starts []time.Time
+		for _, d := range days {
+			if d < 0 {
+				d = length + d + 1
+			}
+			// Months without the day are skipped, as RFC 5545 asks
+			if d >= 1 && d <= length {
+				starts = append(starts, at(first.Year(), first.Month(), d))
+			}
+		}
+		sortTimes(starts)
+		return dedupe(starts)
+	}
+	return nil
+}
+
+func sortTimes(times []time.Time) {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+}
+
+func dedupe(sorted []time.Time) []time.Time {
+	out := sorted[:0]
+	for i, t := range sorted {
+		if i == 0 || !t.Equal(sorted[i-1]) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
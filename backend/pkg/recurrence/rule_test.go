@@ -0,0 +1,147 @@
+package recurrence_test
+
+import (
+	"testing"
+	"time"
+
+	"swamp/pkg/recurrence"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value     string
+		canonical string
+		err       string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY", ""},
+		{"RRULE:freq=weekly;byday=mo,we;interval=2;count=6", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=6", ""},
+		{"FREQ=MONTHLY;BYMONTHDAY=1,-1;UNTIL=20261231", "FREQ=MONTHLY;BYMONTHDAY=1,-1;UNTIL=20261231T235959Z", ""},
+		{"", "", "FREQ is required"},
+		{"INTERVAL=2", "", "FREQ is required"},
+		{"FREQ=YEARLY", "", "FREQ must be DAILY, WEEKLY or MONTHLY"},
+		{"FREQ=DAILY;COUNT=3;UNTIL=20261231", "", "COUNT and UNTIL cannot be combined"},
+		{"FREQ=DAILY;BYDAY=MO", "", "BYDAY is only supported with FREQ=WEEKLY"},
+		{"FREQ=WEEKLY;BYDAY=1MO", "", "BYDAY takes MO, TU, WE, TH, FR, SA or SU"},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", "", "BYMONTHDAY takes days from 1 to 31 or -1 to -31"},
+		{"FREQ=DAILY;BYHOUR=9", "", "only FREQ, INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY and WKST are supported"},
+		{"FREQ=DAILY;FREQ=WEEKLY", "", "FREQ given twice"},
+		{"FREQ=DAILY;INTERVAL=0", "", "INTERVAL must be a positive number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			rule, err := recurrence.Parse(tt.value)
+			if tt.err != "" {
+				assert.ErrorIs(t, err, recurrence.ErrInvalidRule)
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.canonical, rule.String())
+		})
+	}
+}
+
+func TestBetween(t *testing.T) {
+	// Monday 30 March 2026, 09:00 UTC
+	start := time.Date(2026, time.March, 30, 9, 0, 0, 0, time.UTC)
+	day := func(month time.Month, d int) time.Time { return time.Date(2026, month, d, 9, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name     string
+		rule     string
+		from, to time.Time
+		expected []time.Time
+	}{
+		{"daily count", "FREQ=DAILY;COUNT=3", start, day(time.May, 1),
+			[]time.Time{day(time.March, 30), day(time.March, 31), day(time.April, 1)}},
+		{"every other week on two days", "FREQ=WEEKLY;INTERVAL=2;BYDAY=WE,MO", start, day(time.April, 25),
+			[]time.Time{day(time.March, 30), day(time.April, 1), day(time.April, 13), day(time.April, 15)}},
+		{"weekly until is inclusive", "FREQ=WEEKLY;UNTIL=20260413T090000Z", start, day(time.May, 1),
+			[]time.Time{day(time.March, 30), day(time.April, 6), day(time.April, 13)}},
+		{"window skips earlier occurrences", "FREQ=DAILY", day(time.April, 2), day(time.April, 4),
+			[]time.Time{day(time.April, 2), day(time.April, 3)}},
+		{"count includes occurrences before the window", "FREQ=DAILY;COUNT=3", day(time.March, 31), day(time.May, 1),
+			[]time.Time{day(time.March, 31), day(time.April, 1)}},
+		{"start not matching the rule", "FREQ=WEEKLY;BYDAY=FR;COUNT=2", start, day(time.May, 1),
+			[]time.Time{day(time.April, 3), day(time.April, 10)}},
+		{"monthly skips short months", "FREQ=MONTHLY;BYMONTHDAY=31", start, day(time.August, 1),
+			[]time.Time{day(time.March, 31), day(time.May, 31), day(time.July, 31)}},
+		{"monthly from the end", "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3", start, day(time.December, 1),
+			[]time.Time{day(time.March, 31), day(time.April, 30), day(time.May, 31)}},
+		{"monthly on the start day", "FREQ=MONTHLY;INTERVAL=3;COUNT=2", start, day(time.December, 1),
+			[]time.Time{day(time.March, 30), day(time.June, 30)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := recurrence.Parse(tt.rule)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, rule.Between(start, tt.from, tt.to))
+		})
+	}
+}
+
+func TestWallClockAcrossDaylightSaving(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone data unavailable")
+	}
+	rule, _ := recurrence.Parse("FREQ=WEEKLY;COUNT=2")
+	// Clocks go forward on 29 March 2026
+	start := time.Date(2026, time.March, 23, 9, 0, 0, 0, berlin)
+
+	starts := rule.Between(start, start, start.AddDate(0, 1, 0))
+	assert.Len(t, starts, 2)
+	for _, s := range starts {
+		assert.Equal(t, 9, s.Hour())
+	}
+	assert.Equal(t, 7*24*time.Hour-time.Hour, starts[1].Sub(starts[0]))
+}
+
+func TestIncludes(t *testing.T) {
+	start := time.Date(2026, time.March, 30, 9, 0, 0, 0, time.UTC)
+	rule, _ := recurrence.Parse("FREQ=WEEKLY;BYDAY=MO,TH;COUNT=4")
+
+	assert.True(t, rule.Includes(start, start))
+	assert.True(t, rule.Includes(start, time.Date(2026, time.April, 9, 9, 0, 0, 0, time.UTC)))
+	assert.False(t, rule.Includes(start, time.Date(2026, time.April, 9, 10, 0, 0, 0, time.UTC)))
+	assert.False(t, rule.Includes(start, time.Date(2026, time.April, 13, 9, 0, 0, 0, time.UTC)))
+	assert.False(t, rule.Includes(start, start.Add(-7*24*time.Hour)))
+}
+
+func TestImpossibleRuleTerminates(t *testing.T) {
+	rule, _ := recurrence.Parse("FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=30")
+	start := time.Date(2026, time.February, 1, 9, 0, 0, 0, time.UTC)
+	assert.Empty(t, rule.Between(start, start, start.AddDate(100, 0, 0)))
+}
+
+func TestMatchesAndLast(t *testing.T) {
+	start := time.Date(2026, time.March, 30, 9, 0, 0, 0, time.UTC)
+	weekly, _ := recurrence.Parse("FREQ=WEEKLY;BYDAY=MO,TH;COUNT=4")
+	assert.True(t, weekly.Matches(start))
+	assert.False(t, weekly.Matches(start.Add(24*time.Hour)))
+	last, ok := weekly.Last(start)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2026, time.April, 9, 9, 0, 0, 0, time.UTC), last)
+
+	until, _ := recurrence.Parse("FREQ=DAILY;UNTIL=20260402")
+	last, ok = until.Last(start)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2026, time.April, 2, 9, 0, 0, 0, time.UTC), last)
+	assert.False(t, until.Matches(start.AddDate(0, 1, 0)))
+
+	daily, _ := recurrence.Parse("FREQ=DAILY")
+	_, ok = daily.Last(start)
+	assert.False(t, ok)
+}
+
+func TestExpansionIsCapped(t *testing.T) {
+	rule, _ := recurrence.Parse("FREQ=DAILY")
+	start := time.Date(2026, time.March, 30, 9, 0, 0, 0, time.UTC)
+	far := start.AddDate(900, 0, 0)
+	assert.Empty(t, rule.Between(start, far, far.AddDate(1, 0, 0)))
+	assert.Len(t, rule.Between(start, start, far), recurrence.MaxPeriods)
+}
@@ -0,0 +1,53 @@
+package sso_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"swamp/pkg/sso"
+	"swamp/pkg/sso/ssotest"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func TestExchange(t *testing.T) {
+	issuer := ssotest.NewIssuer("client", "secret")
+	defer issuer.Close()
+
+	provider, err := sso.NewProvider(context.Background(), sso.ProviderConfig{
+		Name:         "mock",
+		Issuer:       issuer.URL(),
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/callback",
+	})
+	assert.NoError(t, err)
+
+	verifier := oauth2.GenerateVerifier()
+	authURL, _ := url.Parse(provider.AuthCodeURL("state", "nonce-1", verifier))
+	challenge := authURL.Query().Get("code_challenge")
+	claims := ssotest.Claims{Subject: "abc", Email: "Someone@Example.com", EmailVerified: true, Name: "Someone"}
+
+	t.Run("valid code", func(t *testing.T) {
+		identity, err := provider.Exchange(context.Background(), issuer.Authorize("nonce-1", challenge, claims), verifier, "nonce-1")
+		assert.NoError(t, err)
+		assert.Equal(t, &sso.Identity{Provider: "mock", Subject: "abc", Email: "someone@example.com", EmailVerified: true, Name: "Someone"}, identity)
+	})
+
+	t.Run("wrong PKCE verifier", func(t *testing.T) {
+		_, err := provider.Exchange(context.Background(), issuer.Authorize("nonce-1", challenge, claims), oauth2.GenerateVerifier(), "nonce-1")
+		assert.Error(t, err)
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		_, err := provider.Exchange(context.Background(), issuer.Authorize("nonce-2", challenge, claims), verifier, "nonce-1")
+		assert.Error(t, err)
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	_, err := sso.NewRegistry().Get("missing")
+	assert.ErrorIs(t, err, sso.ErrUnknownProvider)
+}
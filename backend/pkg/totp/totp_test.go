@@ -0,0 +1,61 @@
+package totp_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"swamp/pkg/totp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 appendix B test vectors for SHA1 (8 digits truncated to 6)
+func TestCodeAtRFCVectors(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // base32("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := totp.CodeAt(secret, totp.Step(time.Unix(tt.unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	assert.NoError(t, err)
+
+	now := time.Now()
+	code, _ := totp.CodeAt(secret, totp.Step(now))
+
+	step, ok := totp.Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, totp.Step(now), step)
+
+	_, ok = totp.Validate(secret, code, now.Add(30*time.Second))
+	assert.True(t, ok, "previous step is accepted for clock drift")
+
+	_, ok = totp.Validate(secret, code, now.Add(5*time.Minute))
+	assert.False(t, ok)
+
+	_, ok = totp.Validate(secret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri := totp.URI("ABC", "The Swamp", "user@example.com")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/The%20Swamp:user@example.com?"))
+	assert.Contains(t, uri, "secret=ABC")
+	assert.Contains(t, uri, "issuer=The+Swamp")
+}
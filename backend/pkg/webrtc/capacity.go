@@ -0,0 +1,205 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Peer roles. Publishers join through the room socket and count against the
+// swamp's MaxParticipants; viewers join through the stream socket and are
+// not capped.
+const (
+	RolePublisher = "publisher"
+	RoleViewer    = "viewer"
+)
+
+// waitlistUpdateInterval is how often waiting clients are told their place
+// in line. A failed write means they have gone.
+const waitlistUpdateInterval = 10 * time.Second
+
+// RoomStatus is the payload of the "room_full" and "waitlist" events.
+type RoomStatus struct {
+	MaxParticipants int  `json:"maxParticipants"`
+	Publishers      int  `json:"publishers"`
+	Viewers         int  `json:"viewers"`
+	Waitlisted      bool `json:"waitlisted"`
+	Position        int  `json:"position,omitempty"`
+}
+
+// Waiter is a publisher queued for a slot in a full room.
+type Waiter struct {
+	peer  PeerConnectionState
+	ready chan bool
+}
+
+// Ready receives true once the waiter has been admitted, or false if the
+// room closed first.
+func (w *Waiter) Ready() <-chan bool {
+	return w.ready
+}
+
+// Counts returns how many publishers and viewers are connected.
+func (p *Peers) Counts() (publishers, viewers int) {
+	p.ListLock.RLock()
+	defer p.ListLock.RUnlock()
+	return p.counts()
+}
+
+// Occupancy returns the publishers and viewers in the live room for uuid,
+// or zeros when there is none.
+func Occupancy(uuid string) (publishers, viewers int) {
+	RoomsLock.RLock()
+	room := Rooms[uuid]
+	RoomsLock.RUnlock()
+	if room == nil {
+		return 0, 0
+	}
+	return room.Peers.Counts()
+}
+
+func (p *Peers) counts() (publishers, viewers int) {
+	for _, conn := range p.Connections {
+		if conn.Role == RoleViewer {
+			viewers++
+		} else {
+			publishers++
+		}
+	}
+	return publishers, viewers
+}
+
+// Remove drops the connection using pc from the room.
+func (p *Peers) Remove(pc *webrtc.PeerConnection) {
+	p.ListLock.Lock()
+	defer p.ListLock.Unlock()
+	p.remove(pc)
+}
+
+func (p *Peers) remove(pc *webrtc.PeerConnection) {
+	for i := range p.Connections {
+		if p.Connections[i].PeerConnection == pc {
+			p.Connections = append(p.Connections[:i], p.Connections[i+1:]...)
+			break
+		}
+	}
+	for i, waiter := range p.waitlist {
+		if waiter.peer.PeerConnection == pc {
+			p.waitlist = append(p.waitlist[:i], p.waitlist[i+1:]...)
+			break
+		}
+	}
+}
+
+// Join adds a publisher unless the room is at MaxParticipants. Swamp
+// moderators are always let in so a full room cannot lock out its host.
+// When the room is full the returned status is non-nil; with wait set the
+// peer is queued and the returned Waiter says when it gets a slot.
+func (r *Room) Join(peer PeerConnectionState, wait bool) (*Waiter, *RoomStatus) {
+	p := r.Peers
+	p.ListLock.Lock()
+	defer p.ListLock.Unlock()
+
+	if p.closed {
+		return nil, &RoomStatus{MaxParticipants: r.MaxParticipants}
+	}
+	if peer.Identity.Moderator || (len(p.waitlist) == 0 && r.hasSlot()) {
+		p.Connections = append(p.Connections, peer)
+		return nil, nil
+	}
+
+	status := r.status()
+	if !wait {
+		return nil, &status
+	}
+	waiter := &Waiter{peer: peer, ready: make(chan bool, 1)}
+	p.waitlist = append(p.waitlist, waiter)
+	status.Waitlisted = true
+	status.Position = len(p.waitlist)
+	return waiter, &status
+}
+
+// Leave removes a publisher or waiter and hands any freed slot to the next
+// person on the waitlist. Calling it more than once is harmless.
+func (r *Room) Leave(pc *webrtc.PeerConnection) {
+	p := r.Peers
+	p.ListLock.Lock()
+	defer p.ListLock.Unlock()
+
+	p.remove(pc)
+	r.admitWaiters()
+}
+
+// SetMaxParticipants changes the publisher limit, e.g. after the owner edits
+// the swamp, admitting waiters if it grew.
+func (r *Room) SetMaxParticipants(max int) {
+	r.Peers.ListLock.Lock()
+	defer r.Peers.ListLock.Unlock()
+
+	r.MaxParticipants = max
+	r.admitWaiters()
+}
+
+// Await blocks until waiter is admitted, telling the client its place in
+// line meanwhile. It reports false if the client left or the room closed.
+func (r *Room) Await(ws *ThreadSafeWriter, waiter *Waiter) bool {
+	ticker := time.NewTicker(waitlistUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case admitted := <-waiter.ready:
+			if admitted {
+				ws.WriteJSON(&websocketMessage{Event: "admitted"})
+			} else {
+				r.Peers.ListLock.RLock()
+				message := r.Peers.closeMessage
+				r.Peers.ListLock.RUnlock()
+				ws.WriteJSON(message)
+			}
+			return admitted
+		case <-ticker.C:
+			r.Peers.ListLock.RLock()
+			status := r.status()
+			status.Waitlisted = true
+			for i, queued := range r.Peers.waitlist {
+				if queued == waiter {
+					status.Position = i + 1
+				}
+			}
+			r.Peers.ListLock.RUnlock()
+
+			if err := ws.WriteJSON(statusMessage("waitlist", status)); err != nil {
+				r.Leave(waiter.peer.PeerConnection)
+				return false
+			}
+		}
+	}
+}
+
+// hasSlot and the helpers below expect Peers.ListLock to be held.
+func (r *Room) hasSlot() bool {
+	publishers, _ := r.Peers.counts()
+	return r.MaxParticipants <= 0 || publishers < r.MaxParticipants
+}
+
+func (r *Room) status() RoomStatus {
+	publishers, viewers := r.Peers.counts()
+	return RoomStatus{MaxParticipants: r.MaxParticipants, Publishers: publishers, Viewers: viewers}
+}
+
+func (r *Room) admitWaiters() {
+	p := r.Peers
+	for len(p.waitlist) > 0 && r.hasSlot() {
+		next := p.waitlist[0]
+		p.waitlist = p.waitlist[1:]
+		p.Connections = append(p.Connections, next.peer)
+		next.ready <- true
+	}
+}
+
+func statusMessage(event string, status RoomStatus) *websocketMessage {
+	data, _ := json.Marshal(status)
+	return &websocketMessage{Event: event, Data: string(data)}
+}
@@ -0,0 +1,80 @@
+package webrtc_test
+
+import (
+	"testing"
+
+	"swamp/pkg/auth"
+	w "swamp/pkg/webrtc"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPeer(t *testing.T, userID uint, role string) w.PeerConnectionState {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	assert.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	return w.PeerConnectionState{PeerConnection: pc, Identity: auth.Identity{UserID: userID}, Role: role}
+}
+
+func TestRoomCapacity(t *testing.T) {
+	room := &w.Room{UUID: "capped", MaxParticipants: 2, Peers: &w.Peers{}}
+
+	first := newPeer(t, 1, w.RolePublisher)
+	second := newPeer(t, 2, w.RolePublisher)
+	for _, peer := range []w.PeerConnectionState{first, second} {
+		waiter, full := room.Join(peer, false)
+		assert.Nil(t, waiter)
+		assert.Nil(t, full)
+	}
+
+	// Viewers are counted separately and never take a publisher slot
+	room.Peers.Connections = append(room.Peers.Connections, newPeer(t, 9, w.RoleViewer))
+	publishers, viewers := room.Peers.Counts()
+	assert.Equal(t, 2, publishers)
+	assert.Equal(t, 1, viewers)
+
+	t.Run("full room refuses without a waitlist", func(t *testing.T) {
+		waiter, full := room.Join(newPeer(t, 3, w.RolePublisher), false)
+		assert.Nil(t, waiter)
+		if assert.NotNil(t, full) {
+			assert.Equal(t, w.RoomStatus{MaxParticipants: 2, Publishers: 2, Viewers: 1}, *full)
+		}
+	})
+
+	t.Run("waitlist admits in order when a slot frees", func(t *testing.T) {
+		fourth, fifth := newPeer(t, 4, w.RolePublisher), newPeer(t, 5, w.RolePublisher)
+		waiter4, full := room.Join(fourth, true)
+		assert.Equal(t, 1, full.Position)
+		waiter5, full := room.Join(fifth, true)
+		assert.Equal(t, 2, full.Position)
+		assert.True(t, full.Waitlisted)
+
+		room.Leave(first.PeerConnection)
+		assert.True(t, <-waiter4.Ready())
+		assert.Empty(t, waiter5.Ready())
+
+		room.Leave(first.PeerConnection)
+		assert.Empty(t, waiter5.Ready())
+
+		room.SetMaxParticipants(3)
+		assert.True(t, <-waiter5.Ready())
+		publishers, _ := room.Peers.Counts()
+		assert.Equal(t, 3, publishers)
+	})
+
+	t.Run("moderators skip the limit", func(t *testing.T) {
+		host := newPeer(t, 6, w.RolePublisher)
+		host.Identity.Moderator = true
+		waiter, full := room.Join(host, false)
+		assert.Nil(t, waiter)
+		assert.Nil(t, full)
+	})
+
+	t.Run("closing turns waiters away", func(t *testing.T) {
+		waiter, _ := room.Join(newPeer(t, 7, w.RolePublisher), true)
+		room.Peers.Connections = nil // the test peers have no sockets to notify
+		room.Peers.Close("swamp_ended", "done")
+		assert.False(t, <-waiter.Ready())
+	})
+}
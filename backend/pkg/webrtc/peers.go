@@ -10,6 +10,7 @@ import (
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 
+	"swamp/pkg/auth"
 	"swamp/pkg/chat"
 )
 
@@ -38,20 +39,72 @@ var (
 	}
 )
 
+// Room is the in-memory state of a live swamp. SwampID and MaxParticipants
+// are copied from the swamp when the room opens; a zero MaxParticipants
+// means no limit.
 type Room struct {
-	Peers *Peers
-	Hub   *chat.Hub
+	UUID            string
+	SwampID         uint
+	MaxParticipants int
+	Peers           *Peers
+	Hub             *chat.Hub
+}
+
+// Kick removes a user from the room. The chat hub's OnKick hook drops their
+// media connections as well; rooms without one drop them directly.
+func (r *Room) Kick(userID uint) {
+	if r.Hub != nil && r.Hub.OnKick != nil {
+		r.Hub.Kick(userID)
+		return
+	}
+	r.Peers.Kick(userID)
+}
+
+// Close tells everyone in the room why it is ending and disconnects them.
+func (r *Room) Close(event, reason string) {
+	if r.Hub != nil {
+		final, _ := json.Marshal(&websocketMessage{Event: event, Data: reason})
+		r.Hub.Close(final)
+	}
+	r.Peers.Close(event, reason)
+}
+
+// CloseRoom removes the room for uuid, along with its stream entry, and
+// closes it. It reports whether the room was live.
+func CloseRoom(uuid, event, reason string) bool {
+	RoomsLock.Lock()
+	room := Rooms[uuid]
+	if room != nil {
+		delete(Rooms, uuid)
+		for suuid, stream := range Streams {
+			if stream == room {
+				delete(Streams, suuid)
+			}
+		}
+	}
+	RoomsLock.Unlock()
+
+	if room == nil {
+		return false
+	}
+	room.Close(event, reason)
+	return true
 }
 
 type Peers struct {
-	ListLock    sync.RWMutex
-	Connections []PeerConnectionState
-	TrackLocals map[string]*webrtc.TrackLocalStaticRTP
+	ListLock     sync.RWMutex
+	Connections  []PeerConnectionState
+	TrackLocals  map[string]*webrtc.TrackLocalStaticRTP
+	closed       bool
+	closeMessage *websocketMessage // sent to waiters turned away by Close
+	waitlist     []*Waiter
 }
 
 type PeerConnectionState struct {
 	PeerConnection *webrtc.PeerConnection
 	Websocket      *ThreadSafeWriter
+	Identity       auth.Identity
+	Role           string // RolePublisher or RoleViewer
 }
 
 type ThreadSafeWriter struct {
@@ -65,6 +118,56 @@ func (t *ThreadSafeWriter) WriteJSON(v interface{}) error {
 	return t.Conn.WriteJSON(v)
 }
 
+// Kick closes every peer connection belonging to userID after telling the
+// client why, and returns how many were closed.
+func (p *Peers) Kick(userID uint) int {
+	p.ListLock.RLock()
+	var kicked []PeerConnectionState
+	for _, conn := range p.Connections {
+		if conn.Identity.UserID == userID {
+			kicked = append(kicked, conn)
+		}
+	}
+	p.ListLock.RUnlock()
+
+	for _, conn := range kicked {
+		conn.Websocket.WriteJSON(&websocketMessage{Event: "kicked", Data: "You were removed from the room"})
+		conn.PeerConnection.Close()
+		conn.Websocket.Conn.Close()
+	}
+	return len(kicked)
+}
+
+// Close sends event to every peer and closes their connections. Viewers
+// polling IsClosed stop as well, and anyone on the waitlist is turned away
+// by Room.Await.
+func (p *Peers) Close(event, reason string) {
+	message := &websocketMessage{Event: event, Data: reason}
+
+	p.ListLock.Lock()
+	p.closed = true
+	p.closeMessage = message
+	conns := append([]PeerConnectionState(nil), p.Connections...)
+	for _, waiter := range p.waitlist {
+		waiter.ready <- false
+	}
+	p.waitlist = nil
+	p.ListLock.Unlock()
+
+	for _, conn := range conns {
+		conn.Websocket.WriteJSON(message)
+		conn.PeerConnection.Close()
+		conn.Websocket.Conn.Close()
+	}
+}
+
+// IsClosed reports whether Close has been called.
+func (p *Peers) IsClosed() bool {
+	p.ListLock.RLock()
+	defer p.ListLock.RUnlock()
+	return p.closed
+}
+
 func (p *Peers) AddTrack(t *webrtc.TrackRemote) *webrtc.TrackLocalStaticRTP {
 	p.ListLock.Lock()
 	defer func() {
@@ -8,9 +8,16 @@ import (
 
 	"github.com/gofiber/websocket/v2"
 	"github.com/pion/webrtc/v3"
+
+	"swamp/pkg/auth"
 )
 
-func RoomConn(c *websocket.Conn, p *Peers) {
+// RoomConn serves a publisher. Joining a full room sends a "room_full"
+// event and closes the socket, unless wait is set, in which case the
+// publisher is queued until a slot frees.
+func RoomConn(c *websocket.Conn, room *Room, identity auth.Identity, wait bool) {
+	p := room.Peers
+
 	var config webrtc.Configuration
 	if os.Getenv("ENVIRONMENT") == "PRODUCTION" {
 		config = turnConfig
@@ -36,14 +43,10 @@ func RoomConn(c *websocket.Conn, p *Peers) {
 		Websocket: &ThreadSafeWriter{
 			Conn:  c,
 			Mutex: sync.Mutex{},
-		}}
-
-	// Add our new PeerConnection to global list
-	p.ListLock.Lock()
-	p.Connections = append(p.Connections, newPeer)
-	p.ListLock.Unlock()
-
-	log.Println(p.Connections)
+		},
+		Identity: identity,
+		Role:     RolePublisher,
+	}
 
 	// Trickle ICE. Emit server candidate to client
 	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
@@ -98,6 +101,23 @@ func RoomConn(c *websocket.Conn, p *Peers) {
 		}
 	})
 
+	// Add our new PeerConnection to global list once there is room for it
+	waiter, full := room.Join(newPeer, wait)
+	defer room.Leave(peerConnection)
+	if full != nil {
+		if p.IsClosed() {
+			return
+		}
+		if err := newPeer.Websocket.WriteJSON(statusMessage("room_full", *full)); err != nil || waiter == nil {
+			return
+		}
+		if !room.Await(newPeer.Websocket, waiter) {
+			return
+		}
+	}
+
+	log.Println(p.Connections)
+
 	p.SignalPeerConnections()
 	message := &websocketMessage{}
 	for {
@@ -8,9 +8,11 @@ import (
 
 	"github.com/gofiber/websocket/v2"
 	"github.com/pion/webrtc/v3"
+
+	"swamp/pkg/auth"
 )
 
-func StreamConn(c *websocket.Conn, p *Peers) {
+func StreamConn(c *websocket.Conn, p *Peers, identity auth.Identity) {
 	var config webrtc.Configuration
 	if os.Getenv("ENVIRONMENT") == "PRODUCTION" {
 		config = turnConfig
@@ -36,11 +38,15 @@ func StreamConn(c *websocket.Conn, p *Peers) {
 		Websocket: &ThreadSafeWriter{
 			Conn:  c,
 			Mutex: sync.Mutex{},
-		}}
+		},
+		Identity: identity,
+		Role:     RoleViewer,
+	}
 
 	p.ListLock.Lock()
 	p.Connections = append(p.Connections, newPeer)
 	p.ListLock.Unlock()
+	defer p.Remove(peerConnection)
 
 	log.Println(p.Connections)
 
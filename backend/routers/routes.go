@@ -4,6 +4,8 @@ import (
 	"swamp/controllers"
 	"swamp/handlers"
 	"swamp/middleware"
+	"swamp/models"
+	"swamp/pkg/policy"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -15,23 +17,112 @@ import (
 // SetupRoutes initializes all API routes
 func SetupRoutes(r *chi.Mux) {
 
-	// Apply auth middleware to all routes in this group
+	// Validate request payloads for all routes
 	r.Use(middleware.ValidateRequest)
 
-	// Protected endpoints
+	// Public endpoints
 	r.Post("/api/request-otp", controllers.RequestOTP)
 	r.Post("/api/verify-otp", controllers.VerifyOTP)
 	r.Post("/api/login", controllers.Login)
+	r.Post("/api/login/2fa", controllers.LoginTwoFactor)
+	r.Post("/api/password/forgot", controllers.ForgotPassword)
+	r.Post("/api/password/reset", controllers.ResetPassword)
+	r.Post("/api/token/refresh", controllers.RefreshSession)
+	r.Post("/api/logout", controllers.Logout)
 
-	// Swamp routes
-	r.Post("/api/swamp", controllers.CreateSwamp)
-	r.Get("/api/swamp", controllers.GetSwamps)
-	r.Get("/api/swamp/{id}", controllers.GetSwampByID)
+	// Single sign-on through configured OpenID Connect providers
+	r.Get("/api/auth/oidc/providers", controllers.ListSSOProviders)
+	r.Get("/api/auth/oidc/{provider}/authorize", controllers.StartSSOLogin)
+	r.Post("/api/auth/oidc/{provider}/callback", controllers.FinishSSOLogin)
 
-	r.Post("/api/topics",           controllers.CreateTopic)
-	r.Get( "/api/topics",           controllers.ListTopics)
-	r.Get( "/api/user/{userID}/topics", controllers.GetUserTopics)
-	r.Post("/api/user/{userID}/topics", controllers.SetUserTopics)
+	// Subscribable calendar feeds; the secret token in the URL is the credential
+	r.Get("/api/calendar/{token}.ics", controllers.CalendarFeed)
+
+	// Endpoints scripts may also call with a personal API key holding the scope
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuthOrAPIKey(models.APIScopeSwampsRead))
+
+		r.Get("/api/swamp", controllers.GetSwamps)
+		r.Get("/api/swamp/occurrences", controllers.ListOccurrences)
+		r.Get("/api/swamp/{id}", controllers.GetSwampByID)
+		r.Get("/api/swamp/{id}/occurrences", controllers.ListSwampOccurrences)
+		r.Get("/api/swamp/{id}/calendar.ics", controllers.GetSwampCalendar)
+		r.Get("/api/topics", controllers.ListTopics)
+	})
+	r.With(middleware.RequireAuthOrAPIKey(models.APIScopeSwampsWrite)).
+		Post("/api/swamp", controllers.CreateSwamp)
+
+	// Endpoints below require a valid access token
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth)
+
+		r.Post("/api/logout/all", controllers.LogoutAll)
+
+		// Two-factor authentication management
+		r.Post("/api/2fa/setup", controllers.SetupTwoFactor)
+		r.Post("/api/2fa/confirm", controllers.ConfirmTwoFactor)
+		r.Post("/api/2fa/disable", controllers.DisableTwoFactor)
+		r.Post("/api/2fa/recovery-codes", controllers.RegenerateRecoveryCodes)
+
+		// Account self-service
+		r.Get("/api/me", controllers.GetMe)
+		r.Patch("/api/me", controllers.UpdateMe)
+		r.Delete("/api/me", controllers.DeleteMe)
+		r.Post("/api/me/email", controllers.RequestEmailChange)
+		r.Post("/api/me/email/verify", controllers.ConfirmEmailChange)
+		r.Post("/api/me/password", controllers.ChangePassword)
+		r.Get("/api/me/feed", controllers.GetFeed)
+
+		// Personal data exports, built in the background
+		r.Post("/api/me/exports", controllers.RequestDataExport)
+		r.Get("/api/me/exports", controllers.ListDataExports)
+		r.Get("/api/me/exports/{id}", controllers.GetDataExport)
+		r.Get("/api/me/exports/{id}/download", controllers.DownloadDataExport)
+
+		// Personal API keys; managed with a login session only
+		r.Post("/api/me/api-keys", controllers.CreateAPIKey)
+		r.Get("/api/me/api-keys", controllers.ListAPIKeys)
+		r.Delete("/api/me/api-keys/{id}", controllers.RevokeAPIKey)
+
+		// Calendar: RSVPs and the personal feed URL
+		r.Put("/api/swamp/{id}/rsvp", controllers.RSVPSwamp)
+		r.Delete("/api/swamp/{id}/rsvp", controllers.CancelRSVP)
+		r.Get("/api/me/calendar-feed", controllers.GetCalendarFeed)
+		r.Post("/api/me/calendar-feed", controllers.CreateCalendarFeed)
+		r.Delete("/api/me/calendar-feed", controllers.DeleteCalendarFeed)
+
+		// Per-swamp roles and moderation; checked against the swamp in the controller
+		r.Patch("/api/swamp/{id}", controllers.UpdateSwamp)
+		r.Delete("/api/swamp/{id}", controllers.DeleteSwamp)
+		r.Put("/api/swamp/{id}/passcode", controllers.SetSwampPasscode)
+		r.Delete("/api/swamp/{id}/passcode", controllers.ClearSwampPasscode)
+		r.Patch("/api/swamp/{id}/occurrences/{start}", controllers.UpdateOccurrence)
+		r.Delete("/api/swamp/{id}/occurrences/{start}", controllers.CancelOccurrence)
+		r.Get("/api/swamp/{id}/co-hosts", controllers.ListCoHosts)
+		r.Post("/api/swamp/{id}/co-hosts", controllers.AddCoHost)
+		r.Delete("/api/swamp/{id}/co-hosts/{userID}", controllers.RemoveCoHost)
+		r.Post("/api/swamp/{id}/participants/{userID}/remove", controllers.RemoveParticipant)
+
+		// Invitations to private swamps, by user, email or shareable link
+		r.Get("/api/swamp/{id}/invitations", controllers.ListInvitations)
+		r.Post("/api/swamp/{id}/invitations", controllers.CreateInvitation)
+		r.Delete("/api/swamp/{id}/invitations/{invitationID}", controllers.DeleteInvitation)
+		r.Get("/api/swamp/{id}/invite-links", controllers.ListInviteLinks)
+		r.Post("/api/swamp/{id}/invite-links", controllers.CreateInviteLink)
+		r.Delete("/api/swamp/{id}/invite-links/{linkID}", controllers.RevokeInviteLink)
+		r.Post("/api/invite-links/{token}/accept", controllers.AcceptInviteLink)
+
+		r.With(middleware.RequirePermission(policy.CreateTopic)).
+			Post("/api/topics", controllers.CreateTopic)
+		r.With(middleware.RequirePermission(policy.ModerateTopic)).
+			Delete("/api/topics/{id}", controllers.DeleteTopic)
+		r.Get( "/api/user/{userID}/topics", controllers.GetUserTopics)
+		r.With(middleware.RequireSelfOrAdmin("userID")).
+			Post("/api/user/{userID}/topics", controllers.SetUserTopics)
+
+		r.With(middleware.RequirePermission(policy.ManageRoles)).
+			Put("/api/admin/users/{userID}/role", controllers.SetUserRole)
+	})
 
 }
 
@@ -40,16 +131,18 @@ func SetupFiberRoutes(app *fiber.App, db *gorm.DB) {
 	// For now, we will just use Chi for the main API
 	// You can also set up Fiber-specific routes here
 
-	app.Get("/room/:uuid/websocket", websocket.New(handlers.RoomWebsocket, websocket.Config{
+	// Every socket authenticates at handshake time (or with its first message)
+	app.Get("/room/:uuid/websocket", handlers.SocketAuth, websocket.New(handlers.RoomWebsocket, websocket.Config{
 		HandshakeTimeout: 10 * time.Second,
 	}))
 
-	app.Get("/room/:uuid/chat/websocket", websocket.New(handlers.RoomChatWebsocket))
-	app.Get("/room/:uuid/viewer/websocket", websocket.New(handlers.RoomViewerWebsocket))
+	// Chat also accepts personal API keys with the chat:write scope
+	app.Get("/room/:uuid/chat/websocket", handlers.ChatSocketAuth, websocket.New(handlers.RoomChatWebsocket))
+	app.Get("/room/:uuid/viewer/websocket", handlers.SocketAuth, websocket.New(handlers.RoomViewerWebsocket))
 
-	app.Get("/stream/:suuid/websocket", websocket.New(handlers.StreamWebsocket, websocket.Config{
+	app.Get("/stream/:suuid/websocket", handlers.SocketAuth, websocket.New(handlers.StreamWebsocket, websocket.Config{
 		HandshakeTimeout: 10 * time.Second,
 	}))
-	app.Get("/stream/:suuid/chat/websocket", websocket.New(handlers.StreamChatWebsocket))
-	app.Get("/stream/:suuid/viewer/websocket", websocket.New(handlers.StreamViewerWebsocket))
+	app.Get("/stream/:suuid/chat/websocket", handlers.ChatSocketAuth, websocket.New(handlers.StreamChatWebsocket))
+	app.Get("/stream/:suuid/viewer/websocket", handlers.SocketAuth, websocket.New(handlers.StreamViewerWebsocket))
 }